@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// AlbumIndex is an in-memory index of album membership built from a single
+// listing of every album. It answers album lookups without a request per
+// asset and is kept up to date as the run adds assets to albums.
+type AlbumIndex struct {
+	mu      sync.RWMutex
+	albums  map[string]immich.Album    // Albums by ID, without their assets
+	byAsset map[string]map[string]bool // Album IDs by asset ID
+}
+
+// newAlbumIndex returns an index of albums, which must include their assets
+func newAlbumIndex(albums []immich.Album) *AlbumIndex {
+	index := &AlbumIndex{
+		albums:  make(map[string]immich.Album, len(albums)),
+		byAsset: make(map[string]map[string]bool),
+	}
+	for _, album := range albums {
+		assets := album.Assets
+		album.Assets = nil
+		index.albums[album.ID] = album
+
+		assetIDs := make([]string, len(assets))
+		for i, asset := range assets {
+			assetIDs[i] = asset.ID
+		}
+		index.link(album.ID, assetIDs)
+	}
+	return index
+}
+
+// loadAlbumIndex lists every album of the server and fetches its assets
+func loadAlbumIndex(config *Config) (*AlbumIndex, error) {
+	summaries, err := config.api().GetAlbums(config.context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list albums: %w", err)
+	}
+
+	albums := make([]immich.Album, 0, len(summaries))
+	for _, summary := range summaries {
+		album, err := config.api().GetAlbum(config.context(), summary.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch album %s: %w", truncateID(summary.ID), err)
+		}
+		albums = append(albums, *album)
+	}
+
+	return newAlbumIndex(albums), nil
+}
+
+// AlbumsForAsset returns the albums containing an asset, sorted by name
+func (x *AlbumIndex) AlbumsForAsset(assetID string) []immich.Album {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	albums := make([]immich.Album, 0, len(x.byAsset[assetID]))
+	for albumID := range x.byAsset[assetID] {
+		albums = append(albums, x.albums[albumID])
+	}
+	sort.Slice(albums, func(i, j int) bool {
+		if albums[i].AlbumName != albums[j].AlbumName {
+			return albums[i].AlbumName < albums[j].AlbumName
+		}
+		return albums[i].ID < albums[j].ID
+	})
+	return albums
+}
+
+// Add records assets added to an album. A nil index records nothing.
+func (x *AlbumIndex) Add(albumID string, assetIDs []string) {
+	if x == nil {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.link(albumID, assetIDs)
+}
+
+// Remove records assets removed from an album. A nil index records nothing.
+func (x *AlbumIndex) Remove(albumID string, assetIDs []string) {
+	if x == nil {
+		return
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, assetID := range assetIDs {
+		delete(x.byAsset[assetID], albumID)
+	}
+}
+
+// Len returns the number of indexed albums and of assets in at least one album
+func (x *AlbumIndex) Len() (albums, assets int) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	for _, albumIDs := range x.byAsset {
+		if len(albumIDs) > 0 {
+			assets++
+		}
+	}
+	return len(x.albums), assets
+}
+
+// link adds assets to an album; the caller holds the write lock
+func (x *AlbumIndex) link(albumID string, assetIDs []string) {
+	if _, ok := x.albums[albumID]; !ok {
+		x.albums[albumID] = immich.Album{ID: albumID}
+	}
+	for _, assetID := range assetIDs {
+		if x.byAsset[assetID] == nil {
+			x.byAsset[assetID] = make(map[string]bool)
+		}
+		x.byAsset[assetID][albumID] = true
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// TestLoadAlbumIndex tests building the index from the album listing
+func TestLoadAlbumIndex(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	responses := map[string]string{
+		"/api/albums":        `[{"id": "album1", "albumName": "Vacation"}, {"id": "album2", "albumName": "Family"}]`,
+		"/api/albums/album1": `{"id": "album1", "albumName": "Vacation", "assets": [{"id": "asset1"}, {"id": "asset2"}]}`,
+		"/api/albums/album2": `{"id": "album2", "albumName": "Family", "assets": [{"id": "asset1"}]}`,
+	}
+	requests := 0
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			requests++
+			body, ok := responses[req.URL.Path]
+			if req.Method != "GET" || !ok || req.URL.RawQuery != "" {
+				t.Errorf("Unexpected request %s %s", req.Method, req.URL)
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+			}, nil
+		},
+	}
+
+	index, err := loadAlbumIndex(&Config{ImmichURL: "http://localhost:2283", APIKey: "test-key"})
+	if err != nil {
+		t.Fatalf("loadAlbumIndex() error = %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("loadAlbumIndex() sent %d requests, want 3", requests)
+	}
+	if albums, assets := index.Len(); albums != 2 || assets != 2 {
+		t.Errorf("Len() = %d albums, %d assets, want 2, 2", albums, assets)
+	}
+
+	albums := index.AlbumsForAsset("asset1")
+	if got := albumNames(albums); !reflect.DeepEqual(got, []string{"Family", "Vacation"}) {
+		t.Errorf("AlbumsForAsset(asset1) = %v, want [Family Vacation]", got)
+	}
+	for _, album := range albums {
+		if album.Assets != nil {
+			t.Errorf("AlbumsForAsset() album %s carries its assets", album.ID)
+		}
+	}
+	if got := index.AlbumsForAsset("unknown"); len(got) != 0 {
+		t.Errorf("AlbumsForAsset(unknown) = %v, want none", got)
+	}
+}
+
+// TestAlbumIndexAddRemove tests that mutations keep the index coherent
+func TestAlbumIndexAddRemove(t *testing.T) {
+	index := newAlbumIndex([]immich.Album{
+		{ID: "album1", AlbumName: "Vacation", Assets: []immich.Asset{{ID: "asset1"}}},
+	})
+
+	index.Add("album1", []string{"asset2", "asset1"})
+	if got := albumNames(index.AlbumsForAsset("asset2")); !reflect.DeepEqual(got, []string{"Vacation"}) {
+		t.Errorf("AlbumsForAsset(asset2) after Add = %v, want [Vacation]", got)
+	}
+
+	index.Remove("album1", []string{"asset1"})
+	if got := index.AlbumsForAsset("asset1"); len(got) != 0 {
+		t.Errorf("AlbumsForAsset(asset1) after Remove = %v, want none", got)
+	}
+	if albums, assets := index.Len(); albums != 1 || assets != 1 {
+		t.Errorf("Len() = %d albums, %d assets, want 1, 1", albums, assets)
+	}
+
+	var nilIndex *AlbumIndex
+	nilIndex.Add("album1", []string{"asset1"})
+	nilIndex.Remove("album1", []string{"asset1"})
+}
+
+// TestSynchronizeAlbumsWithIndex tests that album lookups are served from the
+// index and that additions are reflected in it
+func TestSynchronizeAlbumsWithIndex(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	added := 0
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if req.Method != "PUT" || req.URL.Path != "/api/albums/album1/assets" {
+				t.Errorf("Unexpected request %s %s", req.Method, req.URL)
+			}
+			added++
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`[]`)),
+			}, nil
+		},
+	}
+
+	config := &Config{
+		ImmichURL: "http://localhost:2283",
+		APIKey:    "test-key",
+		albums: newAlbumIndex([]immich.Album{
+			{ID: "album1", AlbumName: "Vacation", Assets: []immich.Asset{{ID: "asset1"}}},
+		}),
+	}
+	group := immich.DuplicateGroup{
+		DuplicateID: "dup1",
+		Assets:      []immich.DuplicateAsset{{ID: "asset1"}, {ID: "asset2"}},
+	}
+
+	syncCount, _, err := synchronizeAlbums(config, group)
+	if err != nil {
+		t.Fatalf("synchronizeAlbums() error = %v", err)
+	}
+	if syncCount != 1 || added != 1 {
+		t.Errorf("synchronizeAlbums() synced %d asset(s) in %d request(s), want 1 in 1", syncCount, added)
+	}
+	if got := albumNames(config.albums.AlbumsForAsset("asset2")); !reflect.DeepEqual(got, []string{"Vacation"}) {
+		t.Errorf("AlbumsForAsset(asset2) = %v, want [Vacation]", got)
+	}
+
+	// A second pass finds nothing left to add
+	if syncCount, _, _ := synchronizeAlbums(config, group); syncCount != 0 || added != 1 {
+		t.Errorf("second synchronizeAlbums() synced %d asset(s), want 0", syncCount)
+	}
+}
+
+func albumNames(albums []immich.Album) []string {
+	names := make([]string, len(albums))
+	for i, album := range albums {
+		names[i] = album.AlbumName
+	}
+	return names
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+const (
+	// Default number of assets sent per album addition request in batch mode
+	defaultAlbumBatchSize = 500
+)
+
+// AlbumPlan accumulates the album additions of every duplicate group of a
+// run so that they can be sent per album in a few large requests, before any
+// duplicate is resolved.
+type AlbumPlan struct {
+	groupAlbums map[string]map[string][]immich.Album // Album assignments before syncing, by duplicate group
+	additions   map[string][]string                  // Assets to add, by album ID
+	albumNames  map[string]string                    // Album names by ID
+	owners      map[string]string                    // Duplicate group of each asset to add
+	failed      map[string]int                       // Failed additions by duplicate group
+	placed      map[string]map[string][]string       // Assets placed in each album, by duplicate group
+}
+
+// planAlbumAdditions looks up the albums of every duplicate group not
+// completed by a previous run and accumulates the assets missing from them
+func planAlbumAdditions(config *Config, checkpoint *Checkpoint, duplicates []immich.DuplicateGroup) *AlbumPlan {
+	plan := &AlbumPlan{
+		groupAlbums: make(map[string]map[string][]immich.Album),
+		additions:   make(map[string][]string),
+		albumNames:  make(map[string]string),
+		owners:      make(map[string]string),
+		failed:      make(map[string]int),
+		placed:      make(map[string]map[string][]string),
+	}
+
+	for _, group := range duplicates {
+		if len(group.Assets) < 2 || checkpoint.IsDone(group.DuplicateID) {
+			continue
+		}
+
+		assetAlbums := fetchAssetAlbums(config, group)
+		plan.groupAlbums[group.DuplicateID] = assetAlbums
+		for _, albums := range assetAlbums {
+			for _, album := range albums {
+				plan.albumNames[album.ID] = album.AlbumName
+			}
+		}
+		for albumID, assetIDs := range missingAlbumAssets(group, assetAlbums, config.ownerID) {
+			plan.additions[albumID] = append(plan.additions[albumID], assetIDs...)
+			for _, assetID := range assetIDs {
+				plan.owners[assetID] = group.DuplicateID
+			}
+		}
+	}
+
+	return plan
+}
+
+// albumIDs returns the albums with pending additions, sorted by name
+func (p *AlbumPlan) albumIDs() []string {
+	ids := make([]string, 0, len(p.additions))
+	for id := range p.additions {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if p.albumNames[ids[i]] != p.albumNames[ids[j]] {
+			return p.albumNames[ids[i]] < p.albumNames[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// fail records that an asset could not be added, which blocks its group
+func (p *AlbumPlan) fail(assetID string) {
+	p.failed[p.owners[assetID]]++
+}
+
+// place records that assets are in an album after synchronization
+func (p *AlbumPlan) place(albumID string, assetIDs []string) {
+	for _, assetID := range assetIDs {
+		owner := p.owners[assetID]
+		if p.placed[owner] == nil {
+			p.placed[owner] = make(map[string][]string)
+		}
+		p.placed[owner][albumID] = append(p.placed[owner][albumID], assetID)
+	}
+}
+
+// Albums returns the album assignments of a duplicate group found while
+// planning. It fails if any addition of the group failed, so that duplicates
+// are never resolved before their albums are synchronized.
+func (p *AlbumPlan) Albums(duplicateID string) (map[string][]immich.Album, error) {
+	if failed := p.failed[duplicateID]; failed > 0 {
+		return nil, fmt.Errorf("%d album addition(s) failed; duplicates left untouched", failed)
+	}
+	return p.groupAlbums[duplicateID], nil
+}
+
+// flushAlbumPlan sends the planned additions of every album in batches of at
+// most config.BatchSize assets and returns the number of assets added.
+// Assets that could not be added are recorded as failures of their group.
+func flushAlbumPlan(config *Config, plan *AlbumPlan) int {
+	batchSize := config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultAlbumBatchSize
+	}
+
+	total := 0
+	for _, albumID := range plan.albumIDs() {
+		assetIDs := plan.additions[albumID]
+		name := plan.albumNames[albumID]
+		batches := (len(assetIDs) + batchSize - 1) / batchSize
+
+		if config.DryRun {
+			config.logInfo("   [DRY RUN] Would add %d asset(s) to album %q in %d request(s)", len(assetIDs), name, batches)
+			plan.place(albumID, assetIDs)
+			total += len(assetIDs)
+			continue
+		}
+
+		added, present, failed := 0, 0, 0
+		for start := 0; start < len(assetIDs); start += batchSize {
+			batch := assetIDs[start:min(start+batchSize, len(assetIDs))]
+
+			addition, err := addAssetsToAlbum(config, albumID, batch)
+			if err != nil {
+				config.logError("❌ Failed to add %d asset(s) to album %q: %v", len(batch), name, err)
+				for _, assetID := range batch {
+					plan.fail(assetID)
+				}
+				failed += len(batch)
+				continue
+			}
+
+			for _, result := range addition.Failed {
+				config.logWarning("⚠️  Could not add asset %s to album %q: %s", truncateID(result.ID), name, result.Error)
+				plan.fail(result.ID)
+			}
+			plan.place(albumID, addition.Added)
+			plan.place(albumID, addition.Present)
+			added += len(addition.Added)
+			present += len(addition.Present)
+			failed += len(addition.Failed)
+		}
+
+		if failed > 0 {
+			config.logWarning("⚠️  Album %q: %d added, %d already present, %d failed (%d request(s))", name, added, present, failed, batches)
+		} else {
+			config.logInfo("✅ Album %q: %d added, %d already present (%d request(s))", name, added, present, batches)
+		}
+		total += added
+	}
+
+	return total
+}
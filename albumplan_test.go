@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// TestAlbumPlanBatches tests that additions of all groups are planned per
+// album, sent in size-limited batches, and that partial failures block only
+// the groups they belong to
+func TestAlbumPlanBatches(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	var batches [][]string
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := `[]`
+			switch {
+			case req.Method == "GET" && strings.HasSuffix(req.URL.Query().Get("assetId"), "-a"):
+				body = `[{"id": "album1", "albumName": "Vacation"}]`
+			case req.Method == "PUT" && req.URL.Path == "/api/albums/album1/assets":
+				var request immich.BulkIDsRequest
+				if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+					t.Fatalf("Failed to decode request: %v", err)
+				}
+				batches = append(batches, request.IDs)
+
+				results := []immich.BulkIDResult{}
+				for _, id := range request.IDs {
+					switch id {
+					case "dup2-b":
+						results = append(results, immich.BulkIDResult{ID: id, Error: immich.BulkErrorNoPermission})
+					case "dup3-b":
+						results = append(results, immich.BulkIDResult{ID: id, Error: immich.BulkErrorDuplicate})
+					default:
+						results = append(results, immich.BulkIDResult{ID: id, Success: true})
+					}
+				}
+				encoded, err := json.Marshal(results)
+				if err != nil {
+					t.Fatalf("Failed to encode results: %v", err)
+				}
+				body = string(encoded)
+			case req.Method != "GET":
+				t.Errorf("Unexpected request %s %s", req.Method, req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+			}, nil
+		},
+	}
+
+	duplicates := []immich.DuplicateGroup{}
+	for i := 1; i <= 3; i++ {
+		id := fmt.Sprintf("dup%d", i)
+		duplicates = append(duplicates, immich.DuplicateGroup{
+			DuplicateID: id,
+			Assets:      []immich.DuplicateAsset{{ID: id + "-a"}, {ID: id + "-b"}},
+		})
+	}
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", BatchSize: 2, report: &Report{}}
+
+	plan := planAlbumAdditions(config, nil, duplicates)
+	if got := len(plan.additions["album1"]); got != 3 {
+		t.Fatalf("planAlbumAdditions() planned %d addition(s) to album1, want 3", got)
+	}
+
+	if added := flushAlbumPlan(config, plan); added != 1 {
+		t.Errorf("flushAlbumPlan() = %d, want 1", added)
+	}
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Errorf("flushAlbumPlan() sent batches %v, want sizes [2 1]", batches)
+	}
+	if summary := `Album "Vacation": 1 added, 1 already present, 1 failed (2 request(s))`; !containsString(config.report.Warnings, summary) {
+		t.Errorf("report warnings = %q, want the album summary %q", config.report.Warnings, summary)
+	}
+
+	for _, tt := range []struct {
+		duplicateID string
+		wantErr     bool
+	}{
+		{"dup1", false},
+		{"dup2", true},
+		{"dup3", false},
+	} {
+		albums, err := plan.Albums(tt.duplicateID)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Albums(%s) error = %v, wantErr %v", tt.duplicateID, err, tt.wantErr)
+		}
+		if !tt.wantErr && len(albums[tt.duplicateID+"-a"]) != 1 {
+			t.Errorf("Albums(%s) = %v, want the assignments found while planning", tt.duplicateID, albums)
+		}
+	}
+}
+
+// TestAlbumPlanDryRun tests that a dry run sends no additions
+func TestAlbumPlanDryRun(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if req.Method != "GET" {
+				t.Errorf("Unexpected request %s %s", req.Method, req.URL)
+			}
+			body := `[]`
+			if req.URL.Query().Get("assetId") == "a" {
+				body = `[{"id": "album1", "albumName": "Vacation"}]`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+			}, nil
+		},
+	}
+
+	duplicates := []immich.DuplicateGroup{
+		{DuplicateID: "dup1", Assets: []immich.DuplicateAsset{{ID: "a"}, {ID: "b"}}},
+	}
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", DryRun: true}
+
+	plan := planAlbumAdditions(config, nil, duplicates)
+	if added := flushAlbumPlan(config, plan); added != 1 {
+		t.Errorf("flushAlbumPlan() = %d, want 1", added)
+	}
+}
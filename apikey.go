@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	// redacted replaces secrets in log output and error messages
+	redacted = "[REDACTED]"
+
+	// minSecretLength is the length below which values are not redacted:
+	// they would mangle unrelated text, and Immich API keys are much longer
+	minSecretLength = 8
+)
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string // Values replaced by redacted wherever they are printed
+)
+
+// resolveAPIKey sets config.APIKey from the API key file or command if one
+// is configured. Only one source of the key can be given.
+func resolveAPIKey(config *Config) error {
+	given := 0
+	for _, value := range []string{config.APIKey, config.APIKeyFile, config.APIKeyCommand} {
+		if value != "" {
+			given++
+		}
+	}
+	if given > 1 {
+		return config.settingError(fmt.Errorf("--api-key, --api-key-file and --api-key-command are mutually exclusive"), apiKeySettings...)
+	}
+
+	switch {
+	case config.APIKeyFile != "":
+		key, err := readAPIKeyFile(config.APIKeyFile)
+		if err != nil {
+			return config.settingError(err, "api-key-file")
+		}
+		config.APIKey = key
+	case config.APIKeyCommand != "":
+		key, err := runAPIKeyCommand(config.APIKeyCommand)
+		if err != nil {
+			return config.settingError(err, "api-key-command")
+		}
+		config.APIKey = key
+	}
+	return nil
+}
+
+// readAPIKeyFile reads an API key from the first line of the file at path,
+// warning if other users can read it. A leading ~/ stands for the home
+// directory.
+func readAPIKeyFile(path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API key file: %w", err)
+	}
+	warnReadableSecret(path, "API key file")
+
+	key := firstLine(data)
+	if key == "" {
+		return "", fmt.Errorf("API key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// runAPIKeyCommand runs command with the shell and returns the first line it
+// prints as the API key. The command can prompt on the terminal, for example
+// to unlock a password manager.
+func runAPIKeyCommand(command string) (string, error) {
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	cmd := exec.Command(shell, flag, command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("API key command failed: %w", err)
+	}
+
+	key := firstLine(output)
+	if key == "" {
+		return "", fmt.Errorf("API key command printed no key")
+	}
+	return key, nil
+}
+
+// firstLine returns the first line of data without surrounding whitespace
+func firstLine(data []byte) string {
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	return string(bytes.TrimSpace(line))
+}
+
+// warnReadableSecret warns if the file at path, described by what, can be
+// read by every user of the system
+func warnReadableSecret(path, what string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if info.Mode().Perm()&0o004 != 0 {
+		logWarning("⚠️  %s %s is readable by every user; restrict it with: chmod 600 %s", what, path, path)
+	}
+}
+
+// addSecret registers a value to redact from log output and error messages
+func addSecret(secret string) {
+	if len(secret) < minSecretLength {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, secret)
+}
+
+// redact replaces every registered secret in s
+func redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+// redactingWriter redacts the secrets of every write before passing it on.
+// The log package writes each line at once, so secrets are not split across
+// writes.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (w redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
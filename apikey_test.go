@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestResolveAPIKey tests reading the API key from a file or a command
+func TestResolveAPIKey(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh and Unix permissions")
+	}
+
+	dir := t.TempDir()
+	privateFile := filepath.Join(dir, "private.key")
+	sharedFile := filepath.Join(dir, "shared.key")
+	emptyFile := filepath.Join(dir, "empty.key")
+	for path, mode := range map[string]os.FileMode{privateFile: 0o600, sharedFile: 0o644, emptyFile: 0o600} {
+		content := "file-key\nignored\n"
+		if path == emptyFile {
+			content = "\n"
+		}
+		if err := os.WriteFile(path, []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		config      Config
+		wantKey     string
+		wantErr     string
+		wantWarning bool
+	}{
+		{"key", Config{APIKey: "flag-key"}, "flag-key", "", false},
+		{"private file", Config{APIKeyFile: privateFile}, "file-key", "", false},
+		{"world-readable file", Config{APIKeyFile: sharedFile}, "file-key", "", true},
+		{"empty file", Config{APIKeyFile: emptyFile}, "", "is empty", false},
+		{"missing file", Config{APIKeyFile: filepath.Join(dir, "missing")}, "", "failed to read API key file", false},
+		{"command", Config{APIKeyCommand: "printf '  command-key \\nsecond line'"}, "command-key", "", false},
+		{"failing command", Config{APIKeyCommand: "exit 3"}, "", "API key command failed", false},
+		{"silent command", Config{APIKeyCommand: "true"}, "", "printed no key", false},
+		{"several sources", Config{APIKey: "flag-key", APIKeyFile: privateFile}, "", "mutually exclusive", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logOutput := log.Writer()
+			log.SetOutput(&logs)
+			defer log.SetOutput(logOutput)
+
+			config := tt.config
+			err := resolveAPIKey(&config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("resolveAPIKey() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveAPIKey() error = %v", err)
+			}
+			if config.APIKey != tt.wantKey {
+				t.Errorf("APIKey = %q, want %q", config.APIKey, tt.wantKey)
+			}
+			if warned := strings.Contains(logs.String(), "readable by every user"); warned != tt.wantWarning {
+				t.Errorf("warning logged = %v, want %v (%s)", warned, tt.wantWarning, logs.String())
+			}
+		})
+	}
+}
+
+// TestRedact tests that the API key is removed from log output and reports
+func TestRedact(t *testing.T) {
+	defer func() { secrets = nil }()
+	addSecret("s3cret-key")
+	addSecret("short")
+
+	var buf bytes.Buffer
+	logger := log.New(redactingWriter{w: &buf}, "", 0)
+	logger.Printf("short request failed: HTTP 401: {\"x-api-key\": %q}", "s3cret-key")
+	if got, want := buf.String(), "short request failed: HTTP 401: {\"x-api-key\": \"[REDACTED]\"}\n"; got != want {
+		t.Errorf("log output = %q, want %q", got, want)
+	}
+
+	report := &Report{}
+	report.recordLog(nil, true, "❌ key s3cret-key rejected")
+	if len(report.Errors) != 1 || strings.Contains(report.Errors[0], "s3cret") {
+		t.Errorf("report errors = %v, want the key redacted", report.Errors)
+	}
+}
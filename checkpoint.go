@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Run modes recorded in the checkpoint header
+const (
+	checkpointModeSync       = "sync"
+	checkpointModeAutoDelete = "auto-delete"
+	checkpointModeMerge      = "auto-delete+merge-metadata"
+	checkpointModeStack      = "stack"
+)
+
+// CheckpointHeader is the first line of a checkpoint file. It identifies the
+// server and the mode of the run, since a group completed by a sync-only run
+// still has duplicates to delete or stack.
+type CheckpointHeader struct {
+	Server string `json:"server"`
+	Mode   string `json:"mode"`
+}
+
+// checkpointHeader returns the header of a checkpoint for a run with config
+func checkpointHeader(config *Config) CheckpointHeader {
+	mode := checkpointModeSync
+	switch {
+	case config.AutoDelete && config.Merge:
+		mode = checkpointModeMerge
+	case config.AutoDelete:
+		mode = checkpointModeAutoDelete
+	case config.Stack:
+		mode = checkpointModeStack
+	}
+	return CheckpointHeader{Server: config.ImmichURL, Mode: mode}
+}
+
+// Checkpoint records the duplicate groups fully processed by a run so that an
+// interrupted run can be resumed. The file holds a JSON header line followed
+// by one DuplicateID per line. It is safe for concurrent use, since groups
+// are checked and marked done on different goroutines.
+type Checkpoint struct {
+	mu        sync.Mutex
+	file      *os.File
+	completed map[string]bool
+}
+
+// defaultCheckpointPath returns the checkpoint location in the user config directory
+func defaultCheckpointPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "immich-duplicate-cleaner-checkpoint.jsonl"
+	}
+	return filepath.Join(dir, "immich-duplicate-cleaner", "checkpoint.jsonl")
+}
+
+// loadCheckpoint reads the groups completed by a previous run with the same
+// server and mode as want
+func loadCheckpoint(path string, want CheckpointHeader) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no checkpoint found at %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logError("Failed to close checkpoint: %v", err)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("checkpoint %s is empty", path)
+	}
+
+	var header CheckpointHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint header: %w", err)
+	}
+	if header.Server != want.Server {
+		return nil, fmt.Errorf("checkpoint %s was created for %s, not %s", path, header.Server, want.Server)
+	}
+	if header.Mode != want.Mode {
+		mode := header.Mode
+		if mode == "" {
+			mode = "unknown"
+		}
+		return nil, fmt.Errorf("checkpoint %s was created by a %s run, not %s; run without --resume to start over", path, mode, want.Mode)
+	}
+
+	completed := make(map[string]bool)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			completed[id] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	return completed, nil
+}
+
+// openCheckpoint opens the checkpoint at path for a run described by header.
+// When resume is set, previously completed groups are loaded and kept;
+// otherwise the checkpoint is started afresh.
+func openCheckpoint(path string, header CheckpointHeader, resume bool) (*Checkpoint, error) {
+	completed := make(map[string]bool)
+	if resume {
+		loaded, err := loadCheckpoint(path, header)
+		if err != nil {
+			return nil, err
+		}
+		completed = loaded
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	if !resume {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(path, flags, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+
+	if !resume {
+		if err := writeCheckpointHeader(file, header); err != nil {
+			return nil, errors.Join(err, file.Close())
+		}
+	}
+
+	return &Checkpoint{file: file, completed: completed}, nil
+}
+
+// writeCheckpointHeader writes the header line of a new checkpoint
+func writeCheckpointHeader(file *os.File, header CheckpointHeader) error {
+	line, err := json.Marshal(header)
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint header: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write checkpoint header: %w", err)
+	}
+	return nil
+}
+
+// IsDone reports whether a duplicate group was completed by a previous run
+func (c *Checkpoint) IsDone(duplicateID string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.completed[duplicateID]
+}
+
+// MarkDone records a duplicate group as fully processed. A nil or read-only
+// checkpoint records nothing.
+func (c *Checkpoint) MarkDone(duplicateID string) {
+	if c == nil || c.file == nil || duplicateID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed[duplicateID] = true
+	if _, err := c.file.WriteString(duplicateID + "\n"); err != nil {
+		logError("Failed to write checkpoint: %v", err)
+	}
+}
+
+// Close closes the checkpoint file
+func (c *Checkpoint) Close() error {
+	if c == nil || c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestCheckpointResume tests that completed groups survive a restart
+func TestCheckpointResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	header := CheckpointHeader{Server: "http://localhost:2283", Mode: checkpointModeAutoDelete}
+
+	first, err := openCheckpoint(path, header, false)
+	if err != nil {
+		t.Fatalf("openCheckpoint() error = %v", err)
+	}
+	first.MarkDone("dup1")
+	first.MarkDone("dup2")
+	first.Close()
+
+	resumed, err := openCheckpoint(path, header, true)
+	if err != nil {
+		t.Fatalf("openCheckpoint() resume error = %v", err)
+	}
+	if !resumed.IsDone("dup1") || !resumed.IsDone("dup2") {
+		t.Error("resumed checkpoint should contain dup1 and dup2")
+	}
+	if resumed.IsDone("dup3") {
+		t.Error("resumed checkpoint should not contain dup3")
+	}
+	resumed.MarkDone("dup3")
+	resumed.Close()
+
+	completed, err := loadCheckpoint(path, header)
+	if err != nil {
+		t.Fatalf("loadCheckpoint() error = %v", err)
+	}
+	if len(completed) != 3 {
+		t.Errorf("loadCheckpoint() returned %d groups, want 3", len(completed))
+	}
+
+	// Starting without resume discards previous progress
+	fresh, err := openCheckpoint(path, header, false)
+	if err != nil {
+		t.Fatalf("openCheckpoint() error = %v", err)
+	}
+	fresh.Close()
+
+	completed, err = loadCheckpoint(path, header)
+	if err != nil {
+		t.Fatalf("loadCheckpoint() error = %v", err)
+	}
+	if len(completed) != 0 {
+		t.Errorf("fresh checkpoint contains %d groups, want 0", len(completed))
+	}
+}
+
+// TestLoadCheckpointErrors tests checkpoint validation
+func TestLoadCheckpointErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
+	header := checkpointHeader(&Config{ImmichURL: "http://localhost:2283"})
+	other := CheckpointHeader{Server: "http://other:2283", Mode: header.Mode}
+
+	if _, err := loadCheckpoint(path, header); err == nil {
+		t.Error("loadCheckpoint() should fail when the checkpoint does not exist")
+	}
+
+	checkpoint, err := openCheckpoint(path, header, false)
+	if err != nil {
+		t.Fatalf("openCheckpoint() error = %v", err)
+	}
+	checkpoint.Close()
+
+	if _, err := loadCheckpoint(path, other); err == nil {
+		t.Error("loadCheckpoint() should fail for a different server")
+	}
+	if _, err := openCheckpoint(path, other, true); err == nil {
+		t.Error("openCheckpoint() should refuse to resume against a different server")
+	}
+
+	// Groups synchronized by a sync-only run still have duplicates to delete
+	deleting := checkpointHeader(&Config{ImmichURL: "http://localhost:2283", AutoDelete: true})
+	if _, err := openCheckpoint(path, deleting, true); err == nil || !strings.Contains(err.Error(), "created by a sync run, not auto-delete") {
+		t.Errorf("openCheckpoint() error = %v, want a refusal to resume under another mode", err)
+	}
+
+	// A nil or read-only checkpoint is a no-op
+	var disabled *Checkpoint
+	disabled.MarkDone("dup1")
+	if disabled.IsDone("dup1") {
+		t.Error("nil checkpoint should not report groups as done")
+	}
+	readOnly := &Checkpoint{completed: map[string]bool{}}
+	readOnly.MarkDone("dup1")
+	if readOnly.IsDone("dup1") {
+		t.Error("read-only checkpoint should not record groups")
+	}
+}
+
+// TestCheckpointHeader tests the run modes recorded in the checkpoint
+func TestCheckpointHeader(t *testing.T) {
+	tests := []struct {
+		config Config
+		want   string
+	}{
+		{Config{}, checkpointModeSync},
+		{Config{AutoDelete: true}, checkpointModeAutoDelete},
+		{Config{AutoDelete: true, Merge: true}, checkpointModeMerge},
+		{Config{Stack: true}, checkpointModeStack},
+	}
+	for _, tt := range tests {
+		tt.config.ImmichURL = "http://localhost:2283"
+		header := checkpointHeader(&tt.config)
+		if header.Mode != tt.want || header.Server != tt.config.ImmichURL {
+			t.Errorf("checkpointHeader(%+v) = %+v, want mode %s", tt.config, header, tt.want)
+		}
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// configFileName is the name of the configuration file searched in the
+	// configuration directories
+	configFileName = "config.toml"
+
+	// Environment variables selecting the configuration file and profile
+	envConfigPath = "IMMICH_DUPLICATE_CLEANER_CONFIG"
+	envProfile    = "IMMICH_DUPLICATE_CLEANER_PROFILE"
+)
+
+// envSettings maps settings to the environment variables overriding the
+// configuration file, in the order they are applied
+var envSettings = []struct {
+	name string // Long flag name of the setting
+	env  string // Environment variable setting it
+}{
+	{"url", "IMMICH_URL"},
+	{"api-key", "IMMICH_API_KEY"},
+}
+
+// apiKeySettings are the mutually exclusive sources of the API key
+var apiKeySettings = []string{"api-key", "api-key-file", "api-key-command"}
+
+// flagShorthands maps shorthand flags to the long flag they stand for
+var flagShorthands = map[string]string{
+	"u": "url",
+	"k": "api-key",
+	"d": "auto-delete",
+	"y": "yes",
+	"i": "interactive",
+	"v": "verbose",
+}
+
+// unsettableFlags are the flags that cannot be set in the configuration file
+var unsettableFlags = map[string]bool{
+	"config":  true,
+	"profile": true,
+	"users":   true,
+	"version": true,
+}
+
+// configKind is the TOML type of a configuration value
+type configKind int
+
+const (
+	configString configKind = iota
+	configBool
+	configNumber
+)
+
+func (k configKind) String() string {
+	switch k {
+	case configBool:
+		return "a boolean"
+	case configNumber:
+		return "a number"
+	default:
+		return "a string"
+	}
+}
+
+// configValue is a value of the configuration file
+type configValue struct {
+	text string     // Value as accepted by flag.Value.Set
+	kind configKind // TOML type of the value
+	line int        // Line of the value in the file
+}
+
+// configTable holds the values of one table of the configuration file by key
+type configTable map[string]configValue
+
+// ConfigFile is a parsed configuration file. Top-level keys apply to every
+// profile; the keys of a [profiles.<name>] table override them when that
+// profile is selected.
+type ConfigFile struct {
+	Path     string
+	Defaults configTable
+	Profiles map[string]configTable
+}
+
+// configSource records where a setting was taken from, so that validation
+// errors can point at it
+type configSource struct {
+	file string // Configuration file, empty for an environment variable
+	line int    // Line in the file
+	key  string // Qualified key in the file, or the environment variable
+}
+
+func (s configSource) String() string {
+	if s.file == "" {
+		return s.key
+	}
+	return fmt.Sprintf("%s:%d: %s", s.file, s.line, s.key)
+}
+
+// settingError prefixes err with the location of the first of the named
+// settings that was taken from the configuration file or the environment.
+// Errors about settings given as flags are returned unchanged.
+func (c *Config) settingError(err error, names ...string) error {
+	for _, name := range names {
+		if source, ok := c.sources[name]; ok {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+	}
+	return err
+}
+
+// configSearchPath returns the locations searched for the configuration file,
+// most specific first: the user configuration directory ($XDG_CONFIG_HOME),
+// then $XDG_CONFIG_DIRS
+func configSearchPath() []string {
+	paths := []string{}
+	if dir, err := os.UserConfigDir(); err == nil {
+		paths = append(paths, filepath.Join(dir, "immich-duplicate-cleaner", configFileName))
+	}
+
+	dirs := os.Getenv("XDG_CONFIG_DIRS")
+	if dirs == "" {
+		dirs = "/etc/xdg"
+	}
+	for _, dir := range filepath.SplitList(dirs) {
+		if dir != "" {
+			paths = append(paths, filepath.Join(dir, "immich-duplicate-cleaner", configFileName))
+		}
+	}
+	return paths
+}
+
+// findConfigFile returns the configuration file to load: path if given,
+// otherwise the first existing file of the search path. It returns an empty
+// path if no file was given and none exists.
+func findConfigFile(path string) (string, error) {
+	if path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("configuration file: %w", err)
+		}
+		return path, nil
+	}
+
+	for _, candidate := range configSearchPath() {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+// loadConfigFile reads and parses the configuration file at path
+func loadConfigFile(path string) (file *ConfigFile, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open configuration file: %w", err)
+	}
+	defer func() {
+		if closeErr := f.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close configuration file: %w", closeErr)
+		}
+	}()
+
+	return parseConfigFile(path, f)
+}
+
+var (
+	configKeyPattern   = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	configTablePattern = regexp.MustCompile(`^profiles\.(?:([A-Za-z0-9_-]+)|"([^"\\]+)")$`)
+)
+
+// parseConfigFile parses the subset of TOML used by configuration files:
+// comments, top-level key/value pairs and [profiles.<name>] tables, with
+// string, boolean and number values
+func parseConfigFile(path string, r io.Reader) (*ConfigFile, error) {
+	file := &ConfigFile{Path: path, Defaults: configTable{}, Profiles: map[string]configTable{}}
+	table, tableName := file.Defaults, ""
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", path, lineNum, fmt.Sprintf(format, args...))
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			header, rest, ok := strings.Cut(line[1:], "]")
+			if !ok || !isConfigComment(rest) {
+				return nil, fail("invalid table header %s", line)
+			}
+			match := configTablePattern.FindStringSubmatch(strings.TrimSpace(header))
+			if match == nil {
+				return nil, fail("unsupported table [%s] (only [profiles.<name>] tables are allowed)", strings.TrimSpace(header))
+			}
+			name := match[1] + match[2]
+			if _, exists := file.Profiles[name]; exists {
+				return nil, fail("profile %q is defined twice", name)
+			}
+			table, tableName = configTable{}, "profiles."+name
+			file.Profiles[name] = table
+			continue
+		}
+
+		key, rest, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !configKeyPattern.MatchString(key) {
+			return nil, fail("expected key = value, got %s", line)
+		}
+		if _, exists := table[key]; exists {
+			return nil, fail("%s is set twice", qualifiedKey(tableName, key))
+		}
+		value, err := parseConfigValue(strings.TrimSpace(rest))
+		if err != nil {
+			return nil, fail("%s: %v", qualifiedKey(tableName, key), err)
+		}
+		value.line = lineNum
+		table[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read configuration file: %w", err)
+	}
+
+	if profile, ok := file.Defaults["profile"]; ok && profile.kind != configString {
+		return nil, fmt.Errorf("%s:%d: profile must be a string", path, profile.line)
+	}
+	return file, nil
+}
+
+// parseConfigValue parses a TOML value followed by an optional comment
+func parseConfigValue(s string) (configValue, error) {
+	var value configValue
+	var rest string
+
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := 1
+		for end < len(s) && s[end] != '"' {
+			if s[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(s) {
+			return value, errors.New("unterminated string")
+		}
+		text, err := strconv.Unquote(s[:end+1])
+		if err != nil {
+			return value, fmt.Errorf("invalid string %s", s[:end+1])
+		}
+		value, rest = configValue{text: text, kind: configString}, s[end+1:]
+	case strings.HasPrefix(s, "'"):
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return value, errors.New("unterminated string")
+		}
+		value, rest = configValue{text: s[1 : end+1], kind: configString}, s[end+2:]
+	default:
+		token, comment, _ := strings.Cut(s, "#")
+		token, rest = strings.TrimSpace(token), "#"+comment
+		switch token {
+		case "true", "false":
+			value = configValue{text: token, kind: configBool}
+		case "":
+			return value, errors.New("missing value")
+		default:
+			if _, err := strconv.ParseFloat(token, 64); err != nil {
+				return value, fmt.Errorf("invalid value %s (strings must be quoted)", token)
+			}
+			value = configValue{text: token, kind: configNumber}
+		}
+	}
+
+	if !isConfigComment(rest) {
+		return value, fmt.Errorf("unexpected %s after value", strings.TrimSpace(rest))
+	}
+	return value, nil
+}
+
+// isConfigComment reports whether s is empty or only a comment
+func isConfigComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || strings.HasPrefix(s, "#")
+}
+
+// qualifiedKey returns the dotted name of key in the named table
+func qualifiedKey(table, key string) string {
+	if table == "" {
+		return key
+	}
+	return table + "." + key
+}
+
+// applyConfigSources completes the settings of config that were not given as
+// flags in fs, first from the environment, then from the selected profile of
+// the configuration file, then from its top-level keys, and resolves the API
+// key. It records where each of those settings came from for validateConfig.
+func applyConfigSources(config *Config, fs *flag.FlagSet) error {
+	config.sources = map[string]configSource{}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		name := f.Name
+		if long, ok := flagShorthands[name]; ok {
+			name = long
+		}
+		explicit[name] = true
+	})
+	markAPIKeySettings(explicit)
+
+	for _, setting := range envSettings {
+		value := os.Getenv(setting.env)
+		if explicit[setting.name] || value == "" {
+			continue
+		}
+		if err := fs.Set(setting.name, value); err != nil {
+			return fmt.Errorf("%s: %w", setting.env, err)
+		}
+		config.sources[setting.name] = configSource{key: setting.env}
+		explicit[setting.name] = true
+		markAPIKeySettings(explicit)
+	}
+
+	if err := applyConfigFile(config, fs, explicit); err != nil {
+		return err
+	}
+	return resolveAPIKey(config)
+}
+
+// markAPIKeySettings marks every source of the API key as set once one of
+// them is, so that a less specific source cannot add a second key
+func markAPIKeySettings(explicit map[string]bool) {
+	for _, name := range apiKeySettings {
+		if explicit[name] {
+			for _, other := range apiKeySettings {
+				explicit[other] = true
+			}
+			return
+		}
+	}
+}
+
+// applyConfigFile sets the settings of config that are not explicit from the
+// configuration file, if one is given or found
+func applyConfigFile(config *Config, fs *flag.FlagSet, explicit map[string]bool) error {
+	if config.ConfigPath == "" {
+		config.ConfigPath = os.Getenv(envConfigPath)
+	}
+	if config.Profile == "" {
+		config.Profile = os.Getenv(envProfile)
+	}
+
+	path, err := findConfigFile(config.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		if config.Profile != "" {
+			return fmt.Errorf("profile %q selected but no configuration file was found (searched %s)", config.Profile, strings.Join(configSearchPath(), ", "))
+		}
+		return nil
+	}
+
+	file, err := loadConfigFile(path)
+	if err != nil {
+		return err
+	}
+	config.ConfigPath = path
+
+	settings, err := file.settings(config.Profile)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		setting := settings[name]
+		source := configSource{file: path, line: setting.value.line, key: qualifiedKey(setting.table, name)}
+		if explicit[name] {
+			continue
+		}
+		if err := setConfigValue(fs, name, setting.value); err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		config.sources[name] = source
+		if name == "api-key" {
+			warnReadableSecret(path, "configuration file holding an API key")
+		}
+	}
+	return nil
+}
+
+// fileSetting is a value of the configuration file with the table it is in
+type fileSetting struct {
+	table string
+	value configValue
+}
+
+// settings returns the values applying to profile: the keys of its table
+// over the top-level keys. An empty profile selects the one named by the
+// top-level profile key, if any. The sources of the API key replace each
+// other, so a profile giving any of them overrides all top-level ones.
+func (f *ConfigFile) settings(profile string) (map[string]fileSetting, error) {
+	if profile == "" {
+		profile = f.Defaults["profile"].text
+	}
+
+	type layer struct {
+		name  string
+		table configTable
+	}
+	layers := []layer{{"", f.Defaults}}
+	if profile != "" {
+		table, ok := f.Profiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("%s: profile %q not found (available: %s)", f.Path, profile, strings.Join(f.profileNames(), ", "))
+		}
+		layers = append(layers, layer{"profiles." + profile, table})
+	}
+
+	settings := map[string]fileSetting{}
+	for _, layer := range layers {
+		keySources, line := 0, 0
+		for _, name := range apiKeySettings {
+			if value, ok := layer.table[name]; ok {
+				keySources++
+				line = max(line, value.line)
+			}
+		}
+		if keySources > 1 {
+			table := layer.name
+			if table == "" {
+				table = "top level"
+			}
+			return nil, fmt.Errorf("%s:%d: only one of %s can be set (%s)", f.Path, line, strings.Join(apiKeySettings, ", "), table)
+		}
+		if keySources > 0 {
+			for _, name := range apiKeySettings {
+				delete(settings, name)
+			}
+		}
+
+		for name, value := range layer.table {
+			if layer.name == "" && name == "profile" {
+				continue
+			}
+			settings[name] = fileSetting{table: layer.name, value: value}
+		}
+	}
+	return settings, nil
+}
+
+// profileNames returns the sorted names of the profiles of the file
+func (f *ConfigFile) profileNames() []string {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setConfigValue sets the flag called name to value, checking that the TOML
+// type of value matches the flag
+func setConfigValue(fs *flag.FlagSet, name string, value configValue) error {
+	f := fs.Lookup(name)
+	if f == nil || unsettableFlags[name] || flagShorthands[name] != "" {
+		return errors.New("unknown key")
+	}
+
+	want := configString
+	if getter, ok := f.Value.(flag.Getter); ok {
+		switch getter.Get().(type) {
+		case bool:
+			want = configBool
+		case int, int64, uint, uint64, float64:
+			want = configNumber
+		}
+	}
+	if value.kind != want {
+		return fmt.Errorf("must be %s, got %s", want, value.kind)
+	}
+
+	return fs.Set(name, value.text)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestParseConfigFile tests parsing configuration files and their errors
+func TestParseConfigFile(t *testing.T) {
+	file, err := parseConfigFile("config.toml", strings.NewReader(`
+# Settings shared by every profile
+profile = "home"
+policy = "balanced" # trailing comment
+
+[profiles.home]
+url = "http://localhost:2283"
+api-key = 'literal\key'
+auto-delete = true
+
+[profiles."parents"]
+concurrency = 4
+resolution-tolerance = 0.05
+weights = "gps=3,size=1"
+`))
+	if err != nil {
+		t.Fatalf("parseConfigFile() error = %v", err)
+	}
+
+	if got := file.Defaults["policy"]; got.text != "balanced" || got.kind != configString || got.line != 4 {
+		t.Errorf("policy = %+v, want balanced on line 4", got)
+	}
+	home := file.Profiles["home"]
+	if home["api-key"].text != `literal\key` || home["auto-delete"].kind != configBool {
+		t.Errorf("profiles.home = %+v", home)
+	}
+	parents := file.Profiles["parents"]
+	if parents["concurrency"].kind != configNumber || parents["resolution-tolerance"].text != "0.05" || parents["weights"].text != "gps=3,size=1" {
+		t.Errorf("profiles.parents = %+v", parents)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"unquoted string", "url = http://localhost", "config.toml:1: url: invalid value"},
+		{"unterminated string", "\n\nurl = \"http://localhost", "config.toml:3: url: unterminated string"},
+		{"text after value", `url = "http://localhost" 2283`, "config.toml:1: url: unexpected 2283 after value"},
+		{"missing value", "yes =", "config.toml:1: yes: missing value"},
+		{"duplicate key", "[profiles.home]\nyes = true\nyes = false", "config.toml:3: profiles.home.yes is set twice"},
+		{"duplicate profile", "[profiles.home]\n[profiles.home]", `config.toml:2: profile "home" is defined twice`},
+		{"unsupported table", "[server]", "config.toml:1: unsupported table [server]"},
+		{"not a key", "just text", "config.toml:1: expected key = value"},
+		{"profile not a string", "profile = 1", "config.toml:1: profile must be a string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfigFile("config.toml", strings.NewReader(tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseConfigFile() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// configFlagSet returns a flag set binding a few settings of config, as
+// parseFlags does
+func configFlagSet(config *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&config.ImmichURL, "url", "", "")
+	fs.StringVar(&config.ImmichURL, "u", "", "")
+	fs.StringVar(&config.APIKey, "api-key", "", "")
+	fs.StringVar(&config.APIKeyFile, "api-key-file", "", "")
+	fs.StringVar(&config.APIKeyCommand, "api-key-command", "", "")
+	fs.BoolVar(&config.AutoDelete, "auto-delete", false, "")
+	fs.BoolVar(&config.Stack, "stack", false, "")
+	fs.StringVar(&config.Policy, "policy", policyLegacy, "")
+	fs.IntVar(&config.Concurrency, "concurrency", 1, "")
+	fs.StringVar(&config.ConfigPath, "config", "", "")
+	fs.StringVar(&config.Profile, "profile", "", "")
+	return fs
+}
+
+// TestApplyConfigSources tests the precedence of flags, environment variables,
+// profiles and top-level keys
+func TestApplyConfigSources(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	if err := os.WriteFile(keyFile, []byte("file-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.toml")
+	content := `profile = "home"
+url = "http://top-level"
+api-key = "top-level-key"
+concurrency = 2
+
+[profiles.home]
+url = "http://home"
+api-key-file = "` + keyFile + `"
+auto-delete = true
+
+[profiles.other]
+policy = "bogus"
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		args        []string
+		env         string
+		envKey      string
+		wantURL     string
+		wantKey     string
+		wantDelete  bool
+		wantWorkers int
+	}{
+		{"default profile", nil, "", "", "http://home", "file-key", true, 2},
+		{"flags win", []string{"-u", "http://flag", "--api-key", "flag-key", "--auto-delete=false", "--concurrency", "8"}, "", "", "http://flag", "flag-key", false, 8},
+		{"environment over file", nil, "http://env", "env-key", "http://env", "env-key", true, 2},
+		{"flag over environment", []string{"--url", "http://flag", "--api-key-command", "echo command-key"}, "http://env", "env-key", "http://flag", "command-key", true, 2},
+		{"top-level only", []string{"--profile", "other"}, "", "", "http://top-level", "top-level-key", false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("IMMICH_URL", tt.env)
+			t.Setenv("IMMICH_API_KEY", tt.envKey)
+			config := &Config{}
+			fs := configFlagSet(config)
+			if err := fs.Parse(append([]string{"--config", path}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := applyConfigSources(config, fs); err != nil {
+				t.Fatalf("applyConfigSources() error = %v", err)
+			}
+			if config.ImmichURL != tt.wantURL || config.APIKey != tt.wantKey || config.AutoDelete != tt.wantDelete || config.Concurrency != tt.wantWorkers {
+				t.Errorf("config = url %s, key %s, auto-delete %v, concurrency %d; want %s, %s, %v, %d",
+					config.ImmichURL, config.APIKey, config.AutoDelete, config.Concurrency, tt.wantURL, tt.wantKey, tt.wantDelete, tt.wantWorkers)
+			}
+		})
+	}
+}
+
+// TestConfigSourceErrors tests that invalid settings point at their source
+func TestConfigSourceErrors(t *testing.T) {
+	t.Setenv("IMMICH_URL", "")
+	t.Setenv("IMMICH_API_KEY", "")
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	content := `url = "http://localhost:2283"
+api-key = "key"
+
+[profiles.types]
+concurrency = "4"
+
+[profiles.policy]
+policy = "bogus"
+
+[profiles.modes]
+auto-delete = true
+stack = true
+
+[profiles.unknown]
+colour = "blue"
+
+[profiles.keys]
+api-key-file = "key.txt"
+api-key = "other"
+
+[profiles.keyfile]
+api-key-file = "missing.txt"
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		profile string
+		args    []string
+		wantErr string
+	}{
+		{"types", nil, path + ":5: profiles.types.concurrency: must be a number, got a string"},
+		{"policy", nil, path + `:8: profiles.policy.policy: unknown policy "bogus"`},
+		{"policy", []string{"--policy", "bogus"}, `unknown policy "bogus"`},
+		{"modes", nil, path + ":11: profiles.modes.auto-delete: --auto-delete and --stack are mutually exclusive"},
+		{"unknown", nil, path + ":15: profiles.unknown.colour: unknown key"},
+		{"keys", nil, path + ":19: only one of api-key, api-key-file, api-key-command can be set (profiles.keys)"},
+		{"keyfile", nil, path + ":22: profiles.keyfile.api-key-file: failed to read API key file"},
+		{"missing", nil, path + `: profile "missing" not found (available: keyfile, keys, modes, policy, types, unknown)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			config := &Config{}
+			fs := configFlagSet(config)
+			if err := fs.Parse(append([]string{"--config", path, "--profile", tt.profile}, tt.args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			err := applyConfigSources(config, fs)
+			if err == nil {
+				err = validateConfig(config)
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
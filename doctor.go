@@ -0,0 +1,420 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// doctorStatus is the outcome of a doctor check
+type doctorStatus int
+
+const (
+	doctorPass doctorStatus = iota
+	doctorWarn
+	doctorFail
+	doctorSkip
+)
+
+// doctorCheck is the result of one doctor check
+type doctorCheck struct {
+	name   string
+	status doctorStatus
+	detail string // What was found
+	remedy string // How to fix a failure or a warning, empty if nothing to do
+}
+
+// doctorStep is a check of the doctor. Later steps are skipped once a
+// blocking step fails, since every request would fail the same way.
+type doctorStep struct {
+	name     string
+	blocking bool
+	run      func(d *doctor) doctorCheck
+}
+
+// doctorPermission is an API key permission checked by the doctor
+type doctorPermission struct {
+	name string
+	use  string // What needs the permission
+}
+
+var (
+	// doctorPermissions are the permissions the tool uses, with what needs them
+	doctorPermissions = []doctorPermission{
+		{"duplicate.read", "listing duplicates"},
+		{"asset.read", "comparing assets"},
+		{"album.read", "reading the albums of assets"},
+		{"albumAsset.create", "synchronizing albums"},
+		{"asset.delete", "--auto-delete"},
+		{"stack.create", "--stack"},
+		{"asset.update", "--merge-metadata, not-duplicates and undo"},
+		{"tag.asset", "--merge-metadata and undo"},
+		{"albumAsset.delete", "undo"},
+		{"trash.restore", "undo"},
+		{"stack.delete", "undo"},
+	}
+
+	doctorSteps = []doctorStep{
+		{"Configuration", true, (*doctor).checkConfig},
+		{"Server reachable", true, (*doctor).checkReachable},
+		{"TLS certificate", true, (*doctor).checkTLS},
+		{"Server version", false, (*doctor).checkVersion},
+		{"API key", true, (*doctor).checkAPIKey},
+		{"Permissions", false, (*doctor).checkPermissions},
+		{"Duplicate detection", false, (*doctor).checkDuplicates},
+		{"Trash", false, (*doctor).checkTrash},
+	}
+)
+
+// doctor diagnoses the connection to the server and the API key
+type doctor struct {
+	config  *Config
+	api     *immich.Client
+	url     *url.URL
+	pingErr error // Error of the reachability check, kept for the TLS check
+}
+
+// runDoctor checks the configuration, the server and the API key, printing
+// how to fix each problem found. It returns the number of failed checks.
+func runDoctor(config *Config) int {
+	logInfo("🩺 Checking the configuration, the server and the API key...")
+
+	counts := make(map[doctorStatus]int)
+	for _, check := range diagnose(config) {
+		counts[check.status]++
+		switch check.status {
+		case doctorPass:
+			logInfo("✅ %s: %s", check.name, check.detail)
+		case doctorWarn:
+			logWarning("%s: %s", check.name, check.detail)
+		case doctorFail:
+			logError("%s: %s", check.name, check.detail)
+		case doctorSkip:
+			logInfo("⏭️  %s: %s", check.name, check.detail)
+		}
+		if check.remedy != "" {
+			logInfo("   💡 %s", check.remedy)
+		}
+	}
+
+	logInfo("\n🩺 %d passed, %d warning(s), %d failed, %d skipped",
+		counts[doctorPass], counts[doctorWarn], counts[doctorFail], counts[doctorSkip])
+	return counts[doctorFail]
+}
+
+// diagnose runs every doctor step in order
+func diagnose(config *Config) []doctorCheck {
+	d := &doctor{config: config, api: config.api()}
+	checks := make([]doctorCheck, 0, len(doctorSteps))
+	blocked := false
+	for _, step := range doctorSteps {
+		if blocked {
+			checks = append(checks, doctorCheck{name: step.name, status: doctorSkip, detail: "skipped after an earlier failure"})
+			continue
+		}
+		check := step.run(d)
+		check.name = step.name
+		checks = append(checks, check)
+		if check.status == doctorFail && step.blocking {
+			blocked = true
+		}
+	}
+	return checks
+}
+
+func (d *doctor) checkConfig() doctorCheck {
+	if d.config.ImmichURL == "" {
+		return doctorCheck{status: doctorFail, detail: "no server URL is set",
+			remedy: "pass --url, set IMMICH_URL, or set url in the configuration file"}
+	}
+	u, err := url.Parse(d.config.ImmichURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("%q is not an http:// or https:// URL", d.config.ImmichURL),
+			remedy: "set the address of the Immich web UI, e.g. http://immich.local:2283"}
+	}
+	d.url = u
+
+	if d.config.APIKey == "" {
+		return doctorCheck{status: doctorFail, detail: "no API key is set",
+			remedy: "create a key under Account Settings > API Keys in Immich, then pass it with IMMICH_API_KEY, --api-key-file or --api-key-command"}
+	}
+
+	check := doctorCheck{status: doctorPass, detail: fmt.Sprintf("server %s, API key from %s", d.config.ImmichURL, apiKeyOrigin(d.config))}
+	if strings.HasSuffix(strings.TrimSuffix(u.Path, "/"), "/api") {
+		check.status = doctorWarn
+		check.detail = fmt.Sprintf("the URL ends with /api, which the tool adds itself; %s", check.detail)
+		check.remedy = "remove /api from the end of the URL"
+	}
+	return check
+}
+
+func (d *doctor) checkReachable() doctorCheck {
+	d.pingErr = d.api.Ping(d.config.context())
+	err := d.pingErr
+	host := d.url.Host
+
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch status := immich.StatusCode(err); {
+	case err == nil:
+		return doctorCheck{status: doctorPass, detail: fmt.Sprintf("%s answers as an Immich server", host)}
+	case isTLSError(err):
+		// Reported by the TLS check
+		return doctorCheck{status: doctorPass, detail: fmt.Sprintf("%s accepts connections", host)}
+	case errors.As(err, &dnsErr):
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("cannot resolve %s: %v", d.url.Hostname(), err),
+			remedy: "check the host name in the URL, or use the server's IP address"}
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("%s refused the connection", host),
+			remedy: "check that Immich is running and that the port in the URL is the one it listens on (2283 by default)"}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("%s did not answer in time", host),
+			remedy: "check that the server is up and that no firewall blocks the port"}
+	case status == http.StatusNotFound:
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("%s answers, but not as an Immich server", host),
+			remedy: "use the address of the Immich web UI; if Immich runs under a path behind a reverse proxy, include that path"}
+	case status == http.StatusBadRequest && d.url.Scheme == "http":
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("%s rejected the request (%v)", host, err),
+			remedy: "the port may expect HTTPS: try https:// in the URL"}
+	case status >= 500:
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("the server failed to answer (%v)", err),
+			remedy: "a reverse proxy may not reach Immich, or Immich is still starting: check the logs of the Immich server container"}
+	default:
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("cannot reach %s: %v", host, err),
+			remedy: "check the URL and the network connection to the server"}
+	}
+}
+
+func (d *doctor) checkTLS() doctorCheck {
+	if d.url.Scheme != "https" {
+		if isLocalHost(d.url.Hostname()) {
+			return doctorCheck{status: doctorPass, detail: "not used (plain HTTP to a local server)"}
+		}
+		return doctorCheck{status: doctorWarn, detail: "not used: the API key is sent unencrypted",
+			remedy: "use https:// when the server is reached over the internet"}
+	}
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+	err := d.pingErr
+	switch {
+	case !isTLSError(err):
+		return doctorCheck{status: doctorPass, detail: fmt.Sprintf("valid for %s", d.url.Hostname())}
+	case errors.As(err, &unknownAuthority):
+		return doctorCheck{status: doctorFail, detail: "the certificate is signed by an unknown authority (self-signed or private CA)",
+			remedy: "add the CA certificate to the system trust store, or point SSL_CERT_FILE at it"}
+	case errors.As(err, &hostnameErr):
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("the certificate is not valid for %s: %v", d.url.Hostname(), hostnameErr),
+			remedy: "use the host name the certificate was issued for, or add this name to the certificate"}
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("the certificate has expired or is not yet valid: %v", invalidErr),
+			remedy: "renew the certificate of the server, and check the clock of this machine"}
+	case errors.As(err, &recordErr), strings.Contains(err.Error(), "HTTP response to HTTPS client"):
+		return doctorCheck{status: doctorFail, detail: "the server does not speak TLS on this port",
+			remedy: "use http:// in the URL, or the port of the HTTPS reverse proxy"}
+	default:
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("the TLS handshake failed: %v", err),
+			remedy: "check the certificate and TLS settings of the server or its reverse proxy"}
+	}
+}
+
+func (d *doctor) checkVersion() doctorCheck {
+	version, err := d.api.GetServerVersion(d.config.context())
+	if err != nil {
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("failed to fetch the version: %v", err),
+			remedy: "check that the URL points at Immich itself and not at another application"}
+	}
+	d.api.Version = version
+
+	if err := checkServerVersion(*version); err != nil {
+		if !version.AtLeast(minServerMajor, minServerMinor, 0) {
+			return doctorCheck{status: doctorFail, detail: err.Error(),
+				remedy: fmt.Sprintf("upgrade Immich to v%d.%d.0 or later", minServerMajor, minServerMinor)}
+		}
+		return doctorCheck{status: doctorWarn, detail: err.Error(),
+			remedy: "check for a newer release of this tool, and try --dry-run first"}
+	}
+	return doctorCheck{status: doctorPass, detail: fmt.Sprintf("Immich %s", version)}
+}
+
+func (d *doctor) checkAPIKey() doctorCheck {
+	user, err := d.api.GetCurrentUser(d.config.context())
+	switch status := immich.StatusCode(err); {
+	case err == nil:
+		return doctorCheck{status: doctorPass, detail: fmt.Sprintf("valid, owned by %s <%s>", user.Name, user.Email)}
+	case status == http.StatusUnauthorized:
+		return doctorCheck{status: doctorFail, detail: "rejected by the server",
+			remedy: fmt.Sprintf("the key was mistyped or deleted: create a new key under Account Settings > API Keys and update %s", apiKeyOrigin(d.config))}
+	case status == http.StatusForbidden:
+		// Keys without user.read can still be used; the permissions check tells
+		return doctorCheck{status: doctorPass, detail: "accepted by the server"}
+	default:
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("failed to check the key: %v", err),
+			remedy: "check the server logs for the failed request"}
+	}
+}
+
+func (d *doctor) checkPermissions() doctorCheck {
+	required, optional := doctorPermissionSets(d.config)
+	key, err := d.api.GetCurrentAPIKey(d.config.context())
+	switch status := immich.StatusCode(err); {
+	case status == http.StatusNotFound:
+		return doctorCheck{status: doctorPass, detail: "the server predates API key permissions, so the key can do anything"}
+	case status == http.StatusForbidden:
+		return doctorCheck{status: doctorWarn, detail: "the key is not allowed to read its own permissions",
+			remedy: "grant the key apiKey.read to check its permissions, or make sure it has " + permissionNames(required)}
+	case err != nil:
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("failed to fetch the key: %v", err),
+			remedy: "check the server logs for the failed request"}
+	case containsString(key.Permissions, permissionAll):
+		return doctorCheck{status: doctorPass, detail: fmt.Sprintf("key %q has every permission", key.Name)}
+	}
+
+	missing := missingDoctorPermissions(key.Permissions, required)
+	if len(missing) > 0 {
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("key %q lacks %s", key.Name, describePermissions(missing)),
+			remedy: "edit the key under Account Settings > API Keys and grant " + permissionNames(missing)}
+	}
+	missing = missingDoctorPermissions(key.Permissions, optional)
+	if len(missing) > 0 {
+		return doctorCheck{status: doctorWarn, detail: fmt.Sprintf("key %q lacks %s", key.Name, describePermissions(missing)),
+			remedy: "grant " + permissionNames(missing) + " to use these modes"}
+	}
+	return doctorCheck{status: doctorPass, detail: fmt.Sprintf("key %q has every permission the tool uses", key.Name)}
+}
+
+func (d *doctor) checkDuplicates() doctorCheck {
+	const jobsRemedy = "as an administrator, run Smart Search and then Duplicate Detection under Administration > Jobs"
+
+	features, err := d.api.GetServerFeatures(d.config.context())
+	if err == nil && !features.DuplicateDetection {
+		remedy := "enable Duplicate Detection under Administration > Settings > Machine Learning Settings, then " + jobsRemedy
+		if !features.SmartSearch {
+			remedy = "enable machine learning and Smart Search, which duplicate detection builds on, then Duplicate Detection under Administration > Settings > Machine Learning Settings"
+		}
+		return doctorCheck{status: doctorFail, detail: "duplicate detection is disabled on the server", remedy: remedy}
+	}
+
+	duplicates, err := d.api.GetDuplicates(d.config.context())
+	switch status := immich.StatusCode(err); {
+	case status == http.StatusForbidden:
+		return doctorCheck{status: doctorFail, detail: "the key is not allowed to list duplicates",
+			remedy: "grant the key duplicate.read under Account Settings > API Keys"}
+	case err != nil:
+		return doctorCheck{status: doctorFail, detail: fmt.Sprintf("failed to fetch duplicates: %v", err),
+			remedy: "check the server logs for the failed request"}
+	case len(duplicates) == 0:
+		return doctorCheck{status: doctorWarn, detail: "the server reports no duplicates; duplicate detection may not have run yet",
+			remedy: jobsRemedy + ", or ignore this if the library has no duplicates"}
+	}
+
+	assets := 0
+	for _, group := range duplicates {
+		assets += len(group.Assets)
+	}
+	return doctorCheck{status: doctorPass, detail: fmt.Sprintf("%d group(s) with %d asset(s) reported", len(duplicates), assets)}
+}
+
+func (d *doctor) checkTrash() doctorCheck {
+	serverConfig, err := d.api.GetServerConfig(d.config.context())
+	switch {
+	case err != nil:
+		return doctorCheck{status: doctorWarn, detail: fmt.Sprintf("failed to fetch the trash setting: %v", err)}
+	case trashDisabled(&serverInfo{trashDays: serverConfig.TrashDays}):
+		return doctorCheck{status: doctorWarn, detail: "disabled, so deleted duplicates could not be restored; --auto-delete requires --permanent",
+			remedy: "enable the trash under Administration > Settings > Trash Settings"}
+	case serverConfig.TrashDays == nil:
+		return doctorCheck{status: doctorPass, detail: "enabled"}
+	}
+	return doctorCheck{status: doctorPass, detail: fmt.Sprintf("deleted duplicates are kept %d day(s)", *serverConfig.TrashDays)}
+}
+
+// isTLSError reports whether err comes from the TLS handshake
+func isTLSError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return err != nil && (errors.As(err, &verifyErr) || errors.As(err, &recordErr) ||
+		errors.As(err, &unknownAuthority) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) ||
+		strings.Contains(err.Error(), "HTTP response to HTTPS client") || strings.Contains(err.Error(), "tls: "))
+}
+
+// isLocalHost reports whether host is this machine or on a private network
+func isLocalHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".local") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate())
+}
+
+// apiKeyOrigin describes where the API key of config was set
+func apiKeyOrigin(config *Config) string {
+	for _, name := range apiKeySettings {
+		if source, ok := config.sources[name]; ok {
+			return source.String()
+		}
+	}
+	switch {
+	case config.APIKeyFile != "":
+		return "--api-key-file"
+	case config.APIKeyCommand != "":
+		return "--api-key-command"
+	}
+	return "--api-key"
+}
+
+// doctorPermissionSets splits the permissions the tool uses into those
+// required by a run with the flags of config and the optional rest
+func doctorPermissionSets(config *Config) (required, optional []doctorPermission) {
+	names := requiredPermissions(config, "")
+	for _, permission := range doctorPermissions {
+		if containsString(names, permission.name) {
+			required = append(required, permission)
+		} else {
+			optional = append(optional, permission)
+		}
+	}
+	return required, optional
+}
+
+// missingDoctorPermissions returns the permissions not granted
+func missingDoctorPermissions(granted []string, permissions []doctorPermission) []doctorPermission {
+	missing := []doctorPermission{}
+	for _, permission := range permissions {
+		if !containsString(granted, permission.name) {
+			missing = append(missing, permission)
+		}
+	}
+	return missing
+}
+
+// describePermissions lists permissions with what needs them
+func describePermissions(permissions []doctorPermission) string {
+	parts := make([]string, len(permissions))
+	for i, permission := range permissions {
+		parts[i] = fmt.Sprintf("%s (%s)", permission.name, permission.use)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// permissionNames lists the names of permissions
+func permissionNames(permissions []doctorPermission) string {
+	names := make([]string, len(permissions))
+	for i, permission := range permissions {
+		names[i] = permission.name
+	}
+	return strings.Join(names, ", ")
+}
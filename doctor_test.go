@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// doctorMock answers like an Immich server with the given API key
+// permissions, duplicates and features. A nil permissions list answers the
+// API key request with HTTP 404 like servers without scoped keys.
+func doctorMock(permissions []string, duplicates, features string) *MockHTTPClient {
+	return &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			status, body := http.StatusOK, `{"res": "pong"}`
+			switch {
+			case req.Header.Get("x-api-key") != "test-key" && req.URL.Path != "/api/server/ping":
+				status, body = http.StatusUnauthorized, `{"message": "Invalid API key"}`
+			case req.URL.Path == "/api/server/version":
+				body = `{"major": 1, "minor": 120, "patch": 0}`
+			case req.URL.Path == "/api/server/config":
+				body = `{"trashDays": 30}`
+			case req.URL.Path == "/api/server/features":
+				body = features
+			case req.URL.Path == "/api/users/me":
+				body = `{"id": "u1", "name": "Alice", "email": "alice@example.com"}`
+			case req.URL.Path == "/api/api-keys/me" && permissions == nil:
+				status, body = http.StatusNotFound, `{"message": "Cannot GET /api/api-keys/me"}`
+			case req.URL.Path == "/api/api-keys/me":
+				body = `{"id": "k1", "name": "cleaner", "permissions": ["` + strings.Join(permissions, `", "`) + `"]}`
+			case req.URL.Path == "/api/duplicates":
+				body = duplicates
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		},
+	}
+}
+
+// TestDiagnose tests the doctor checks and the remedies they suggest
+func TestDiagnose(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	const (
+		groups  = `[{"duplicateId": "dup1", "assets": [{"id": "a1"}, {"id": "a2"}]}]`
+		enabled = `{"smartSearch": true, "duplicateDetection": true}`
+	)
+	readOnly := []string{"duplicate.read", "asset.read", "album.read"}
+	syncOnly := append(readOnly, "albumAsset.create")
+
+	tests := []struct {
+		name        string
+		config      Config
+		permissions []string
+		duplicates  string
+		features    string
+		want        map[string]doctorStatus
+		wantRemedy  string
+	}{
+		{
+			name:        "healthy",
+			permissions: []string{"all"},
+			duplicates:  groups,
+			features:    enabled,
+			want: map[string]doctorStatus{"Configuration": doctorPass, "Server reachable": doctorPass, "TLS certificate": doctorPass,
+				"Server version": doctorPass, "API key": doctorPass, "Permissions": doctorPass, "Duplicate detection": doctorPass, "Trash": doctorPass},
+		},
+		{
+			name:       "unscoped key",
+			duplicates: groups,
+			features:   enabled,
+			want:       map[string]doctorStatus{"Permissions": doctorPass},
+		},
+		{
+			name:       "no API key",
+			config:     Config{APIKey: "-"},
+			want:       map[string]doctorStatus{"Configuration": doctorFail, "Server reachable": doctorSkip, "Trash": doctorSkip},
+			wantRemedy: "Account Settings > API Keys",
+		},
+		{
+			name:       "URL ending with /api",
+			config:     Config{ImmichURL: "http://localhost:2283/api/"},
+			duplicates: groups,
+			features:   enabled,
+			want:       map[string]doctorStatus{"Configuration": doctorWarn},
+			wantRemedy: "remove /api",
+		},
+		{
+			name:       "rejected key",
+			config:     Config{APIKey: "wrong-key"},
+			want:       map[string]doctorStatus{"Server reachable": doctorPass, "API key": doctorFail, "Permissions": doctorSkip},
+			wantRemedy: "create a new key",
+		},
+		{
+			name:        "missing sync permission",
+			permissions: readOnly,
+			duplicates:  groups,
+			features:    enabled,
+			want:        map[string]doctorStatus{"Permissions": doctorFail, "Duplicate detection": doctorPass},
+			wantRemedy:  "grant albumAsset.create\n",
+		},
+		{
+			name:        "missing delete permission",
+			config:      Config{AutoDelete: true},
+			permissions: syncOnly,
+			duplicates:  groups,
+			features:    enabled,
+			want:        map[string]doctorStatus{"Permissions": doctorFail},
+			wantRemedy:  "grant asset.delete\n",
+		},
+		{
+			name:        "read-only key for a dry run",
+			config:      Config{AutoDelete: true, DryRun: true},
+			permissions: readOnly,
+			duplicates:  groups,
+			features:    enabled,
+			want:        map[string]doctorStatus{"Permissions": doctorWarn},
+			wantRemedy:  "grant albumAsset.create, asset.delete, stack.create",
+		},
+		{
+			name:        "missing optional permissions",
+			permissions: syncOnly,
+			duplicates:  groups,
+			features:    enabled,
+			want:        map[string]doctorStatus{"Permissions": doctorWarn},
+			wantRemedy:  "grant asset.delete, stack.create, asset.update, tag.asset, albumAsset.delete, trash.restore, stack.delete",
+		},
+		{
+			name:       "detection disabled",
+			features:   `{"smartSearch": true, "duplicateDetection": false}`,
+			duplicates: `[]`,
+			want:       map[string]doctorStatus{"Duplicate detection": doctorFail},
+			wantRemedy: "enable Duplicate Detection",
+		},
+		{
+			name:       "detection not run",
+			features:   enabled,
+			duplicates: `[]`,
+			want:       map[string]doctorStatus{"Duplicate detection": doctorWarn},
+			wantRemedy: "Administration > Jobs",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpClient = doctorMock(tt.permissions, tt.duplicates, tt.features)
+			config := tt.config
+			if config.ImmichURL == "" {
+				config.ImmichURL = "http://localhost:2283"
+			}
+			switch config.APIKey {
+			case "":
+				config.APIKey = "test-key"
+			case "-":
+				config.APIKey = ""
+			}
+
+			checks := diagnose(&config)
+			if len(checks) != len(doctorSteps) {
+				t.Fatalf("diagnose() returned %d check(s), want %d", len(checks), len(doctorSteps))
+			}
+			remedies := []string{}
+			for _, check := range checks {
+				if want, ok := tt.want[check.name]; ok && check.status != want {
+					t.Errorf("%s status = %d, want %d (%s)", check.name, check.status, want, check.detail)
+				}
+				remedies = append(remedies, check.remedy)
+			}
+			if tt.wantRemedy != "" && !strings.Contains(strings.Join(remedies, "\n"), tt.wantRemedy) {
+				t.Errorf("remedies = %q, want one containing %q", remedies, tt.wantRemedy)
+			}
+		})
+	}
+}
+
+// TestDoctorPermissions tests that the doctor describes every permission a
+// run or command may require
+func TestDoctorPermissions(t *testing.T) {
+	configs := []Config{{}, {AutoDelete: true, Stack: true, Merge: true}}
+	for _, command := range []string{"", "apply", "undo", "not-duplicates"} {
+		for i := range configs {
+			for _, name := range requiredPermissions(&configs[i], command) {
+				found := false
+				for _, permission := range doctorPermissions {
+					found = found || permission.name == name
+				}
+				if !found {
+					t.Errorf("doctorPermissions lacks %s, required by %q", name, command)
+				}
+			}
+		}
+	}
+}
+
+// TestDiagnoseConnection tests explaining connection and certificate failures
+func TestDiagnoseConnection(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+	httpClient = &http.Client{}
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s after a failed TLS handshake", r.URL.Path)
+	}))
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedURL := "http://" + listener.Addr().String()
+	if err := listener.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		url        string
+		wantFailed string
+		wantRemedy string
+	}{
+		{"self-signed certificate", server.URL, "TLS certificate", "trust store"},
+		{"connection refused", closedURL, "Server reachable", "check that Immich is running"},
+		{"not a URL", "localhost:2283", "Configuration", "address of the Immich web UI"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checks := diagnose(&Config{ImmichURL: tt.url, APIKey: "test-key"})
+			var failed *doctorCheck
+			for i := range checks {
+				if checks[i].status == doctorFail {
+					failed = &checks[i]
+					break
+				}
+			}
+			if failed == nil || failed.name != tt.wantFailed || !strings.Contains(failed.remedy, tt.wantRemedy) {
+				t.Errorf("first failed check = %+v, want %s with a remedy containing %q", failed, tt.wantFailed, tt.wantRemedy)
+			}
+		})
+	}
+}
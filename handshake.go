@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+const (
+	// Oldest Immich release providing every endpoint the tool uses
+	minServerMajor = 1
+	minServerMinor = 106
+
+	// maxServerMajor is the newest major Immich release the tool was tested with
+	maxServerMajor = 2
+
+	// permissionAll grants every permission to an API key
+	permissionAll = "all"
+)
+
+// serverInfo is what the startup handshake learned about the server
+type serverInfo struct {
+	version     *immich.ServerVersion
+	keyName     string   // Name of the API key, empty if unknown
+	permissions []string // Permissions of the API key, nil if unknown or unrestricted
+	trashDays   *int     // Days deleted assets stay in the trash, nil if unknown
+}
+
+// probeServer pings the server and fetches its version, its trash setting
+// and the permissions of the API key. The permissions and the trash setting
+// are left unknown when the server does not provide them.
+func probeServer(config *Config) (*serverInfo, error) {
+	api := config.api()
+	if err := api.Ping(config.context()); err != nil {
+		return nil, fmt.Errorf("cannot reach %s: %w", config.ImmichURL, err)
+	}
+
+	version, err := api.GetServerVersion(config.context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the server version: %w", err)
+	}
+	info := &serverInfo{version: version}
+
+	// Scoped API keys appeared in later releases; older keys can do anything
+	key, err := api.GetCurrentAPIKey(config.context())
+	switch status := immich.StatusCode(err); {
+	case err == nil:
+		info.keyName = key.Name
+		if !containsString(key.Permissions, permissionAll) {
+			info.permissions = key.Permissions
+		}
+	case status == http.StatusUnauthorized:
+		return nil, fmt.Errorf("the API key was rejected: %w", err)
+	case status == http.StatusNotFound || status == http.StatusForbidden:
+		// Keys cannot be inspected: unscoped keys, or a key not allowed to read itself
+	default:
+		return nil, fmt.Errorf("failed to fetch the API key: %w", err)
+	}
+
+	serverConfig, err := api.GetServerConfig(config.context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the server configuration: %w", err)
+	}
+	info.trashDays = serverConfig.TrashDays
+
+	return info, nil
+}
+
+// checkServer performs the startup handshake for command: it refuses to run
+// destructive modes against unsupported server versions, checks that the API
+// key has the permissions command needs, and selects the request shapes
+// matching the server version for every later request.
+func checkServer(config *Config, command string) error {
+	info, err := probeServer(config)
+	if err != nil {
+		return err
+	}
+	config.serverVersion = info.version
+
+	if config.Verbose {
+		logInfo("🖥️  Immich server %s at %s", info.version, config.ImmichURL)
+	}
+
+	if err := checkServerVersion(*info.version); err != nil {
+		if isDestructive(config, command) && !config.SkipVersionCheck {
+			return fmt.Errorf("%w; refusing to change assets (use --dry-run, or --skip-version-check at your own risk)", err)
+		}
+		logWarning("⚠️  %v", err)
+	}
+
+	if missing := missingPermissions(info.permissions, requiredPermissions(config, command)); len(missing) > 0 {
+		return fmt.Errorf("API key %q lacks the permission(s) %s", info.keyName, strings.Join(missing, ", "))
+	}
+
+	if trashDisabled(info) && mayDelete(config, command) && !config.Permanent {
+		remedy := "pass --permanent"
+		if command == "apply" {
+			remedy = "make a new plan with --permanent"
+		}
+		return fmt.Errorf("the trash is disabled on the server, so deleted duplicates could not be restored; enable the trash in Immich or %s", remedy)
+	}
+
+	return nil
+}
+
+// checkServerVersion returns an error if the tool does not support version
+func checkServerVersion(version immich.ServerVersion) error {
+	switch {
+	case !version.AtLeast(minServerMajor, minServerMinor, 0):
+		return fmt.Errorf("server version %s is not supported (v%d.%d.0 or later is required)", version, minServerMajor, minServerMinor)
+	case version.Major > maxServerMajor:
+		return fmt.Errorf("server version %s is newer than the releases this tool was tested with (up to v%d)", version, maxServerMajor)
+	}
+	return nil
+}
+
+// trashDisabled reports whether the server deletes assets permanently
+func trashDisabled(info *serverInfo) bool {
+	return info.trashDays != nil && *info.trashDays == 0
+}
+
+// isDestructive reports whether command may delete, stack, update or restore
+// assets, or change albums beyond adding to them, with the flags of config
+func isDestructive(config *Config, command string) bool {
+	if config.DryRun {
+		return false
+	}
+	switch command {
+	case "":
+		return config.AutoDelete || config.Stack || config.Merge
+	case "apply", "tui", "serve", "not-duplicates", "undo":
+		return true
+	}
+	return false
+}
+
+// mayDelete reports whether command may delete assets with the flags of
+// config. The terminal and web UIs and plans delete without --auto-delete.
+func mayDelete(config *Config, command string) bool {
+	if !isDestructive(config, command) {
+		return false
+	}
+	switch command {
+	case "":
+		return config.AutoDelete
+	case "apply", "tui", "serve":
+		return true
+	}
+	return false
+}
+
+// requiredPermissions returns the API key permissions command needs with the
+// flags of config, or with the actions of the plan that apply applies. Actions
+// chosen while the command runs, such as those of the terminal and web UIs,
+// are not known up front.
+func requiredPermissions(config *Config, command string) []string {
+	switch command {
+	case "undo":
+		return []string{"albumAsset.delete", "trash.restore", "asset.update", "stack.delete", "tag.asset"}
+	case "not-duplicates":
+		return []string{"duplicate.read", "asset.update"}
+	}
+
+	permissions := []string{"duplicate.read", "asset.read", "album.read"}
+	if config.DryRun || command == "plan" || command == "html-report" {
+		return permissions
+	}
+	permissions = append(permissions, "albumAsset.create")
+	if command == "apply" && config.plan != nil {
+		return append(permissions, config.plan.permissions()...)
+	}
+	if command != "" {
+		return permissions
+	}
+
+	if config.AutoDelete {
+		permissions = append(permissions, "asset.delete")
+	}
+	if config.Stack {
+		permissions = append(permissions, "stack.create")
+	}
+	if config.Merge {
+		permissions = append(permissions, "asset.update", "tag.asset")
+	}
+	return permissions
+}
+
+// missingPermissions returns the required permissions not granted. Nil
+// granted permissions are unknown or unrestricted, and miss nothing.
+func missingPermissions(granted, required []string) []string {
+	if granted == nil {
+		return nil
+	}
+	missing := []string{}
+	for _, permission := range required {
+		if !containsString(granted, permission) && !containsString(missing, permission) {
+			missing = append(missing, permission)
+		}
+	}
+	return missing
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// serverMock answers the handshake requests with the given version, API key
+// and server configuration. An empty key answers HTTP 404 like servers
+// without scoped API keys.
+func serverMock(version, key, serverConfig string) *MockHTTPClient {
+	return &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			status, body := http.StatusOK, `{"res": "pong"}`
+			switch req.URL.Path {
+			case "/api/server/version":
+				body = version
+			case "/api/server/config":
+				body = serverConfig
+			case "/api/api-keys/me":
+				body = key
+				if key == "" {
+					status, body = http.StatusNotFound, `{"message": "Cannot GET /api/api-keys/me"}`
+				}
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		},
+	}
+}
+
+// TestCheckServer tests refusing to run against unsupported servers and with
+// API keys lacking permissions
+func TestCheckServer(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	const (
+		current = `{"major": 1, "minor": 120, "patch": 0}`
+		old     = `{"major": 1, "minor": 99, "patch": 0}`
+		trash   = `{"trashDays": 30}`
+		noTrash = `{"trashDays": 0}`
+		allKey  = `{"id": "k1", "name": "cleaner", "permissions": ["all"]}`
+		readKey = `{"id": "k1", "name": "read-only", "permissions": ["duplicate.read", "asset.read", "album.read"]}`
+	)
+
+	tests := []struct {
+		name    string
+		config  Config
+		command string
+		version string
+		key     string
+		server  string
+		wantErr string
+	}{
+		{"supported", Config{AutoDelete: true}, "", current, allKey, trash, ""},
+		{"unscoped key", Config{AutoDelete: true, Stack: true}, "", current, "", trash, ""},
+		{"old server dry run", Config{AutoDelete: true, DryRun: true}, "", old, "", trash, ""},
+		{"old server read only", Config{}, "plan", old, "", trash, ""},
+		{"old server destructive", Config{AutoDelete: true}, "", old, "", trash, "server version v1.99.0 is not supported"},
+		{"old server skipped check", Config{AutoDelete: true, SkipVersionCheck: true}, "", old, "", trash, ""},
+		{"old server apply", Config{}, "apply", old, "", trash, "refusing to change assets"},
+		{"read-only key dry run", Config{AutoDelete: true, DryRun: true}, "", current, readKey, trash, ""},
+		{"read-only key", Config{AutoDelete: true}, "", current, readKey, trash, `API key "read-only" lacks the permission(s) albumAsset.create, asset.delete`},
+		{"trash disabled", Config{AutoDelete: true}, "", current, allKey, noTrash, "the trash is disabled"},
+		{"trash disabled permanent", Config{AutoDelete: true, Permanent: true}, "", current, allKey, noTrash, ""},
+		{"trash disabled stack", Config{Stack: true}, "", current, allKey, noTrash, ""},
+		{"trash disabled serve", Config{}, "serve", current, allKey, noTrash, "the trash is disabled"},
+		{"trash disabled apply", Config{}, "apply", current, allKey, noTrash, "make a new plan with --permanent"},
+		{"trash disabled permanent plan", Config{Permanent: true}, "apply", current, allKey, noTrash, ""},
+		{"old server undo", Config{}, "undo", old, "", trash, "refusing to change assets"},
+		{"read-only key undo", Config{}, "undo", current, readKey, trash, "lacks the permission(s) albumAsset.delete, trash.restore, asset.update, stack.delete, tag.asset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpClient = serverMock(tt.version, tt.key, tt.server)
+			config := tt.config
+			config.ImmichURL = "http://localhost:2283"
+			config.APIKey = "test-key"
+
+			err := checkServer(&config, tt.command)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("checkServer() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("checkServer() error = %v", err)
+			}
+			if config.serverVersion == nil || config.api().Version != config.serverVersion {
+				t.Error("the server version should select the request shapes of later requests")
+			}
+		})
+	}
+}
+
+// TestCheckServerVersion tests the supported server releases
+func TestCheckServerVersion(t *testing.T) {
+	tests := []struct {
+		version immich.ServerVersion
+		wantErr string
+	}{
+		{immich.ServerVersion{Major: 1, Minor: 106, Patch: 0}, ""},
+		{immich.ServerVersion{Major: 1, Minor: 135, Patch: 3}, ""},
+		{immich.ServerVersion{Major: 2, Minor: 1, Patch: 0}, ""},
+		{immich.ServerVersion{Major: 1, Minor: 105, Patch: 9}, "is not supported"},
+		{immich.ServerVersion{Major: 3, Minor: 0, Patch: 0}, "is newer than"},
+	}
+	for _, tt := range tests {
+		err := checkServerVersion(tt.version)
+		if (err == nil) != (tt.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("checkServerVersion(%s) error = %v, want %q", tt.version, err, tt.wantErr)
+		}
+	}
+}
+
+// TestMissingPermissions tests finding the permissions an API key lacks
+func TestMissingPermissions(t *testing.T) {
+	required := requiredPermissions(&Config{AutoDelete: true, Merge: true}, "")
+	want := []string{"duplicate.read", "asset.read", "album.read", "albumAsset.create", "asset.delete", "asset.update", "tag.asset"}
+	if !reflect.DeepEqual(required, want) {
+		t.Errorf("requiredPermissions() = %v, want %v", required, want)
+	}
+
+	// apply needs the permissions of the actions of its plan
+	favorite := true
+	plan := &Plan{Groups: []GroupPlan{
+		{DuplicateID: "d1", Keeper: "a", Delete: []string{"b"}},
+		{DuplicateID: "d2", Keeper: "c", Merge: &MetadataMerge{Update: immich.UpdateAssetRequest{IsFavorite: &favorite}}},
+	}}
+	required = requiredPermissions(&Config{plan: plan}, "apply")
+	want = []string{"duplicate.read", "asset.read", "album.read", "albumAsset.create", "asset.delete", "asset.update"}
+	if !reflect.DeepEqual(required, want) {
+		t.Errorf("requiredPermissions(apply) = %v, want %v", required, want)
+	}
+	plan.Groups = append(plan.Groups, GroupPlan{DuplicateID: "d3", Keeper: "e", Stack: []string{"e", "f"}, Merge: &MetadataMerge{TagIDs: []string{"t1"}}})
+	required = requiredPermissions(&Config{plan: plan}, "apply")
+	want = []string{"duplicate.read", "asset.read", "album.read", "albumAsset.create", "asset.delete", "stack.create", "asset.update", "tag.asset"}
+	if !reflect.DeepEqual(required, want) {
+		t.Errorf("requiredPermissions(apply) = %v, want %v", required, want)
+	}
+
+	required = requiredPermissions(&Config{AutoDelete: true, Merge: true}, "")
+	if missing := missingPermissions(nil, required); len(missing) != 0 {
+		t.Errorf("missingPermissions(nil) = %v, want none for unrestricted keys", missing)
+	}
+	granted := []string{"duplicate.read", "asset.read", "album.read", "albumAsset.create", "asset.update"}
+	if missing := missingPermissions(granted, required); !reflect.DeepEqual(missing, []string{"asset.delete", "tag.asset"}) {
+		t.Errorf("missingPermissions() = %v, want [asset.delete tag.asset]", missing)
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"mime"
+	"os"
+	"strings"
+	"time"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// reviewPage is the data rendered by the HTML review report
+type reviewPage struct {
+	Server      string
+	Policy      string
+	GeneratedAt time.Time
+	Groups      []reviewGroup
+}
+
+// reviewGroup is a duplicate group shown side by side in the review report
+type reviewGroup struct {
+	Index       int
+	DuplicateID string
+	Assets      []reviewAsset
+	Warnings    []string
+}
+
+// reviewAsset is an asset of a duplicate group in the review report
+type reviewAsset struct {
+	ID        string
+	Details   *immich.AssetDetails // nil if the details could not be fetched
+	Albums    []immich.Album
+	Thumbnail template.URL // Data URI of the thumbnail, empty if unavailable
+	Score     *ScoredCandidate
+	Keep      bool
+}
+
+// writeHTMLReport renders every duplicate group with the thumbnails, EXIF
+// data and albums of its assets, and the asset the quality policy would
+// keep, as a self-contained HTML file. Nothing is changed on the server.
+func writeHTMLReport(config *Config, path string) error {
+	policy, err := newQualityPolicy(config)
+	if err != nil {
+		return err
+	}
+
+	logInfo("🔍 Fetching duplicate groups...")
+	duplicates, err := config.api().GetDuplicates(config.context())
+	if err != nil {
+		return fmt.Errorf("failed to fetch duplicates: %w", err)
+	}
+
+	logInfo("🖼️  Building review report for %d duplicate group(s)...", len(duplicates))
+	page := reviewPage{
+		Server:      config.ImmichURL,
+		Policy:      policy.Name(),
+		GeneratedAt: time.Now(),
+		Groups:      make([]reviewGroup, 0, len(duplicates)),
+	}
+	for i, group := range duplicates {
+		review := buildReviewGroup(config, policy, i+1, group)
+		embedThumbnails(config, &review)
+		page.Groups = append(page.Groups, review)
+	}
+
+	var buf bytes.Buffer
+	if err := reviewTemplate.Execute(&buf, page); err != nil {
+		return fmt.Errorf("failed to render report: %w", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+
+	logInfo("📄 Review report written to %s", path)
+	return nil
+}
+
+// buildReviewGroup fetches the details, albums and scores of the assets of a
+// group. Failures are reported on the group instead of aborting the review.
+func buildReviewGroup(config *Config, policy QualityPolicy, index int, group immich.DuplicateGroup) reviewGroup {
+	review := reviewGroup{Index: index, DuplicateID: group.DuplicateID}
+
+	candidates := []Candidate{}
+	for _, asset := range group.Assets {
+		item := reviewAsset{ID: asset.ID}
+
+		albums, err := albumsForAsset(config, asset.ID)
+		if err != nil {
+			review.warn(config, "Failed to fetch albums for asset %s: %v", truncateID(asset.ID), err)
+		}
+		item.Albums = albums
+
+		details, err := config.api().GetAssetDetails(config.context(), asset.ID)
+		if err != nil {
+			review.warn(config, "Failed to fetch details for asset %s: %v", truncateID(asset.ID), err)
+		} else {
+			item.Details = details
+			candidates = append(candidates, Candidate{Details: details, AlbumCount: len(albums)})
+		}
+
+		review.Assets = append(review.Assets, item)
+	}
+
+	if len(candidates) < 2 {
+		return review
+	}
+	ranked := policy.Rank(candidates)
+	for i := range review.Assets {
+		for r := range ranked {
+			if ranked[r].ID == review.Assets[i].ID {
+				review.Assets[i].Score = &ranked[r]
+				review.Assets[i].Keep = r == 0
+			}
+		}
+	}
+
+	return review
+}
+
+// embedThumbnails fetches the thumbnails of the assets of a group as data URIs
+func embedThumbnails(config *Config, review *reviewGroup) {
+	for i := range review.Assets {
+		item := &review.Assets[i]
+		thumbnail, err := config.api().GetThumbnail(config.context(), item.ID)
+		if err != nil {
+			review.warn(config, "Failed to fetch thumbnail for asset %s: %v", truncateID(item.ID), err)
+		} else if uri, ok := thumbnailURI(thumbnail); ok {
+			item.Thumbnail = uri
+		} else {
+			review.warn(config, "Unsupported thumbnail type %q for asset %s", thumbnail.ContentType, truncateID(item.ID))
+		}
+	}
+}
+
+// warn logs a warning and shows it on the group
+func (r *reviewGroup) warn(config *Config, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	config.logWarning("⚠️  %s", message)
+	r.Warnings = append(r.Warnings, message)
+}
+
+// thumbnailURI embeds a thumbnail as a data URI. Only image types are
+// accepted so that the report cannot embed active content.
+func thumbnailURI(thumbnail *immich.Thumbnail) (template.URL, bool) {
+	mediaType, ok := thumbnailType(thumbnail)
+	if !ok {
+		return "", false
+	}
+	return template.URL("data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(thumbnail.Data)), true
+}
+
+// thumbnailType returns the media type of a thumbnail if it is a raster image
+func thumbnailType(thumbnail *immich.Thumbnail) (string, bool) {
+	mediaType, _, err := mime.ParseMediaType(thumbnail.ContentType)
+	if err != nil || !strings.HasPrefix(mediaType, "image/") || mediaType == "image/svg+xml" {
+		return "", false
+	}
+	return mediaType, true
+}
+
+// formatBytes renders a size in bytes with a binary unit
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// formatCoordinates renders a GPS position, or an empty string if unknown
+func formatCoordinates(latitude, longitude *float64) string {
+	if latitude == nil || longitude == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.5f, %.5f", *latitude, *longitude)
+}
+
+var reviewTemplate = template.Must(template.New("review").Funcs(template.FuncMap{
+	"bytes":       formatBytes,
+	"breakdown":   formatBreakdown,
+	"short":       truncateID,
+	"coordinates": formatCoordinates,
+	"stars":       func(rating *int) int { return *rating },
+}).Parse(reviewHTML))
+
+const reviewHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Duplicate review - {{.Server}}</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; background: #f5f5f7; color: #1d1d1f; }
+header p { color: #6e6e73; margin: 0.25rem 0; }
+section { background: #fff; border-radius: 12px; padding: 1rem 1.5rem; margin: 1.5rem 0; box-shadow: 0 1px 3px rgba(0,0,0,.1); }
+h2 { font-size: 1.1rem; margin: 0 0 1rem; }
+h2 code { color: #6e6e73; font-weight: normal; }
+.assets { display: flex; gap: 1rem; flex-wrap: wrap; }
+.asset { flex: 0 1 260px; border: 2px solid #e5e5ea; border-radius: 10px; padding: 0.75rem; }
+.asset.keep { border-color: #34c759; }
+.asset img, .asset .missing { width: 100%; height: 200px; object-fit: contain; background: #f0f0f3; border-radius: 6px; }
+.asset .missing { display: flex; align-items: center; justify-content: center; color: #8e8e93; }
+.badge { display: inline-block; padding: 0.1rem 0.5rem; border-radius: 999px; font-size: 0.8rem; background: #e5e5ea; }
+.keep .badge { background: #34c759; color: #fff; }
+dl { display: grid; grid-template-columns: max-content 1fr; gap: 0.2rem 0.75rem; font-size: 0.85rem; margin: 0.75rem 0 0; }
+dt { color: #6e6e73; }
+dd { margin: 0; overflow-wrap: anywhere; }
+.warnings { color: #c93400; font-size: 0.85rem; }
+</style>
+</head>
+<body>
+<header>
+<h1>Duplicate review</h1>
+<p>Server: {{.Server}}</p>
+<p>Policy: {{.Policy}} &middot; {{len .Groups}} group(s) &middot; generated {{.GeneratedAt.Format "2006-01-02 15:04:05"}}</p>
+</header>
+{{range .Groups}}
+<section id="group-{{.Index}}">
+<h2>Group {{.Index}} <code>{{.DuplicateID}}</code></h2>
+<div class="assets">
+{{range .Assets}}
+<div class="asset{{if .Keep}} keep{{end}}">
+{{if .Thumbnail}}<img src="{{.Thumbnail}}" alt="Thumbnail of {{.ID}}">{{else}}<div class="missing">No thumbnail</div>{{end}}
+<p><span class="badge">{{if .Keep}}Keep{{else if .Score}}Delete{{else}}Not compared{{end}}</span>{{with .Score}} score {{printf "%.2f" .Total}}{{end}}</p>
+<dl>
+<dt>ID</dt><dd><code title="{{.ID}}">{{short .ID}}</code></dd>
+{{with .Details}}
+<dt>File</dt><dd>{{.OriginalFileName}}</dd>
+<dt>Created</dt><dd>{{.FileCreatedAt.Format "2006-01-02 15:04:05"}}</dd>
+{{with .ExifInfo}}
+<dt>Size</dt><dd>{{bytes .FileSizeInByte}}</dd>
+{{if .ImageWidth}}<dt>Resolution</dt><dd>{{.ImageWidth}} &times; {{.ImageHeight}}</dd>{{end}}
+{{if .BitsPerSample}}<dt>Bit depth</dt><dd>{{.BitsPerSample}}</dd>{{end}}
+{{if or .Make .Model}}<dt>Camera</dt><dd>{{.Make}} {{.Model}}</dd>{{end}}
+{{with coordinates .Latitude .Longitude}}<dt>GPS</dt><dd>{{.}}</dd>{{end}}
+{{with .Rating}}<dt>Rating</dt><dd>{{stars .}}</dd>{{end}}
+{{if .Description}}<dt>Description</dt><dd>{{.Description}}</dd>{{end}}
+{{end}}
+{{if .IsFavorite}}<dt>Favorite</dt><dd>yes</dd>{{end}}
+{{if .IsArchived}}<dt>Archived</dt><dd>yes</dd>{{end}}
+{{end}}
+<dt>Albums</dt><dd>{{range $i, $album := .Albums}}{{if $i}}, {{end}}{{$album.AlbumName}}{{else}}none{{end}}</dd>
+{{with .Score}}<dt>Breakdown</dt><dd>{{breakdown .Breakdown}}</dd>{{if .Reason}}<dt>Reason</dt><dd>{{.Reason}}</dd>{{end}}{{end}}
+</dl>
+</div>
+{{end}}
+</div>
+{{with .Warnings}}<ul class="warnings">{{range .}}<li>{{.}}</li>{{end}}</ul>{{end}}
+</section>
+{{else}}
+<p>No duplicates found.</p>
+{{end}}
+</body>
+</html>
+`
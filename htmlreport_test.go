@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// TestWriteHTMLReport tests that the review report embeds every group
+func TestWriteHTMLReport(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe0}
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if req.Method != "GET" {
+				t.Errorf("Unexpected request %s %s", req.Method, req.URL)
+			}
+
+			header := http.Header{"Content-Type": {"application/json"}}
+			body := `[]`
+			switch req.URL.Path {
+			case "/api/duplicates":
+				body = `[{"duplicateId": "dup1", "assets": [{"id": "big"}, {"id": "small"}]}]`
+			case "/api/albums":
+				if req.URL.Query().Get("assetId") == "small" {
+					body = `[{"id": "album1", "albumName": "Vacation"}]`
+				}
+			case "/api/assets/big":
+				body = `{"id": "big", "originalFileName": "big.jpg", "exifInfo": {"fileSizeInByte": 2048, "make": "Canon", "description": "<script>alert(1)</script>"}}`
+			case "/api/assets/small":
+				body = `{"id": "small", "originalFileName": "small.jpg", "exifInfo": {"fileSizeInByte": 1024}}`
+			case "/api/assets/big/thumbnail":
+				header.Set("Content-Type", "image/jpeg")
+				body = string(jpeg)
+			case "/api/assets/small/thumbnail":
+				header.Set("Content-Type", "text/html")
+				body = `<html></html>`
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     header,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+			}, nil
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "review.html")
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key"}
+	if err := writeHTMLReport(config, path); err != nil {
+		t.Fatalf("writeHTMLReport() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	page := string(data)
+
+	for _, want := range []string{
+		`id="group-1"`,
+		`<img src="data:image/jpeg;base64,` + base64.StdEncoding.EncodeToString(jpeg) + `"`,
+		`<div class="asset keep">`,
+		`big.jpg`,
+		`2.0 KiB`,
+		`Canon`,
+		`Vacation`,
+		`No thumbnail`,
+		`Unsupported thumbnail type &#34;text/html&#34;`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("report does not contain %q", want)
+		}
+	}
+	if strings.Contains(page, "<script>") {
+		t.Error("report does not escape asset descriptions")
+	}
+}
+
+// TestThumbnailURI tests that only images are embedded
+func TestThumbnailURI(t *testing.T) {
+	tests := []struct {
+		contentType string
+		wantOK      bool
+	}{
+		{"image/jpeg", true},
+		{"image/webp; charset=binary", true},
+		{"image/svg+xml", false},
+		{"text/html", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		uri, ok := thumbnailURI(&immich.Thumbnail{Data: []byte("x"), ContentType: tt.contentType})
+		if ok != tt.wantOK {
+			t.Errorf("thumbnailURI(%q) ok = %v, want %v", tt.contentType, ok, tt.wantOK)
+		}
+		if ok && !strings.HasPrefix(string(uri), "data:image/") {
+			t.Errorf("thumbnailURI(%q) = %q, want a data URI", tt.contentType, uri)
+		}
+	}
+}
+
+// TestFormatBytes tests human-readable sizes
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+
+	for _, tt := range tests {
+		if got := formatBytes(tt.size); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,366 @@
+// Package immich provides a typed client for the parts of the Immich API used
+// to manage duplicate assets.
+package immich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// API endpoint paths
+	duplicatesEndpoint = "/api/duplicates"
+	albumsEndpoint     = "/api/albums"
+	assetsEndpoint     = "/api/assets"
+	trashEndpoint      = "/api/trash"
+	stacksEndpoint     = "/api/stacks"
+	tagsEndpoint       = "/api/tags"
+	usersEndpoint      = "/api/users"
+	serverEndpoint     = "/api/server"
+	apiKeysEndpoint    = "/api/api-keys"
+
+	// legacyServerEndpoint is the server endpoint before v1.107.0
+	legacyServerEndpoint = "/api/server-info"
+
+	// DefaultTimeout is the timeout of the default HTTP client
+	DefaultTimeout = 30 * time.Second
+
+	// Largest thumbnail accepted, in bytes
+	maxThumbnailSize = 10 << 20
+)
+
+// HTTPClient interface for easier testing
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Client is an Immich API client
+type Client struct {
+	BaseURL    string         // Base URL of the Immich instance, without trailing slash
+	APIKey     string         // API key for authentication
+	HTTPClient HTTPClient     // Client used to send requests
+	UserAgent  string         // User-Agent header, omitted if empty
+	Version    *ServerVersion // Server release selecting the request shapes, nil assumes the latest
+}
+
+// NewClient returns a client for the Immich instance at baseURL
+func NewClient(baseURL, apiKey string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		APIKey:     apiKey,
+		HTTPClient: &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// GetDuplicates fetches all duplicate groups
+func (c *Client) GetDuplicates(ctx context.Context) ([]DuplicateGroup, error) {
+	var duplicates []DuplicateGroup
+	if err := c.do(ctx, "GET", duplicatesEndpoint, nil, &duplicates, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return duplicates, nil
+}
+
+// Ping checks that the server answers API requests. It does not need a
+// valid API key.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.getServerInfo(ctx, "/ping", nil)
+}
+
+// GetServerVersion fetches the release of the server
+func (c *Client) GetServerVersion(ctx context.Context) (*ServerVersion, error) {
+	var version ServerVersion
+	if err := c.getServerInfo(ctx, "/version", &version); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// GetServerConfig fetches the server settings that change how requests behave
+func (c *Client) GetServerConfig(ctx context.Context) (*ServerConfig, error) {
+	var config ServerConfig
+	if err := c.getServerInfo(ctx, "/config", &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// GetServerFeatures fetches the features enabled on the server
+func (c *Client) GetServerFeatures(ctx context.Context) (*ServerFeatures, error) {
+	var features ServerFeatures
+	if err := c.getServerInfo(ctx, "/features", &features); err != nil {
+		return nil, err
+	}
+	return &features, nil
+}
+
+// getServerInfo fetches a server information endpoint, falling back to its
+// path before v1.107.0 if the server does not know the current one
+func (c *Client) getServerInfo(ctx context.Context, path string, out interface{}) error {
+	err := c.do(ctx, "GET", serverEndpoint+path, nil, out, http.StatusOK)
+	if StatusCode(err) == http.StatusNotFound {
+		err = c.do(ctx, "GET", legacyServerEndpoint+path, nil, out, http.StatusOK)
+	}
+	return err
+}
+
+// GetCurrentAPIKey fetches the API key used by the client with its
+// permissions. Servers without scoped API keys answer with HTTP 404.
+func (c *Client) GetCurrentAPIKey(ctx context.Context) (*APIKey, error) {
+	var key APIKey
+	if err := c.do(ctx, "GET", apiKeysEndpoint+"/me", nil, &key, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetCurrentUser fetches the user owning the API key
+func (c *Client) GetCurrentUser(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.do(ctx, "GET", usersEndpoint+"/me", nil, &user, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetAlbumsForAsset fetches all albums containing a specific asset
+func (c *Client) GetAlbumsForAsset(ctx context.Context, assetID string) ([]Album, error) {
+	path := albumsEndpoint + "?" + url.Values{"assetId": {assetID}}.Encode()
+
+	var albums []Album
+	if err := c.do(ctx, "GET", path, nil, &albums, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return albums, nil
+}
+
+// GetAlbums fetches every album, without their assets
+func (c *Client) GetAlbums(ctx context.Context) ([]Album, error) {
+	var albums []Album
+	if err := c.do(ctx, "GET", albumsEndpoint, nil, &albums, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return albums, nil
+}
+
+// GetAlbum fetches an album with its assets
+func (c *Client) GetAlbum(ctx context.Context, albumID string) (*Album, error) {
+	var album Album
+	if err := c.do(ctx, "GET", albumsEndpoint+"/"+url.PathEscape(albumID), nil, &album, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &album, nil
+}
+
+// GetAssetDetails fetches detailed information about an asset
+func (c *Client) GetAssetDetails(ctx context.Context, assetID string) (*AssetDetails, error) {
+	var details AssetDetails
+	if err := c.do(ctx, "GET", assetsEndpoint+"/"+url.PathEscape(assetID), nil, &details, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &details, nil
+}
+
+// AddAssetsToAlbum adds assets to an album and returns the outcome for each
+// asset. A request that succeeds may still fail for some of the assets.
+func (c *Client) AddAssetsToAlbum(ctx context.Context, albumID string, assetIDs []string) ([]BulkIDResult, error) {
+	path := albumsEndpoint + "/" + url.PathEscape(albumID) + "/assets"
+
+	var results []BulkIDResult
+	if err := c.do(ctx, "PUT", path, BulkIDsRequest{IDs: assetIDs}, &results, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// RemoveAssetsFromAlbum removes assets from an album
+func (c *Client) RemoveAssetsFromAlbum(ctx context.Context, albumID string, assetIDs []string) error {
+	path := albumsEndpoint + "/" + url.PathEscape(albumID) + "/assets"
+	return c.do(ctx, "DELETE", path, BulkIDsRequest{IDs: assetIDs}, nil, http.StatusOK)
+}
+
+// DeleteAssets deletes assets in a single request. Assets are moved to the
+// trash unless force is set.
+func (c *Client) DeleteAssets(ctx context.Context, assetIDs []string, force bool) error {
+	body := DeleteAssetsRequest{IDs: assetIDs, Force: force}
+	return c.do(ctx, "DELETE", assetsEndpoint, body, nil, http.StatusNoContent, http.StatusOK)
+}
+
+// RestoreAssets restores assets from the trash
+func (c *Client) RestoreAssets(ctx context.Context, assetIDs []string) error {
+	return c.do(ctx, "POST", trashEndpoint+"/restore/assets", BulkIDsRequest{IDs: assetIDs}, nil, http.StatusOK)
+}
+
+// UpdateAsset updates the metadata of an asset
+func (c *Client) UpdateAsset(ctx context.Context, assetID string, update UpdateAssetRequest) error {
+	return c.do(ctx, "PUT", assetsEndpoint+"/"+url.PathEscape(assetID), update, nil, http.StatusOK)
+}
+
+// SetDuplicateID moves assets to a duplicate group. An empty duplicateID
+// clears their duplicate status, so that the server no longer reports them as
+// duplicates.
+func (c *Client) SetDuplicateID(ctx context.Context, assetIDs []string, duplicateID string) error {
+	update := UpdateAssetsRequest{IDs: assetIDs}
+	if duplicateID != "" {
+		update.DuplicateID = &duplicateID
+	}
+	return c.do(ctx, "PUT", assetsEndpoint, update, nil, http.StatusNoContent, http.StatusOK)
+}
+
+// TagAssets adds a tag to assets
+func (c *Client) TagAssets(ctx context.Context, tagID string, assetIDs []string) error {
+	path := tagsEndpoint + "/" + url.PathEscape(tagID) + "/assets"
+	return c.do(ctx, "PUT", path, BulkIDsRequest{IDs: assetIDs}, nil, http.StatusOK)
+}
+
+// UntagAssets removes a tag from assets
+func (c *Client) UntagAssets(ctx context.Context, tagID string, assetIDs []string) error {
+	path := tagsEndpoint + "/" + url.PathEscape(tagID) + "/assets"
+	return c.do(ctx, "DELETE", path, BulkIDsRequest{IDs: assetIDs}, nil, http.StatusOK)
+}
+
+// GetThumbnail fetches the thumbnail image of an asset
+func (c *Client) GetThumbnail(ctx context.Context, assetID string) (thumbnail *Thumbnail, err error) {
+	path := assetsEndpoint + "/" + url.PathEscape(assetID) + "/thumbnail?" + url.Values{"size": {"thumbnail"}}.Encode()
+
+	resp, err := c.send(ctx, "GET", path, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close response body: %w", closeErr)
+		}
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxThumbnailSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read thumbnail: %w", err)
+	}
+	if len(data) > maxThumbnailSize {
+		return nil, fmt.Errorf("thumbnail larger than %d bytes", maxThumbnailSize)
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return &Thumbnail{Data: data, ContentType: contentType}, nil
+}
+
+// CreateStack stacks assets together, using the first asset as the primary
+func (c *Client) CreateStack(ctx context.Context, assetIDs []string) (*Stack, error) {
+	if c.Version != nil && !c.Version.AtLeast(1, 113, 0) && len(assetIDs) > 0 {
+		// Before v1.113.0 stacks were created by updating the stacked assets
+		request := legacyStackRequest{IDs: assetIDs[1:], StackParentID: assetIDs[0]}
+		if err := c.do(ctx, "PUT", assetsEndpoint, request, nil, http.StatusNoContent, http.StatusOK); err != nil {
+			return nil, err
+		}
+		return &Stack{PrimaryAssetID: assetIDs[0]}, nil
+	}
+
+	var stack Stack
+	if err := c.do(ctx, "POST", stacksEndpoint, CreateStackRequest{AssetIDs: assetIDs}, &stack, http.StatusCreated, http.StatusOK); err != nil {
+		return nil, err
+	}
+	return &stack, nil
+}
+
+// DeleteStack removes a stack, leaving its assets unstacked
+func (c *Client) DeleteStack(ctx context.Context, stackID string) error {
+	return c.do(ctx, "DELETE", stacksEndpoint+"/"+url.PathEscape(stackID), nil, nil, http.StatusNoContent, http.StatusOK)
+}
+
+// do sends a request with an optional JSON body, checks that the response
+// status is one of expected and decodes the JSON response into out if set
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}, expected ...int) (err error) {
+	resp, err := c.send(ctx, method, path, body, expected...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close response body: %w", closeErr)
+		}
+	}()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// send sends a request with an optional JSON body and checks that the
+// response status is one of expected. The caller closes the response body.
+func (c *Client) send(ctx context.Context, method, path string, body interface{}, expected ...int) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("x-api-key", c.APIKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+
+	if !containsStatus(expected, resp.StatusCode) {
+		apiErr := &APIError{Method: method, Path: path, StatusCode: resp.StatusCode}
+		respBody, err := io.ReadAll(resp.Body)
+		if closeErr := resp.Body.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w (failed to read response body: %v)", apiErr, err)
+		}
+		apiErr.Body = c.redact(string(respBody))
+		return nil, apiErr
+	}
+
+	return resp, nil
+}
+
+// redact removes the API key from text received from the server, such as
+// error bodies echoing the request headers
+func (c *Client) redact(s string) string {
+	if c.APIKey == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, c.APIKey, "[REDACTED]")
+}
+
+func containsStatus(statuses []int, status int) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
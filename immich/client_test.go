@@ -0,0 +1,595 @@
+package immich
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// MockHTTPClient is a mock implementation of HTTPClient for testing
+type MockHTTPClient struct {
+	DoFunc func(req *http.Request) (*http.Response, error)
+}
+
+func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return m.DoFunc(req)
+}
+
+// TestClientGetDuplicates tests the GetDuplicates method with a mock HTTP client
+func TestClientGetDuplicates(t *testing.T) {
+	tests := []struct {
+		name       string
+		mockResp   *http.Response
+		mockErr    error
+		wantGroups int
+		wantErr    bool
+	}{
+		{
+			name: "successful response",
+			mockResp: &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(bytes.NewBufferString(`[
+					{
+						"duplicateId": "dup1",
+						"assets": [
+							{"id": "asset1"},
+							{"id": "asset2"}
+						]
+					}
+				]`)),
+			},
+			wantGroups: 1,
+			wantErr:    false,
+		},
+		{
+			name: "empty response",
+			mockResp: &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`[]`)),
+			},
+			wantGroups: 0,
+			wantErr:    false,
+		},
+		{
+			name: "HTTP error",
+			mockResp: &http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"message": "Unauthorized"}`)),
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient("http://localhost:2283", "test-key")
+			client.HTTPClient = &MockHTTPClient{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					return tt.mockResp, tt.mockErr
+				},
+			}
+
+			groups, err := client.GetDuplicates(context.Background())
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetDuplicates() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && len(groups) != tt.wantGroups {
+				t.Errorf("GetDuplicates() returned %d groups, want %d", len(groups), tt.wantGroups)
+			}
+		})
+	}
+}
+
+// TestClientGetAlbumsForAsset tests the GetAlbumsForAsset method
+func TestClientGetAlbumsForAsset(t *testing.T) {
+	mockAlbums := []Album{
+		{ID: "album1", AlbumName: "Vacation", AssetCount: 10},
+		{ID: "album2", AlbumName: "Family", AssetCount: 20},
+	}
+
+	albumsJSON, _ := json.Marshal(mockAlbums)
+
+	client := NewClient("http://localhost:2283", "test-key")
+
+	client.HTTPClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBuffer(albumsJSON)),
+			}, nil
+		},
+	}
+
+	albums, err := client.GetAlbumsForAsset(context.Background(), "asset1")
+	if err != nil {
+		t.Errorf("GetAlbumsForAsset() error = %v", err)
+		return
+	}
+
+	if len(albums) != 2 {
+		t.Errorf("GetAlbumsForAsset() returned %d albums, want 2", len(albums))
+	}
+
+	if albums[0].AlbumName != "Vacation" {
+		t.Errorf("First album name = %s, want Vacation", albums[0].AlbumName)
+	}
+}
+
+// TestClientGetAssetDetails tests the GetAssetDetails method
+func TestClientGetAssetDetails(t *testing.T) {
+	mockAsset := &AssetDetails{
+		ID:               "asset1",
+		OriginalFileName: "photo.jpg",
+		FileCreatedAt:    time.Now(),
+		ExifInfo: &ExifInfo{
+			FileSizeInByte: 1500000,
+			ImageWidth:     1920,
+			ImageHeight:    1080,
+		},
+	}
+
+	assetJSON, _ := json.Marshal(mockAsset)
+
+	client := NewClient("http://localhost:2283", "test-key")
+
+	client.HTTPClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBuffer(assetJSON)),
+			}, nil
+		},
+	}
+
+	details, err := client.GetAssetDetails(context.Background(), "asset1")
+	if err != nil {
+		t.Errorf("GetAssetDetails() error = %v", err)
+		return
+	}
+
+	if details.ID != "asset1" {
+		t.Errorf("Asset ID = %s, want asset1", details.ID)
+	}
+
+	if details.ExifInfo.FileSizeInByte != 1500000 {
+		t.Errorf("File size = %d, want 1500000", details.ExifInfo.FileSizeInByte)
+	}
+}
+
+// TestClientAddAssetsToAlbum tests the AddAssetsToAlbum method
+func TestClientAddAssetsToAlbum(t *testing.T) {
+	client := NewClient("http://localhost:2283", "test-key")
+
+	var capturedRequest *http.Request
+
+	client.HTTPClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			capturedRequest = req
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body: io.NopCloser(bytes.NewBufferString(`[
+					{"id": "asset1", "success": true},
+					{"id": "asset2", "success": false, "error": "duplicate"},
+					{"id": "asset3", "success": false, "error": "no_permission"}
+				]`)),
+			}, nil
+		},
+	}
+
+	assetIDs := []string{"asset1", "asset2", "asset3"}
+	results, err := client.AddAssetsToAlbum(context.Background(), "album1", assetIDs)
+
+	if err != nil {
+		t.Errorf("AddAssetsToAlbum() error = %v", err)
+		return
+	}
+
+	want := []BulkIDResult{
+		{ID: "asset1", Success: true},
+		{ID: "asset2", Error: BulkErrorDuplicate},
+		{ID: "asset3", Error: BulkErrorNoPermission},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("AddAssetsToAlbum() = %+v, want %+v", results, want)
+	}
+
+	// Verify request method
+	if capturedRequest.Method != "PUT" {
+		t.Errorf("Request method = %s, want PUT", capturedRequest.Method)
+	}
+
+	// Verify request body
+	var body BulkIDsRequest
+	bodyBytes, _ := io.ReadAll(capturedRequest.Body)
+	json.Unmarshal(bodyBytes, &body)
+
+	if len(body.IDs) != 3 {
+		t.Errorf("Request body contains %d IDs, want 3", len(body.IDs))
+	}
+}
+
+// TestDeleteAssets tests the DeleteAssets method
+func TestClientDeleteAssets(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		wantErr    bool
+	}{
+		{"successful delete - 204", http.StatusNoContent, false},
+		{"successful delete - 200", http.StatusOK, false},
+		{"failed delete - 404", http.StatusNotFound, true},
+		{"failed delete - 500", http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient("http://localhost:2283", "test-key")
+
+			client.HTTPClient = &MockHTTPClient{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					return &http.Response{
+						StatusCode: tt.statusCode,
+						Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
+					}, nil
+				},
+			}
+
+			err := client.DeleteAssets(context.Background(), []string{"asset1"}, false)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DeleteAssets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestClientDeleteAssetsPayload tests that deletions are batched and honour the trash mode
+func TestClientDeleteAssetsPayload(t *testing.T) {
+	tests := []struct {
+		name      string
+		permanent bool
+	}{
+		{"move to trash", false},
+		{"permanent delete", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient("http://localhost:2283", "test-key")
+
+			requests := 0
+			var body DeleteAssetsRequest
+			client.HTTPClient = &MockHTTPClient{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					requests++
+					bodyBytes, _ := io.ReadAll(req.Body)
+					json.Unmarshal(bodyBytes, &body)
+					return &http.Response{
+						StatusCode: http.StatusNoContent,
+						Body:       io.NopCloser(bytes.NewBufferString(``)),
+					}, nil
+				},
+			}
+
+			if err := client.DeleteAssets(context.Background(), []string{"asset1", "asset2", "asset3"}, tt.permanent); err != nil {
+				t.Fatalf("DeleteAssets() error = %v", err)
+			}
+
+			if requests != 1 {
+				t.Errorf("DeleteAssets() sent %d requests, want 1", requests)
+			}
+			if len(body.IDs) != 3 {
+				t.Errorf("Request body contains %d IDs, want 3", len(body.IDs))
+			}
+			if body.Force != tt.permanent {
+				t.Errorf("Request force = %v, want %v", body.Force, tt.permanent)
+			}
+		})
+	}
+}
+
+// TestClientSetDuplicateID tests that clearing the duplicate group sends null
+func TestClientSetDuplicateID(t *testing.T) {
+	tests := []struct {
+		name        string
+		duplicateID string
+		want        string
+	}{
+		{"clear", "", `{"ids":["asset1","asset2"],"duplicateId":null}`},
+		{"restore", "dup1", `{"ids":["asset1","asset2"],"duplicateId":"dup1"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient("http://localhost:2283", "test-key")
+
+			var method, path, body string
+			client.HTTPClient = &MockHTTPClient{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					bodyBytes, err := io.ReadAll(req.Body)
+					if err != nil {
+						t.Fatalf("failed to read request body: %v", err)
+					}
+					method, path, body = req.Method, req.URL.Path, strings.TrimSpace(string(bodyBytes))
+					return &http.Response{
+						StatusCode: http.StatusNoContent,
+						Body:       io.NopCloser(bytes.NewBufferString(``)),
+					}, nil
+				},
+			}
+
+			if err := client.SetDuplicateID(context.Background(), []string{"asset1", "asset2"}, tt.duplicateID); err != nil {
+				t.Fatalf("SetDuplicateID() error = %v", err)
+			}
+			if method != "PUT" || path != "/api/assets" || body != tt.want {
+				t.Errorf("request = %s %s %s, want PUT /api/assets %s", method, path, body, tt.want)
+			}
+		})
+	}
+}
+
+// TestClientCreateStack tests the CreateStack method
+func TestClientCreateStack(t *testing.T) {
+	client := NewClient("http://localhost:2283", "test-key")
+
+	var capturedRequest *http.Request
+	var body CreateStackRequest
+
+	client.HTTPClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			capturedRequest = req
+			bodyBytes, _ := io.ReadAll(req.Body)
+			json.Unmarshal(bodyBytes, &body)
+			return &http.Response{
+				StatusCode: http.StatusCreated,
+				Body:       io.NopCloser(bytes.NewBufferString(`{"id": "stack1", "primaryAssetId": "asset2"}`)),
+			}, nil
+		},
+	}
+
+	stack, err := client.CreateStack(context.Background(), []string{"asset2", "asset1"})
+	if err != nil {
+		t.Fatalf("CreateStack() error = %v", err)
+	}
+
+	if capturedRequest.Method != "POST" {
+		t.Errorf("Request method = %s, want POST", capturedRequest.Method)
+	}
+	if len(body.AssetIDs) != 2 || body.AssetIDs[0] != "asset2" {
+		t.Errorf("Request asset IDs = %v, want primary asset2 first", body.AssetIDs)
+	}
+	if stack.PrimaryAssetID != "asset2" {
+		t.Errorf("Stack primary asset = %s, want asset2", stack.PrimaryAssetID)
+	}
+}
+
+// TestClientLegacyCreateStack tests stacking assets on servers older than the stacks endpoint
+func TestClientLegacyCreateStack(t *testing.T) {
+	client := NewClient("http://localhost:2283", "test-key")
+	client.Version = &ServerVersion{Major: 1, Minor: 110, Patch: 2}
+
+	var method, path, body string
+	client.HTTPClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			bodyBytes, err := io.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("failed to read request body: %v", err)
+			}
+			method, path, body = req.Method, req.URL.Path, strings.TrimSpace(string(bodyBytes))
+			return &http.Response{
+				StatusCode: http.StatusNoContent,
+				Body:       io.NopCloser(bytes.NewBufferString(``)),
+			}, nil
+		},
+	}
+
+	stack, err := client.CreateStack(context.Background(), []string{"asset2", "asset1", "asset3"})
+	if err != nil {
+		t.Fatalf("CreateStack() error = %v", err)
+	}
+
+	want := `{"ids":["asset1","asset3"],"stackParentId":"asset2"}`
+	if method != "PUT" || path != "/api/assets" || body != want {
+		t.Errorf("request = %s %s %s, want PUT /api/assets %s", method, path, body, want)
+	}
+	if stack.PrimaryAssetID != "asset2" {
+		t.Errorf("Stack primary asset = %s, want asset2", stack.PrimaryAssetID)
+	}
+}
+
+// TestClientUndoRequests tests the requests reverting stacks and tags
+func TestClientUndoRequests(t *testing.T) {
+	client := NewClient("http://localhost:2283", "test-key")
+
+	var requests []string
+	client.HTTPClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := ""
+			if req.Body != nil {
+				bodyBytes, _ := io.ReadAll(req.Body)
+				body = " " + strings.TrimSpace(string(bodyBytes))
+			}
+			requests = append(requests, req.Method+" "+req.URL.Path+body)
+			status := http.StatusOK
+			if strings.HasPrefix(req.URL.Path, "/api/stacks/") {
+				status = http.StatusNoContent
+			}
+			return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBufferString(``))}, nil
+		},
+	}
+
+	if err := client.DeleteStack(context.Background(), "stack1"); err != nil {
+		t.Fatalf("DeleteStack() error = %v", err)
+	}
+	if err := client.UntagAssets(context.Background(), "tag1", []string{"asset1"}); err != nil {
+		t.Fatalf("UntagAssets() error = %v", err)
+	}
+
+	want := []string{"DELETE /api/stacks/stack1", `DELETE /api/tags/tag1/assets {"ids":["asset1"]}`}
+	if strings.Join(requests, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+}
+
+// TestClientGetServerVersion tests the version request and its fallback to
+// the endpoint of older servers
+func TestClientGetServerVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		legacy    bool
+		wantPaths []string
+	}{
+		{"current", false, []string{"/api/server/version"}},
+		{"legacy", true, []string{"/api/server/version", "/api/server-info/version"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient("http://localhost:2283", "test-key")
+
+			var paths []string
+			client.HTTPClient = &MockHTTPClient{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					paths = append(paths, req.URL.Path)
+					if tt.legacy && !strings.HasPrefix(req.URL.Path, "/api/server-info/") {
+						return &http.Response{
+							StatusCode: http.StatusNotFound,
+							Body:       io.NopCloser(bytes.NewBufferString(`{"message":"Cannot GET"}`)),
+						}, nil
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewBufferString(`{"major": 1, "minor": 106, "patch": 4}`)),
+					}, nil
+				},
+			}
+
+			version, err := client.GetServerVersion(context.Background())
+			if err != nil {
+				t.Fatalf("GetServerVersion() error = %v", err)
+			}
+			if version.String() != "v1.106.4" {
+				t.Errorf("version = %s, want v1.106.4", version)
+			}
+			if strings.Join(paths, " ") != strings.Join(tt.wantPaths, " ") {
+				t.Errorf("paths = %v, want %v", paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+// TestServerVersionAtLeast tests comparing server releases
+func TestServerVersionAtLeast(t *testing.T) {
+	version := ServerVersion{Major: 1, Minor: 113, Patch: 1}
+	tests := []struct {
+		major, minor, patch int
+		want                bool
+	}{
+		{1, 113, 0, true},
+		{1, 113, 1, true},
+		{1, 113, 2, false},
+		{1, 99, 9, true},
+		{1, 120, 0, false},
+		{2, 0, 0, false},
+		{0, 200, 0, true},
+	}
+	for _, tt := range tests {
+		if got := version.AtLeast(tt.major, tt.minor, tt.patch); got != tt.want {
+			t.Errorf("%s.AtLeast(%d, %d, %d) = %v, want %v", version, tt.major, tt.minor, tt.patch, got, tt.want)
+		}
+	}
+}
+
+// TestClientAPIError tests that unexpected statuses surface as *APIError,
+// without the API key echoed by some proxies
+func TestClientAPIError(t *testing.T) {
+	client := NewClient("http://localhost:2283/", "test-key")
+	client.HTTPClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Body:       io.NopCloser(bytes.NewReader([]byte(`{"message":"Invalid API key test-key"}`))),
+			}, nil
+		},
+	}
+
+	_, err := client.GetDuplicates(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("GetDuplicates() error = %v, want *APIError", err)
+	}
+	if apiErr.Method != "GET" || apiErr.Path != duplicatesEndpoint {
+		t.Errorf("APIError request = %s %s, want GET %s", apiErr.Method, apiErr.Path, duplicatesEndpoint)
+	}
+	if want := `{"message":"Invalid API key [REDACTED]"}`; apiErr.Body != want {
+		t.Errorf("APIError body = %s, want %s", apiErr.Body, want)
+	}
+	if got := StatusCode(err); got != http.StatusUnauthorized {
+		t.Errorf("StatusCode() = %d, want %d", got, http.StatusUnauthorized)
+	}
+	if got := StatusCode(errors.New("other")); got != 0 {
+		t.Errorf("StatusCode() of a plain error = %d, want 0", got)
+	}
+}
+
+// TestClientHeaders tests the headers sent with every request
+func TestClientHeaders(t *testing.T) {
+	client := NewClient("http://localhost:2283/", "test-key")
+	client.UserAgent = "cleaner/1.0"
+	client.HTTPClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if got := req.URL.String(); got != "http://localhost:2283/api/duplicates" {
+				t.Errorf("Request URL = %s", got)
+			}
+			if got := req.Header.Get("x-api-key"); got != "test-key" {
+				t.Errorf("x-api-key = %q, want %q", got, "test-key")
+			}
+			if got := req.Header.Get("User-Agent"); got != "cleaner/1.0" {
+				t.Errorf("User-Agent = %q, want %q", got, "cleaner/1.0")
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader([]byte(`[]`))),
+			}, nil
+		},
+	}
+
+	if _, err := client.GetDuplicates(context.Background()); err != nil {
+		t.Fatalf("GetDuplicates() error = %v", err)
+	}
+}
+
+// TestClientGetThumbnail tests fetching a thumbnail image
+func TestClientGetThumbnail(t *testing.T) {
+	client := NewClient("http://localhost:2283", "test-key")
+	client.HTTPClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/api/assets/asset1/thumbnail" || req.URL.Query().Get("size") != "thumbnail" {
+				t.Errorf("Request URL = %s", req.URL)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"image/webp"}},
+				Body:       io.NopCloser(bytes.NewReader([]byte("RIFF"))),
+			}, nil
+		},
+	}
+
+	thumbnail, err := client.GetThumbnail(context.Background(), "asset1")
+	if err != nil {
+		t.Fatalf("GetThumbnail() error = %v", err)
+	}
+	if thumbnail.ContentType != "image/webp" || string(thumbnail.Data) != "RIFF" {
+		t.Errorf("GetThumbnail() = %+v", thumbnail)
+	}
+}
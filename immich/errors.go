@@ -0,0 +1,29 @@
+package immich
+
+import (
+	"errors"
+	"fmt"
+)
+
+// APIError is returned when the Immich server answers with an unexpected
+// status code
+type APIError struct {
+	Method     string // HTTP method of the request
+	Path       string // Path of the request, without the base URL
+	StatusCode int    // HTTP status code of the response
+	Body       string // Raw response body
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// StatusCode returns the HTTP status code carried by err, or 0 if err is not
+// an *APIError
+func StatusCode(err error) int {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
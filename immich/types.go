@@ -0,0 +1,182 @@
+package immich
+
+import (
+	"fmt"
+	"time"
+)
+
+// DuplicateAsset represents a single asset in a duplicate group
+type DuplicateAsset struct {
+	ID string `json:"id"`
+}
+
+// DuplicateGroup represents a group of duplicate assets
+type DuplicateGroup struct {
+	DuplicateID string           `json:"duplicateId"`
+	Assets      []DuplicateAsset `json:"assets"`
+}
+
+// User represents an Immich user account
+type User struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+// Album represents an Immich album
+type Album struct {
+	ID         string  `json:"id"`
+	AlbumName  string  `json:"albumName"`
+	OwnerID    string  `json:"ownerId,omitempty"`
+	Assets     []Asset `json:"assets,omitempty"`
+	AssetCount int     `json:"assetCount"`
+}
+
+// Asset represents a media asset with its metadata
+type Asset struct {
+	ExifInfo         *ExifInfo `json:"exifInfo,omitempty"`
+	FileCreatedAt    time.Time `json:"fileCreatedAt,omitempty"`
+	ID               string    `json:"id"`
+	OriginalFileName string    `json:"originalFileName,omitempty"`
+}
+
+// ExifInfo contains EXIF metadata for an asset
+type ExifInfo struct {
+	FileSizeInByte int64    `json:"fileSizeInByte,omitempty"`
+	ImageWidth     int      `json:"imageWidth,omitempty"`
+	ImageHeight    int      `json:"imageHeight,omitempty"`
+	BitsPerSample  int      `json:"bitsPerSample,omitempty"`
+	Make           string   `json:"make,omitempty"`
+	Model          string   `json:"model,omitempty"`
+	Latitude       *float64 `json:"latitude,omitempty"`
+	Longitude      *float64 `json:"longitude,omitempty"`
+	Description    string   `json:"description,omitempty"`
+	Rating         *int     `json:"rating,omitempty"`
+}
+
+// Tag represents an Immich tag
+type Tag struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+// AssetDetails represents detailed information about an asset
+type AssetDetails struct {
+	ExifInfo         *ExifInfo `json:"exifInfo"`
+	FileCreatedAt    time.Time `json:"fileCreatedAt"`
+	ID               string    `json:"id"`
+	OriginalFileName string    `json:"originalFileName"`
+	IsFavorite       bool      `json:"isFavorite"`
+	IsArchived       bool      `json:"isArchived"`
+	IsTrashed        bool      `json:"isTrashed"`
+	Tags             []Tag     `json:"tags,omitempty"`
+}
+
+// BulkIDsRequest is the payload of endpoints acting on a list of asset IDs
+type BulkIDsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// Reasons reported by BulkIDResult.Error
+const (
+	BulkErrorDuplicate    = "duplicate"     // The asset was already present
+	BulkErrorNoPermission = "no_permission" // The API key may not modify the asset
+	BulkErrorNotFound     = "not_found"     // The asset does not exist
+	BulkErrorUnknown      = "unknown"       // Any other failure
+)
+
+// BulkIDResult is the outcome for a single asset of a bulk request
+type BulkIDResult struct {
+	ID      string `json:"id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// UpdateAssetRequest is the payload for updating an asset; nil fields are
+// left unchanged
+type UpdateAssetRequest struct {
+	IsFavorite  *bool   `json:"isFavorite,omitempty"`
+	IsArchived  *bool   `json:"isArchived,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Rating      *int    `json:"rating,omitempty"`
+}
+
+// UpdateAssetsRequest is the payload for updating assets in bulk. A nil
+// DuplicateID is sent as null, which removes the assets from their duplicate
+// group.
+type UpdateAssetsRequest struct {
+	IDs         []string `json:"ids"`
+	DuplicateID *string  `json:"duplicateId"`
+}
+
+// DeleteAssetsRequest is the payload for deleting assets
+type DeleteAssetsRequest struct {
+	IDs   []string `json:"ids"`
+	Force bool     `json:"force"`
+}
+
+// CreateStackRequest is the payload for creating a stack; the first asset
+// becomes the primary asset
+type CreateStackRequest struct {
+	AssetIDs []string `json:"assetIds"`
+}
+
+// Stack represents an Immich stack
+type Stack struct {
+	ID             string `json:"id"`
+	PrimaryAssetID string `json:"primaryAssetId"`
+}
+
+// legacyStackRequest is the payload stacking assets on servers older than
+// the stacks endpoint: ids are stacked behind stackParentId
+type legacyStackRequest struct {
+	IDs           []string `json:"ids"`
+	StackParentID string   `json:"stackParentId"`
+}
+
+// ServerVersion is the release of an Immich server
+type ServerVersion struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Patch int `json:"patch"`
+}
+
+func (v ServerVersion) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast reports whether v is the release major.minor.patch or a later one
+func (v ServerVersion) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// ServerConfig holds the server settings that change how requests behave
+type ServerConfig struct {
+	TrashDays *int `json:"trashDays"` // Days assets stay in the trash; 0 means deletions are permanent
+}
+
+// ServerFeatures holds the server features the duplicates depend on
+type ServerFeatures struct {
+	SmartSearch        bool `json:"smartSearch"`        // CLIP search, which duplicate detection builds on
+	DuplicateDetection bool `json:"duplicateDetection"` // Duplicate detection job enabled
+}
+
+// APIKey describes the API key used by the client
+type APIKey struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Permissions []string `json:"permissions"`
+}
+
+// Thumbnail is the preview image of an asset
+type Thumbnail struct {
+	Data        []byte
+	ContentType string
+}
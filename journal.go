@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// Journal actions
+const (
+	actionAlbumAdd    = "album_add"
+	actionAlbumRemove = "album_remove"
+	actionDelete      = "delete"
+	actionRestore     = "restore"
+
+	actionDuplicateClear   = "duplicate_clear"
+	actionDuplicateRestore = "duplicate_restore"
+
+	actionStack          = "stack"
+	actionStackDelete    = "stack_delete"
+	actionMetadataUpdate = "metadata_update"
+	actionMetadataRevert = "metadata_revert"
+	actionTag            = "tag"
+	actionUntag          = "untag"
+)
+
+// JournalEntry records a single mutation made on the Immich server
+type JournalEntry struct {
+	RunID       string    `json:"runId"`
+	Timestamp   time.Time `json:"timestamp"`
+	Server      string    `json:"server"`
+	Action      string    `json:"action"`
+	AlbumID     string    `json:"albumId,omitempty"`
+	DuplicateID string    `json:"duplicateId,omitempty"`
+	StackID     string    `json:"stackId,omitempty"`
+	TagID       string    `json:"tagId,omitempty"`
+	AssetIDs    []string  `json:"assetIds"`
+	Permanent   bool      `json:"permanent,omitempty"`
+
+	Update   *immich.UpdateAssetRequest `json:"update,omitempty"`   // Fields set by a metadata update
+	Previous *immich.UpdateAssetRequest `json:"previous,omitempty"` // Values replaced by a metadata update
+}
+
+// Journal is an append-only JSON-lines log of every mutation of a run
+type Journal struct {
+	mu     sync.Mutex
+	file   *os.File
+	runID  string
+	server string
+}
+
+// newRunID returns a unique, sortable identifier for a run
+func newRunID() string {
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return time.Now().UTC().Format("20060102-150405")
+	}
+	return time.Now().UTC().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// defaultJournalPath returns the journal location in the user config directory
+func defaultJournalPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "immich-duplicate-cleaner-journal.jsonl"
+	}
+	return filepath.Join(dir, "immich-duplicate-cleaner", "journal.jsonl")
+}
+
+// openJournal opens the journal at path for appending, creating it if needed
+func openJournal(path, runID, server string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	return &Journal{file: file, runID: runID, server: server}, nil
+}
+
+// Record appends an entry to the journal. A nil journal records nothing.
+func (j *Journal) Record(entry JournalEntry) {
+	if j == nil {
+		return
+	}
+
+	entry.RunID = j.runID
+	entry.Server = j.server
+	entry.Timestamp = time.Now().UTC()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		logError("Failed to encode journal entry: %v", err)
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		logError("Failed to write journal entry: %v", err)
+	}
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// readJournal returns the entries recorded for runID, in journal order
+func readJournal(path, runID string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logError("Failed to close journal: %v", err)
+		}
+	}()
+
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("journal line %d: %w", line, err)
+		}
+		if entry.RunID == runID {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	return entries, nil
+}
+
+// undoRun reverts the album additions, trash deletions, cleared duplicate
+// groups, stacks, metadata updates and tags recorded for runID, newest first.
+// Permanent deletions, stacks made by servers without stack IDs and metadata
+// updates whose previous values were not recorded cannot be reverted and are
+// reported, so that they can be reverted by hand.
+func undoRun(config *Config, runID string) error {
+	entries, err := readJournal(config.JournalPath, runID)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no journal entries found for run %s in %s", runID, config.JournalPath)
+	}
+	if entries[0].Server != config.ImmichURL {
+		return fmt.Errorf("run %s was made against %s, not %s", runID, entries[0].Server, config.ImmichURL)
+	}
+
+	logInfo("↩️  Undoing run %s (%d journal entries)", runID, len(entries))
+
+	failed := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		switch entry.Action {
+		case actionAlbumAdd:
+			if config.DryRun {
+				logInfo("   [DRY RUN] Would remove %d asset(s) from album %s", len(entry.AssetIDs), truncateID(entry.AlbumID))
+				continue
+			}
+			if err := removeAssetsFromAlbum(config, entry.AlbumID, entry.AssetIDs); err != nil {
+				logError("❌ Failed to remove assets from album %s: %v", truncateID(entry.AlbumID), err)
+				failed++
+				continue
+			}
+			logInfo("✅ Removed %d asset(s) from album %s", len(entry.AssetIDs), truncateID(entry.AlbumID))
+		case actionDelete:
+			if entry.Permanent {
+				logWarning("⚠️  %d asset(s) were permanently deleted and cannot be restored", len(entry.AssetIDs))
+				failed++
+				continue
+			}
+			if config.DryRun {
+				logInfo("   [DRY RUN] Would restore %d asset(s) from trash", len(entry.AssetIDs))
+				continue
+			}
+			if err := restoreAssets(config, entry.AssetIDs); err != nil {
+				logError("❌ Failed to restore assets from trash: %v", err)
+				failed++
+				continue
+			}
+			logInfo("♻️  Restored %d asset(s) from trash", len(entry.AssetIDs))
+		case actionDuplicateClear:
+			if config.DryRun {
+				logInfo("   [DRY RUN] Would return %d asset(s) to duplicate group %s", len(entry.AssetIDs), truncateID(entry.DuplicateID))
+				continue
+			}
+			if err := restoreDuplicateStatus(config, entry.DuplicateID, entry.AssetIDs); err != nil {
+				logError("❌ Failed to return assets to duplicate group %s: %v", truncateID(entry.DuplicateID), err)
+				failed++
+				continue
+			}
+			logInfo("🔁 Returned %d asset(s) to duplicate group %s", len(entry.AssetIDs), truncateID(entry.DuplicateID))
+		case actionStack:
+			if entry.StackID == "" {
+				logWarning("⚠️  A stack of %d asset(s) was made by a server without stack IDs and cannot be removed; unstack %s in Immich",
+					len(entry.AssetIDs), strings.Join(truncateIDs(entry.AssetIDs), ", "))
+				failed++
+				continue
+			}
+			if config.DryRun {
+				logInfo("   [DRY RUN] Would remove stack %s of %d asset(s)", truncateID(entry.StackID), len(entry.AssetIDs))
+				continue
+			}
+			if err := deleteStack(config, entry.StackID, entry.AssetIDs); err != nil {
+				logError("❌ Failed to remove stack %s: %v", truncateID(entry.StackID), err)
+				failed++
+				continue
+			}
+			logInfo("📚 Removed stack %s of %d asset(s)", truncateID(entry.StackID), len(entry.AssetIDs))
+		case actionMetadataUpdate:
+			fields := strings.Join(updatedFields(entry.Update), ", ")
+			if entry.Previous == nil || len(entry.AssetIDs) != 1 {
+				logWarning("⚠️  The previous %s of asset(s) %s were not recorded and cannot be restored",
+					fields, strings.Join(truncateIDs(entry.AssetIDs), ", "))
+				failed++
+				continue
+			}
+			assetID := entry.AssetIDs[0]
+			if config.DryRun {
+				logInfo("   [DRY RUN] Would restore the %s of asset %s", fields, truncateID(assetID))
+				continue
+			}
+			if err := revertMetadata(config, assetID, *entry.Previous, entry.Update); err != nil {
+				logError("❌ Failed to restore the %s of asset %s: %v", fields, truncateID(assetID), err)
+				failed++
+				continue
+			}
+			logInfo("🔀 Restored the %s of asset %s", fields, truncateID(assetID))
+		case actionTag:
+			if config.DryRun {
+				logInfo("   [DRY RUN] Would remove tag %s from %d asset(s)", truncateID(entry.TagID), len(entry.AssetIDs))
+				continue
+			}
+			if err := untagAssets(config, entry.TagID, entry.AssetIDs); err != nil {
+				logError("❌ Failed to remove tag %s: %v", truncateID(entry.TagID), err)
+				failed++
+				continue
+			}
+			logInfo("🏷️  Removed tag %s from %d asset(s)", truncateID(entry.TagID), len(entry.AssetIDs))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d journal entries could not be undone", failed)
+	}
+
+	logInfo("🎉 Run %s undone", runID)
+	return nil
+}
+
+// updatedFields names the fields set by a metadata update
+func updatedFields(update *immich.UpdateAssetRequest) []string {
+	fields := []string{}
+	if update == nil {
+		return fields
+	}
+	if update.IsFavorite != nil {
+		fields = append(fields, "favorite")
+	}
+	if update.IsArchived != nil {
+		fields = append(fields, "archived")
+	}
+	if update.Description != nil {
+		fields = append(fields, "description")
+	}
+	if update.Rating != nil {
+		fields = append(fields, "rating")
+	}
+	return fields
+}
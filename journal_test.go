@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// TestJournalRoundTrip tests that recorded entries are read back per run
+func TestJournalRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal", "journal.jsonl")
+
+	first, err := openJournal(path, "run1", "http://localhost:2283")
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	first.Record(JournalEntry{Action: actionAlbumAdd, AlbumID: "album1", AssetIDs: []string{"asset1"}})
+	first.Record(JournalEntry{Action: actionDelete, AssetIDs: []string{"asset2", "asset3"}})
+	if err := first.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	second, err := openJournal(path, "run2", "http://localhost:2283")
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	second.Record(JournalEntry{Action: actionDelete, AssetIDs: []string{"asset4"}})
+	second.Close()
+
+	entries, err := readJournal(path, "run1")
+	if err != nil {
+		t.Fatalf("readJournal() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("readJournal() returned %d entries, want 2", len(entries))
+	}
+	if entries[0].Action != actionAlbumAdd || entries[0].AlbumID != "album1" {
+		t.Errorf("first entry = %+v, want album_add to album1", entries[0])
+	}
+	if entries[1].Server != "http://localhost:2283" || entries[1].Timestamp.IsZero() {
+		t.Errorf("second entry = %+v, want server and timestamp set", entries[1])
+	}
+
+	// A nil journal records nothing
+	var disabled *Journal
+	disabled.Record(JournalEntry{Action: actionDelete})
+	if err := disabled.Close(); err != nil {
+		t.Errorf("Close() on nil journal error = %v", err)
+	}
+}
+
+// TestUndoRun tests that undo reverts album additions and trash deletions
+func TestUndoRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := openJournal(path, "run1", "http://localhost:2283")
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	journal.Record(JournalEntry{Action: actionAlbumAdd, AlbumID: "album1", AssetIDs: []string{"asset1"}})
+	journal.Record(JournalEntry{Action: actionDelete, AssetIDs: []string{"asset2"}})
+	journal.Record(JournalEntry{Action: actionDuplicateClear, DuplicateID: "dup1", AssetIDs: []string{"asset3", "asset4"}})
+	journal.Close()
+
+	tests := []struct {
+		name         string
+		url          string
+		runID        string
+		wantRequests []string
+		wantErr      bool
+	}{
+		{
+			name:         "reverts newest first",
+			url:          "http://localhost:2283",
+			runID:        "run1",
+			wantRequests: []string{"PUT /api/assets", "POST /api/trash/restore/assets", "DELETE /api/albums/album1/assets"},
+		},
+		{
+			name:    "unknown run",
+			url:     "http://localhost:2283",
+			runID:   "run2",
+			wantErr: true,
+		},
+		{
+			name:    "different server",
+			url:     "http://other:2283",
+			runID:   "run1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldClient := httpClient
+			defer func() { httpClient = oldClient }()
+
+			var requests []string
+			httpClient = &MockHTTPClient{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					requests = append(requests, req.Method+" "+req.URL.Path)
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       io.NopCloser(bytes.NewBufferString(`[]`)),
+					}, nil
+				},
+			}
+
+			config := &Config{
+				ImmichURL:   tt.url,
+				APIKey:      "test-key",
+				JournalPath: path,
+			}
+
+			err := undoRun(config, tt.runID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("undoRun() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(requests, ",") != strings.Join(tt.wantRequests, ",") {
+				t.Errorf("requests = %v, want %v", requests, tt.wantRequests)
+			}
+		})
+	}
+}
+
+// TestUndoRunMerge tests reverting stacks, metadata merges and tags
+func TestUndoRunMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := openJournal(path, "run1", "http://localhost:2283")
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	favorite, wasFavorite, rating, wasRating := true, false, 5, 0
+	journal.Record(JournalEntry{Action: actionMetadataUpdate, AssetIDs: []string{"keeper"},
+		Update:   &immich.UpdateAssetRequest{IsFavorite: &favorite, Rating: &rating},
+		Previous: &immich.UpdateAssetRequest{IsFavorite: &wasFavorite, Rating: &wasRating}})
+	journal.Record(JournalEntry{Action: actionTag, TagID: "tag1", AssetIDs: []string{"keeper"}})
+	journal.Record(JournalEntry{Action: actionStack, StackID: "stack1", AssetIDs: []string{"keeper", "other"}})
+	journal.Close()
+
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	var requests []string
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := ""
+			if req.Body != nil {
+				bodyBytes, _ := io.ReadAll(req.Body)
+				body = " " + string(bodyBytes)
+			}
+			requests = append(requests, req.Method+" "+req.URL.Path+body)
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(`{}`))}, nil
+		},
+	}
+
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", JournalPath: path}
+	if err := undoRun(config, "run1"); err != nil {
+		t.Fatalf("undoRun() error = %v", err)
+	}
+
+	want := []string{
+		"DELETE /api/stacks/stack1",
+		`DELETE /api/tags/tag1/assets {"ids":["keeper"]}`,
+		`PUT /api/assets/keeper {"isFavorite":false,"rating":0}`,
+	}
+	if strings.Join(requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+}
+
+// TestUndoRunIrreversible tests that permanent deletions, stacks without an
+// ID and metadata updates without previous values are reported instead of
+// reverted
+func TestUndoRunIrreversible(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := openJournal(path, "run1", "http://localhost:2283")
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	favorite := true
+	journal.Record(JournalEntry{Action: actionDelete, AssetIDs: []string{"asset1"}, Permanent: true})
+	journal.Record(JournalEntry{Action: actionStack, AssetIDs: []string{"asset2", "asset3"}})
+	journal.Record(JournalEntry{Action: actionStack})
+	journal.Record(JournalEntry{Action: actionMetadataUpdate, AssetIDs: []string{"asset2"}, Update: &immich.UpdateAssetRequest{IsFavorite: &favorite}})
+	journal.Record(JournalEntry{Action: actionMetadataUpdate, Previous: &immich.UpdateAssetRequest{}})
+	journal.Close()
+
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			t.Errorf("unexpected request %s %s", req.Method, req.URL.Path)
+			return nil, nil
+		},
+	}
+
+	config := &Config{
+		ImmichURL:   "http://localhost:2283",
+		APIKey:      "test-key",
+		JournalPath: path,
+	}
+
+	err = undoRun(config, "run1")
+	if err == nil || !strings.Contains(err.Error(), "5 journal entries could not be undone") {
+		t.Errorf("undoRun() error = %v, want 5 entries that could not be undone", err)
+	}
+}
@@ -4,8 +4,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
-	"encoding/json"
+	"context"
 	"flag"
 	"fmt"
 	"io"
@@ -13,146 +14,623 @@ import (
 	"net/http"
 	"os"
 	"strings"
-	"time"
+	"sync"
+
+	"immich-duplicate-cleaner/immich"
 )
 
 const (
-	// API endpoint paths
-	duplicatesEndpoint = "/api/duplicates"
-	albumsEndpoint     = "/api/albums"
-	assetsEndpoint     = "/api/assets"
-
-	// HTTP timeouts
-	defaultTimeout = 30 * time.Second
-
 	// Version information
 	version = "1.0.0"
 )
 
 // Config holds the application configuration
 type Config struct {
-	ImmichURL  string // Base URL of the Immich instance
-	APIKey     string // API key for authentication
-	AutoDelete bool   // Whether to automatically delete lower-quality duplicates
-	DryRun     bool   // Preview mode - don't make any changes
-	Yes        bool   // Skip confirmation prompts
-	Verbose    bool   // Enable verbose logging
-}
-
-// DuplicateAsset represents a single asset in a duplicate group
-type DuplicateAsset struct {
-	ID string `json:"id"`
-}
-
-// DuplicateGroup represents a group of duplicate assets
-type DuplicateGroup struct {
-	DuplicateID string           `json:"duplicateId"`
-	Assets      []DuplicateAsset `json:"assets"`
+	ImmichURL   string // Base URL of the Immich instance
+	APIKey      string // API key for authentication
+	AutoDelete  bool   // Whether to automatically delete lower-quality duplicates
+	Stack       bool   // Whether to stack duplicates behind the best quality asset
+	Merge       bool   // Merge metadata from deleted duplicates into the kept asset
+	DryRun      bool   // Preview mode - don't make any changes
+	Yes         bool   // Skip confirmation prompts
+	Interactive bool   // Review each group and choose the asset to keep
+	Trash       bool   // Move deleted duplicates to the Immich trash (default)
+	Permanent   bool   // Permanently delete duplicates, bypassing the trash
+	Verbose     bool   // Enable verbose logging
+	Policy      string // Name of the quality policy used to pick the asset to keep
+	Weights     string // Criterion weight overrides for weighted policies
+
+	ResolutionTolerance float64 // Relative pixel-count band treated as equal by the resolution policy
+
+	JournalPath string   // Path of the mutation journal; empty disables journaling
+	RunID       string   // Identifier of the current run in the journal
+	journal     *Journal // Open journal, nil when journaling is disabled
+
+	CheckpointPath string // Path of the checkpoint of completed duplicate groups
+	Resume         bool   // Skip duplicate groups completed by a previous run
+
+	AlbumCache bool        // Index album membership up front instead of querying it per asset
+	albums     *AlbumIndex // Album membership index, nil when album lookups query the server
+
+	BatchAlbums bool       // Plan the album additions of every group and send them per album in batches
+	BatchSize   int        // Maximum number of assets per batched album addition
+	albumPlan   *AlbumPlan // Flushed album additions, nil when albums are synchronized per group
+
+	Concurrency int          // Number of duplicate groups processed in parallel
+	MaxAttempts int          // Maximum attempts per idempotent request on transient errors
+	output      *groupOutput // Buffered log output of the current group, nil logs directly
+
+	review *Review // Interactive review state, nil when groups are not reviewed
+
+	ConfigPath string                  // Configuration file; empty searches the configuration directories
+	Profile    string                  // Profile of the configuration file; empty uses its default profile
+	sources    map[string]configSource // Settings taken from the configuration file or the environment
+
+	APIKeyFile    string // File whose first line is the API key
+	APIKeyCommand string // Shell command printing the API key
+
+	Users   string // Comma-separated profiles of the users processed one after the other
+	user    string // Profile of the user being processed in multi-user mode
+	ownerID string // ID of that user; albums owned by other users are never added to
+
+	Listen      string // Address the serve command listens on
+	AllowRemote bool   // Let the serve command listen on an address reachable from other machines
+	IDsFile     string // File listing the duplicate groups of the not-duplicates command
+	plan        *Plan  // Plan of the apply command, read before the server check
+
+	ReportPath  string       // Path of the JSON run report; empty disables it
+	report      *Report      // Report of the run, nil when no report is written
+	groupReport *GroupReport // Report of the current group, nil outside of a group
+
+	SkipVersionCheck bool                  // Run destructive modes against unsupported server versions
+	serverVersion    *immich.ServerVersion // Version found by the startup handshake, nil before it
+
+	ctx context.Context // Cancelled when the run is interrupted, nil means never
 }
 
-// Album represents an Immich album
-type Album struct {
-	ID         string  `json:"id"`
-	AlbumName  string  `json:"albumName"`
-	Assets     []Asset `json:"assets,omitempty"`
-	AssetCount int     `json:"assetCount"`
-}
-
-// Asset represents a media asset with its metadata
-type Asset struct {
-	ExifInfo         *ExifInfo `json:"exifInfo,omitempty"`
-	FileCreatedAt    time.Time `json:"fileCreatedAt,omitempty"`
-	ID               string    `json:"id"`
-	OriginalFileName string    `json:"originalFileName,omitempty"`
-}
-
-// ExifInfo contains EXIF metadata for an asset
-type ExifInfo struct {
-	FileSizeInByte int64 `json:"fileSizeInByte,omitempty"`
-	ImageWidth     int   `json:"imageWidth,omitempty"`
-	ImageHeight    int   `json:"imageHeight,omitempty"`
+// context returns the context of the run
+func (c *Config) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
 
-// AssetDetails represents detailed information about an asset
-type AssetDetails struct {
-	ExifInfo         *ExifInfo `json:"exifInfo"`
-	FileCreatedAt    time.Time `json:"fileCreatedAt"`
-	ID               string    `json:"id"`
-	OriginalFileName string    `json:"originalFileName"`
+// api returns an Immich client for the configured server
+func (c *Config) api() *immich.Client {
+	client := immich.NewClient(c.ImmichURL, c.APIKey)
+	client.HTTPClient = httpClient
+	if retry, ok := httpClient.(*retryClient); ok {
+		client.HTTPClient = retry.withConfig(c)
+	}
+	client.UserAgent = "immich-duplicate-cleaner/" + version
+	client.Version = c.serverVersion
+	return client
 }
 
-// AddAssetsRequest is the payload for adding assets to an album
-type AddAssetsRequest struct {
-	IDs []string `json:"ids"`
+// Stats holds the counters reported in the final summary
+type Stats struct {
+	Groups        int `json:"groups"`        // Duplicate groups processed successfully
+	Failed        int `json:"failed"`        // Duplicate groups that failed
+	Synced        int `json:"synced"`        // Assets added to albums
+	Trashed       int `json:"trashed"`       // Assets moved to the Immich trash
+	Deleted       int `json:"deleted"`       // Assets permanently deleted
+	Stacked       int `json:"stacked"`       // Stacks created
+	Cancelled     int `json:"cancelled"`     // Groups whose deletion was cancelled at the prompt
+	Skipped       int `json:"skipped"`       // Groups skipped because a previous run completed them
+	Kept          int `json:"kept"`          // Groups whose assets were all kept at the review prompt
+	NotDuplicates int `json:"notDuplicates"` // Groups marked as not duplicates at the review prompt
 }
 
-// HTTPClient interface for easier testing
-type HTTPClient interface {
-	Do(req *http.Request) (*http.Response, error)
+// add accumulates the counters of other into s
+func (s *Stats) add(other Stats) {
+	s.Groups += other.Groups
+	s.Failed += other.Failed
+	s.Synced += other.Synced
+	s.Trashed += other.Trashed
+	s.Deleted += other.Deleted
+	s.Stacked += other.Stacked
+	s.Cancelled += other.Cancelled
+	s.Skipped += other.Skipped
+	s.Kept += other.Kept
+	s.NotDuplicates += other.NotDuplicates
 }
 
 var (
 	// Global HTTP client with timeout
-	httpClient HTTPClient = &http.Client{Timeout: defaultTimeout}
+	httpClient immich.HTTPClient = &http.Client{Timeout: immich.DefaultTimeout}
 )
 
 func main() {
+	// Split off the subcommand and its positional arguments
+	command, positional, flagArgs := parseCommand(os.Args[1:])
+
 	// Parse command-line flags
-	config := parseFlags()
+	config := parseFlags(flagArgs)
+	positional = append(positional, flag.Args()...)
+
+	// Process the duplicates of several users one after the other
+	if config.Users != "" {
+		if command != "" {
+			log.Fatalf("--users cannot be used with the %s command", command)
+		}
+		log.SetOutput(redactingWriter{w: log.Writer()})
+		users, err := loadUserConfigs(flagArgs, config.Users)
+		if err != nil {
+			log.Fatalf("Configuration error: %v", err)
+		}
+		// Every user retries up to its own max-attempts, see Config.api
+		httpClient = newRetryClient(httpClient, config.MaxAttempts)
+		if failed := runUsers(users); failed > 0 {
+			log.Fatalf("Failed to process %d user(s)", failed)
+		}
+		return
+	}
+
+	// Complete the flags from the environment and the configuration file,
+	// then keep the API key out of every log line
+	err := applyConfigSources(config, flag.CommandLine)
+	addSecret(config.APIKey)
+	log.SetOutput(redactingWriter{w: log.Writer()})
+	if err != nil {
+		log.Fatalf("Configuration error: %v", err)
+	}
+
+	// The doctor diagnoses missing settings and server problems itself
+	if command == "doctor" {
+		if failed := runDoctor(config); failed > 0 {
+			log.Fatalf("%d check(s) failed", failed)
+		}
+		return
+	}
 
 	// Validate configuration
 	if err := validateConfig(config); err != nil {
 		log.Fatalf("Configuration error: %v", err)
 	}
 
+	// Retry transient failures of idempotent requests
+	httpClient = newRetryClient(httpClient, config.MaxAttempts)
+
+	// Deletions and permissions of a plan follow the plan, which the server
+	// check must know
+	if command == "apply" && len(positional) == 1 {
+		plan, err := readPlan(positional[0])
+		if err != nil {
+			log.Fatalf("Apply failed: %v", err)
+		}
+		config.Permanent = plan.Permanent
+		config.plan = plan
+	}
+
+	// Check the server and the API key before changing anything
+	if err := checkServer(config, command); err != nil {
+		log.Fatalf("Server check failed: %v", err)
+	}
+
+	switch command {
+	case "":
+		run(config)
+	case "undo":
+		if len(positional) != 1 {
+			log.Fatalf("Usage: %s undo <run-id> [flags]", os.Args[0])
+		}
+		closeJournal, err := startJournal(config)
+		if err != nil {
+			log.Fatalf("Undo failed: %v", err)
+		}
+		defer closeJournal()
+		if err := undoRun(config, positional[0]); err != nil {
+			log.Fatalf("Undo failed: %v", err)
+		}
+	case "plan":
+		if len(positional) != 1 {
+			log.Fatalf("Usage: %s plan <file> [flags]", os.Args[0])
+		}
+		if err := startAlbumIndex(config); err != nil {
+			log.Fatalf("Planning failed: %v", err)
+		}
+		runPlan(config, positional[0])
+	case "apply":
+		if len(positional) != 1 {
+			log.Fatalf("Usage: %s apply <file> [flags]", os.Args[0])
+		}
+		runApply(config, positional[0])
+	case "tui":
+		closeJournal, err := startJournal(config)
+		if err != nil {
+			log.Fatalf("Terminal UI failed: %v", err)
+		}
+		defer closeJournal()
+		if err := startAlbumIndex(config); err != nil {
+			log.Fatalf("Terminal UI failed: %v", err)
+		}
+		if err := runTUI(config); err != nil {
+			log.Fatalf("Terminal UI failed: %v", err)
+		}
+	case "serve":
+		closeJournal, err := startJournal(config)
+		if err != nil {
+			log.Fatalf("Web UI failed: %v", err)
+		}
+		defer closeJournal()
+		if err := startAlbumIndex(config); err != nil {
+			log.Fatalf("Web UI failed: %v", err)
+		}
+		if err := runServe(config); err != nil {
+			log.Fatalf("Web UI failed: %v", err)
+		}
+	case "not-duplicates":
+		closeJournal, err := startJournal(config)
+		if err != nil {
+			log.Fatalf("Not-duplicates failed: %v", err)
+		}
+		defer closeJournal()
+		if err := runNotDuplicates(config, positional); err != nil {
+			log.Fatalf("Not-duplicates failed: %v", err)
+		}
+	case "html-report":
+		if len(positional) != 1 {
+			log.Fatalf("Usage: %s html-report <file> [flags]", os.Args[0])
+		}
+		if err := startAlbumIndex(config); err != nil {
+			log.Fatalf("Review report failed: %v", err)
+		}
+		if err := writeHTMLReport(config, positional[0]); err != nil {
+			log.Fatalf("Review report failed: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown command %q (run with --help for usage)", command)
+	}
+}
+
+// parseCommand splits the command line into an optional subcommand, the
+// positional arguments that follow it, and the remaining flags
+func parseCommand(args []string) (string, []string, []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", nil, args
+	}
+
+	command, args := args[0], args[1:]
+	positional := []string{}
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	return command, positional, args
+}
+
+// startJournal opens the mutation journal for a new run unless journaling is
+// disabled or nothing will be changed. It returns a function closing it.
+func startJournal(config *Config) (func(), error) {
+	if config.DryRun || config.JournalPath == "" {
+		return func() {}, nil
+	}
+
+	config.RunID = newRunID()
+	journal, err := openJournal(config.JournalPath, config.RunID, config.ImmichURL)
+	if err != nil {
+		return nil, err
+	}
+	config.journal = journal
+	logInfo("📝 Run ID: %s (journal: %s)", config.RunID, config.JournalPath)
+
+	return func() {
+		if err := journal.Close(); err != nil {
+			logError("Failed to close journal: %v", err)
+		}
+	}, nil
+}
+
+// startCheckpoint opens the checkpoint of completed duplicate groups. In dry
+// run mode a resumed checkpoint is only read. It returns a function closing it.
+func startCheckpoint(config *Config) (*Checkpoint, func(), error) {
+	if config.CheckpointPath == "" {
+		if config.Resume {
+			return nil, nil, fmt.Errorf("--resume requires a --checkpoint file")
+		}
+		return nil, func() {}, nil
+	}
+
+	if config.DryRun {
+		if !config.Resume {
+			return nil, func() {}, nil
+		}
+		completed, err := loadCheckpoint(config.CheckpointPath, checkpointHeader(config))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resume: %w", err)
+		}
+		return &Checkpoint{completed: completed}, func() {}, nil
+	}
+
+	checkpoint, err := openCheckpoint(config.CheckpointPath, checkpointHeader(config), config.Resume)
+	if err != nil {
+		if config.Resume {
+			return nil, nil, fmt.Errorf("failed to resume: %w", err)
+		}
+		return nil, nil, fmt.Errorf("failed to start checkpoint: %w", err)
+	}
+
+	return checkpoint, func() {
+		if err := checkpoint.Close(); err != nil {
+			logError("Failed to close checkpoint: %v", err)
+		}
+	}, nil
+}
+
+// startAlbumIndex builds the album membership index when --album-cache is set
+func startAlbumIndex(config *Config) error {
+	if !config.AlbumCache {
+		return nil
+	}
+
+	logInfo("📚 Indexing album membership...")
+	index, err := loadAlbumIndex(config)
+	if err != nil {
+		return fmt.Errorf("failed to index albums: %w", err)
+	}
+	config.albums = index
+	albums, assets := index.Len()
+	logInfo("✅ Indexed %d album(s) covering %d asset(s)", albums, assets)
+	return nil
+}
+
+// run synchronizes albums and resolves every duplicate group
+func run(config *Config) {
 	logInfo("🚀 Starting Immich Duplicate Cleaner v%s", version)
 	if config.DryRun {
 		logWarning("⚠️  DRY RUN MODE - No changes will be made")
 	}
 
+	if _, err := processDuplicates(config); err != nil {
+		log.Fatalf("Run failed: %v", err)
+	}
+}
+
+// processDuplicates synchronizes albums and resolves every duplicate group of
+// the configured account, and returns the counters of the run
+func processDuplicates(config *Config) (*Stats, error) {
+	closeJournal, err := startJournal(config)
+	if err != nil {
+		return nil, err
+	}
+	defer closeJournal()
+
+	checkpoint, closeCheckpoint, err := startCheckpoint(config)
+	if err != nil {
+		return nil, err
+	}
+	defer closeCheckpoint()
+
+	if config.ReportPath != "" {
+		config.report = newReport(config)
+	}
+
+	if err := startAlbumIndex(config); err != nil {
+		return nil, err
+	}
+
+	if config.Interactive {
+		config.review = newReview()
+	}
+
 	// Fetch all duplicate groups
 	logInfo("🔍 Fetching duplicate groups...")
-	duplicates, err := getDuplicates(config)
+	duplicates, err := config.api().GetDuplicates(config.context())
 	if err != nil {
-		log.Fatalf("Failed to fetch duplicates: %v", err)
+		return nil, fmt.Errorf("failed to fetch duplicates: %w", err)
 	}
 
 	logInfo("✅ Found %d duplicate group(s)", len(duplicates))
 
 	if len(duplicates) == 0 {
 		logInfo("🎉 No duplicates found - nothing to do!")
-		return
+		stats := &Stats{}
+		writeReport(config, stats)
+		return stats, nil
 	}
 
-	// Process each duplicate group
-	for i, group := range duplicates {
-		if err := processDuplicateGroup(config, i+1, len(duplicates), group); err != nil {
-			logError("Failed to process group %d: %v", i+1, err)
-			continue
-		}
+	// Synchronize the albums of every group up front in batch mode
+	synced := 0
+	if config.BatchAlbums {
+		logInfo("🗂️  Planning album additions...")
+		plan := planAlbumAdditions(config, checkpoint, duplicates)
+		synced = flushAlbumPlan(config, plan)
+		config.albumPlan = plan
 	}
 
+	// Process each duplicate group
+	stats := processGroups(config, checkpoint, duplicates)
+	stats.Synced += synced
+
+	if config.review.stopped() {
+		logInfo("\n⏹️  Review stopped - remaining groups were left untouched")
+	}
 	logInfo("\n🎉 Processing complete!")
-	if !config.AutoDelete {
+	printSummary(config, stats)
+	writeReport(config, stats)
+	if !config.AutoDelete && !config.Stack {
 		logInfo("💡 Tip: Use --auto-delete flag to automatically remove lower-quality duplicates")
 	}
+	if config.RunID != "" {
+		if config.user != "" {
+			logInfo("↩️  To revert this run: %s undo %s --profile %s", os.Args[0], config.RunID, config.user)
+		} else {
+			logInfo("↩️  To revert this run: %s undo %s --url %s --api-key YOUR_KEY", os.Args[0], config.RunID, config.ImmichURL)
+		}
+	}
+	return stats, nil
+}
+
+// writeReport saves the run report, if one was requested
+func writeReport(config *Config, stats *Stats) {
+	if config.report == nil {
+		return
+	}
+	if err := config.report.write(config.ReportPath, config.RunID, stats); err != nil {
+		logError("Failed to save report: %v", err)
+		return
+	}
+	logInfo("📄 Report written to %s", config.ReportPath)
+}
+
+// groupResult is the outcome of processing a single duplicate group
+type groupResult struct {
+	group  immich.DuplicateGroup
+	status string // One of the groupStatus values
+	stats  Stats
+	report *GroupReport // Report of the group, nil when no report is written
+}
+
+// resolved reports whether the group needs no more work, so that a resumed
+// run can skip it. Failed, skipped and cancelled groups are offered again.
+func (r groupResult) resolved() bool {
+	switch r.status {
+	case groupStatusDone, groupStatusKept, groupStatusNotDuplicate:
+		return true
+	}
+	return false
+}
+
+// processGroups processes every duplicate group not completed by a previous
+// run, using up to config.Concurrency workers, and returns the aggregated
+// counters. Results are collected on the calling goroutine, which is the only
+// one updating the checkpoint and the run-level counters.
+func processGroups(config *Config, checkpoint *Checkpoint, duplicates []immich.DuplicateGroup) *Stats {
+	stats := &Stats{}
+	workers := max(1, config.Concurrency)
+
+	jobs := make(chan int)
+	results := make(chan groupResult)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if config.review.stopped() {
+					continue
+				}
+				results <- processGroup(config, workers > 1, i+1, len(duplicates), duplicates[i])
+			}
+		}()
+	}
+
+	go func() {
+		for i, group := range duplicates {
+			if config.review.stopped() {
+				break
+			}
+			if checkpoint.IsDone(group.DuplicateID) {
+				result := groupResult{group: group, status: groupStatusSkipped, stats: Stats{Skipped: 1}}
+				if config.report != nil {
+					result.report = newGroupReport(i+1, group)
+					result.report.finish(groupStatusSkipped)
+				}
+				results <- result
+				continue
+			}
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for result := range results {
+		stats.add(result.stats)
+		config.report.addGroup(result.report)
+		if result.resolved() {
+			checkpoint.MarkDone(result.group.DuplicateID)
+		}
+	}
+
+	return stats
+}
+
+// processGroup runs processDuplicateGroup with its own counters and report.
+// When buffered, the group's log lines are held back and printed together.
+func processGroup(config *Config, buffered bool, groupNum, totalGroups int, group immich.DuplicateGroup) groupResult {
+	groupConfig := config
+	if buffered || config.report != nil {
+		copied := *config
+		if buffered {
+			copied.output = newGroupOutput()
+		}
+		if config.report != nil {
+			copied.groupReport = newGroupReport(groupNum, group)
+		}
+		groupConfig = &copied
+	}
+
+	result := groupResult{group: group, status: groupStatusDone, report: groupConfig.groupReport}
+	if err := processDuplicateGroup(groupConfig, &result.stats, groupNum, totalGroups, group); err != nil {
+		groupConfig.logError("Failed to process group %d: %v", groupNum, err)
+		result.stats.Failed++
+		result.status = groupStatusFailed
+	} else {
+		switch {
+		case result.stats.Cancelled > 0:
+			result.status = groupStatusCancelled
+		case result.stats.Kept > 0:
+			result.status = groupStatusKept
+		case result.stats.NotDuplicates > 0:
+			result.status = groupStatusNotDuplicate
+		}
+		// Cancelled groups were left untouched and are not processed
+		if result.status != groupStatusCancelled {
+			result.stats.Groups++
+		}
+	}
+	result.report.finish(result.status)
+
+	if buffered {
+		outputMu.Lock()
+		groupConfig.output.flush()
+		outputMu.Unlock()
+	}
+
+	return result
+}
+
+// printSummary logs the run-level counters
+func printSummary(config *Config, stats *Stats) {
+	logInfo("📊 Summary: %d group(s) processed, %d failed, %d asset(s) synchronized", stats.Groups, stats.Failed, stats.Synced)
+	if stats.Skipped > 0 {
+		logInfo("⏭️  Skipped %d group(s) completed by a previous run", stats.Skipped)
+	}
+	if config.DryRun {
+		return
+	}
+
+	if stats.Stacked > 0 {
+		logInfo("📚 Created %d stack(s)", stats.Stacked)
+	}
+
+	if stats.Cancelled > 0 {
+		logInfo("   %d group(s) skipped at the confirmation prompt", stats.Cancelled)
+	}
+	if stats.Kept > 0 {
+		logInfo("   %d group(s) kept in full at the review prompt", stats.Kept)
+	}
+	if stats.NotDuplicates > 0 {
+		logInfo("   %d group(s) marked as not duplicates", stats.NotDuplicates)
+	}
+	if stats.Trashed > 0 {
+		logInfo("🗑️  Moved %d asset(s) to the Immich trash", stats.Trashed)
+		logInfo("💡 To restore them, open Trash in the Immich web UI and select Restore before the trash is emptied")
+	}
+	if stats.Deleted > 0 {
+		logInfo("🗑️  Permanently deleted %d asset(s)", stats.Deleted)
+	}
 }
 
 // parseFlags parses command-line flags and returns a Config
-func parseFlags() *Config {
+func parseFlags(args []string) *Config {
 	config := &Config{}
-
-	flag.StringVar(&config.ImmichURL, "url", "", "Immich server URL (e.g., http://localhost:2283)")
-	flag.StringVar(&config.ImmichURL, "u", "", "Immich server URL (shorthand)")
-	flag.StringVar(&config.APIKey, "api-key", "", "Immich API key")
-	flag.StringVar(&config.APIKey, "k", "", "Immich API key (shorthand)")
-	flag.BoolVar(&config.AutoDelete, "auto-delete", false, "Automatically delete lower-quality duplicates")
-	flag.BoolVar(&config.AutoDelete, "d", false, "Automatically delete lower-quality duplicates (shorthand)")
-	flag.BoolVar(&config.DryRun, "dry-run", false, "Preview actions without making changes")
-	flag.BoolVar(&config.Yes, "yes", false, "Skip confirmation prompts")
-	flag.BoolVar(&config.Yes, "y", false, "Skip confirmation prompts (shorthand)")
-	flag.BoolVar(&config.Verbose, "verbose", false, "Enable verbose logging")
-	flag.BoolVar(&config.Verbose, "v", false, "Enable verbose logging (shorthand)")
+	registerFlags(flag.CommandLine, config)
 
 	showVersion := flag.Bool("version", false, "Show version information")
 
@@ -160,7 +638,24 @@ func parseFlags() *Config {
 		fmt.Fprintf(os.Stderr, "Immich Duplicate Cleaner v%s\n\n", version)
 		fmt.Fprintf(os.Stderr, "A tool to synchronize albums across duplicate assets and optionally remove duplicates.\n\n")
 		fmt.Fprintf(os.Stderr, "Usage:\n")
-		fmt.Fprintf(os.Stderr, "  %s [flags]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s undo <run-id> [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s tui [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s serve [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s not-duplicates <duplicate-id>... [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s html-report <file> [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s plan <file> [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s apply <file> [flags]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s doctor [flags]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Commands:\n")
+		fmt.Fprintf(os.Stderr, "  undo <run-id>        Revert the album additions, deletions, stacks, merges and tags of a run\n")
+		fmt.Fprintf(os.Stderr, "  tui                  Browse duplicate groups in a full-screen terminal UI and resolve them\n")
+		fmt.Fprintf(os.Stderr, "  serve                Serve a local web UI to review and resolve duplicate groups\n")
+		fmt.Fprintf(os.Stderr, "  not-duplicates <id>  Tell the server that the listed duplicate groups are not duplicates\n")
+		fmt.Fprintf(os.Stderr, "  html-report <file>   Write an HTML page showing every duplicate group with thumbnails for review\n")
+		fmt.Fprintf(os.Stderr, "  plan <file>          Write every change a run with the same flags would make to a plan file\n")
+		fmt.Fprintf(os.Stderr, "  apply <file>         Apply a plan file, refusing if the server has changed since it was made\n")
+		fmt.Fprintf(os.Stderr, "  doctor               Check the connection, the API key and its permissions, and explain how to fix problems\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
@@ -168,9 +663,45 @@ func parseFlags() *Config {
 		fmt.Fprintf(os.Stderr, "  %s --url http://localhost:2283 --api-key YOUR_KEY --dry-run\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  # Synchronize albums and auto-delete duplicates\n")
 		fmt.Fprintf(os.Stderr, "  %s -u http://localhost:2283 -k YOUR_KEY --auto-delete\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Synchronize albums and stack duplicates\n")
+		fmt.Fprintf(os.Stderr, "  %s -u http://localhost:2283 -k YOUR_KEY --stack\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Review each group and choose the asset to keep\n")
+		fmt.Fprintf(os.Stderr, "  %s -u http://localhost:2283 -k YOUR_KEY -d --interactive\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Auto-delete using weighted quality scoring\n")
+		fmt.Fprintf(os.Stderr, "  %s -u http://localhost:2283 -k YOUR_KEY -d --policy balanced --weights gps=3\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Synchronize the albums of a large library in batches\n")
+		fmt.Fprintf(os.Stderr, "  %s -u http://localhost:2283 -k YOUR_KEY --album-cache --batch-albums\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Preview deletions and save a JSON report\n")
+		fmt.Fprintf(os.Stderr, "  %s -u http://localhost:2283 -k YOUR_KEY -d --dry-run --report report.json\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Resume an interrupted run\n")
+		fmt.Fprintf(os.Stderr, "  %s -u http://localhost:2283 -k YOUR_KEY -d --resume\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Browse and resolve duplicate groups in a terminal UI\n")
+		fmt.Fprintf(os.Stderr, "  %s tui -u http://localhost:2283 -k YOUR_KEY --policy balanced\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Review and resolve duplicate groups in a local web UI\n")
+		fmt.Fprintf(os.Stderr, "  %s serve -u http://localhost:2283 -k YOUR_KEY --listen 127.0.0.1:8080\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Review duplicate groups in a browser before deleting\n")
+		fmt.Fprintf(os.Stderr, "  %s html-report review.html -u http://localhost:2283 -k YOUR_KEY --policy balanced\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Plan deletions, review the plan, then apply it\n")
+		fmt.Fprintf(os.Stderr, "  %s plan plan.json -u http://localhost:2283 -k YOUR_KEY -d\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s apply plan.json -u http://localhost:2283 -k YOUR_KEY\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Stop false positives from being reported as duplicates\n")
+		fmt.Fprintf(os.Stderr, "  %s not-duplicates --ids-file false-positives.txt -u http://localhost:2283 -k YOUR_KEY\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Keep the API key out of the process list and shell history\n")
+		fmt.Fprintf(os.Stderr, "  IMMICH_API_KEY=$(cat ~/.immich-key) %s -u http://localhost:2283 --dry-run\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s -u http://localhost:2283 --api-key-command \"pass show immich\" --dry-run\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Use the settings of a profile of the configuration file\n")
+		fmt.Fprintf(os.Stderr, "  %s --profile home --dry-run\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Process the duplicates of every member of a household\n")
+		fmt.Fprintf(os.Stderr, "  %s --users alice,bob,carol -d\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Diagnose connection and API key problems\n")
+		fmt.Fprintf(os.Stderr, "  %s doctor -u https://photos.example.com\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  # Revert a previous run\n")
+		fmt.Fprintf(os.Stderr, "  %s undo 20240101-120000-a1b2c3 -u http://localhost:2283 -k YOUR_KEY\n\n", os.Args[0])
 	}
 
-	flag.Parse()
+	if err := flag.CommandLine.Parse(args); err != nil {
+		os.Exit(2)
+	}
 
 	if *showVersion {
 		fmt.Printf("Immich Duplicate Cleaner v%s\n", version)
@@ -188,13 +719,95 @@ func parseFlags() *Config {
 	return config
 }
 
-// validateConfig validates the configuration
+// registerFlags defines the flags setting config on fs
+func registerFlags(fs *flag.FlagSet, config *Config) {
+	fs.StringVar(&config.ImmichURL, "url", "", "Immich server URL (e.g., http://localhost:2283)")
+	fs.StringVar(&config.ImmichURL, "u", "", "Immich server URL (shorthand)")
+	fs.StringVar(&config.APIKey, "api-key", "", "Immich API key (visible to other users in the process list; prefer $IMMICH_API_KEY, --api-key-file or --api-key-command)")
+	fs.StringVar(&config.APIKey, "k", "", "Immich API key (shorthand)")
+	fs.StringVar(&config.APIKeyFile, "api-key-file", "", "File whose first line is the Immich API key")
+	fs.StringVar(&config.APIKeyCommand, "api-key-command", "", "Shell command printing the Immich API key (e.g., \"pass show immich\")")
+	fs.StringVar(&config.ConfigPath, "config", "", fmt.Sprintf("Configuration file (default: first %s found in the XDG configuration directories, or $%s)", configFileName, envConfigPath))
+	fs.StringVar(&config.Profile, "profile", "", fmt.Sprintf("Profile of the configuration file to use (default: its profile key, or $%s)", envProfile))
+	fs.BoolVar(&config.AutoDelete, "auto-delete", false, "Automatically delete lower-quality duplicates")
+	fs.BoolVar(&config.AutoDelete, "d", false, "Automatically delete lower-quality duplicates (shorthand)")
+	fs.BoolVar(&config.Merge, "merge-metadata", false, "Merge favorites, ratings, descriptions, archive state and tags into the kept asset before deleting duplicates")
+	fs.BoolVar(&config.Stack, "stack", false, "Stack duplicates behind the best quality asset instead of deleting them")
+	fs.BoolVar(&config.DryRun, "dry-run", false, "Preview actions without making changes")
+	fs.BoolVar(&config.Trash, "trash", false, "Move deleted duplicates to the Immich trash (default)")
+	fs.BoolVar(&config.Permanent, "permanent", false, "Permanently delete duplicates, bypassing the Immich trash")
+	fs.BoolVar(&config.Yes, "yes", false, "Skip confirmation prompts")
+	fs.BoolVar(&config.Yes, "y", false, "Skip confirmation prompts (shorthand)")
+	fs.BoolVar(&config.Interactive, "interactive", false, "Review each duplicate group and choose the asset to keep")
+	fs.BoolVar(&config.Interactive, "i", false, "Review each duplicate group and choose the asset to keep (shorthand)")
+	fs.BoolVar(&config.Verbose, "verbose", false, "Enable verbose logging")
+	fs.BoolVar(&config.Verbose, "v", false, "Enable verbose logging (shorthand)")
+	fs.StringVar(&config.Policy, "policy", policyLegacy, fmt.Sprintf("Quality policy used to pick the asset to keep (%s)", strings.Join(policyNames(), ", ")))
+	fs.StringVar(&config.Weights, "weights", "", "Criterion weight overrides for weighted policies (e.g., resolution=4,size=1,gps=2)")
+	fs.StringVar(&config.JournalPath, "journal", defaultJournalPath(), "Append-only journal of every change, used by the undo command (empty to disable)")
+	fs.StringVar(&config.ReportPath, "report", "", "Write a JSON report of the run to this file")
+	fs.StringVar(&config.CheckpointPath, "checkpoint", defaultCheckpointPath(), "Checkpoint file recording completed duplicate groups (empty to disable)")
+	fs.BoolVar(&config.Resume, "resume", false, "Skip duplicate groups completed by a previous run recorded in the checkpoint")
+	fs.BoolVar(&config.AlbumCache, "album-cache", false, "List every album once up front instead of querying the albums of each asset")
+	fs.BoolVar(&config.BatchAlbums, "batch-albums", false, "Synchronize the albums of all groups up front, sending the additions of each album in batches")
+	fs.IntVar(&config.BatchSize, "batch-size", defaultAlbumBatchSize, "Maximum number of assets per album addition request with --batch-albums")
+	fs.StringVar(&config.Users, "users", "", "Comma-separated configuration profiles of users whose duplicates are processed one after the other")
+	fs.StringVar(&config.Listen, "listen", defaultListenAddress, "Address the serve command listens on")
+	fs.BoolVar(&config.AllowRemote, "allow-remote", false, "Let the serve command listen on an address reachable from other machines")
+	fs.StringVar(&config.IDsFile, "ids-file", "", "File listing one duplicate group ID per line for the not-duplicates command (- for standard input, with --yes)")
+	fs.IntVar(&config.Concurrency, "concurrency", 1, "Number of duplicate groups processed in parallel")
+	fs.BoolVar(&config.SkipVersionCheck, "skip-version-check", false, "Run destructive modes even if the server version is not supported")
+	fs.IntVar(&config.MaxAttempts, "max-attempts", defaultMaxAttempts, "Maximum attempts per request on transient errors (429, 502, 503, 504, connection errors)")
+	fs.Float64Var(&config.ResolutionTolerance, "resolution-tolerance", defaultResolutionTolerance, "Relative pixel-count difference treated as equal by the resolution policy")
+}
+
+// validateConfig validates the configuration. Errors about settings taken
+// from the configuration file or the environment point at where they were set.
 func validateConfig(config *Config) error {
 	if config.ImmichURL == "" {
+		if config.ConfigPath != "" {
+			return fmt.Errorf("--url is required (set url in %s or IMMICH_URL)", config.ConfigPath)
+		}
 		return fmt.Errorf("--url is required")
 	}
 	if config.APIKey == "" {
-		return fmt.Errorf("--api-key is required")
+		if config.ConfigPath != "" {
+			return fmt.Errorf("--api-key, --api-key-file, --api-key-command or IMMICH_API_KEY is required (or set api-key, api-key-file or api-key-command in %s)", config.ConfigPath)
+		}
+		return fmt.Errorf("--api-key, --api-key-file, --api-key-command or IMMICH_API_KEY is required")
+	}
+
+	if config.AutoDelete && config.Stack {
+		return config.settingError(fmt.Errorf("--auto-delete and --stack are mutually exclusive"), "auto-delete", "stack")
+	}
+	if config.Merge && !config.AutoDelete {
+		return config.settingError(fmt.Errorf("--merge-metadata requires --auto-delete"), "merge-metadata")
+	}
+	if config.Interactive && !config.AutoDelete && !config.Stack {
+		return config.settingError(fmt.Errorf("--interactive requires --auto-delete or --stack"), "interactive")
+	}
+	if config.Interactive && config.Yes {
+		return config.settingError(fmt.Errorf("--interactive and --yes are mutually exclusive"), "interactive", "yes")
+	}
+	if config.Concurrency < 0 {
+		return config.settingError(fmt.Errorf("--concurrency cannot be negative, got %d", config.Concurrency), "concurrency")
+	}
+	if config.BatchSize < 0 {
+		return config.settingError(fmt.Errorf("--batch-size cannot be negative, got %d", config.BatchSize), "batch-size")
+	}
+	if config.MaxAttempts < 0 {
+		return config.settingError(fmt.Errorf("--max-attempts cannot be negative, got %d", config.MaxAttempts), "max-attempts")
+	}
+	if config.Trash && config.Permanent {
+		return config.settingError(fmt.Errorf("--trash and --permanent are mutually exclusive"), "trash", "permanent")
+	}
+
+	// Check the policy name on its own so that its errors point at it
+	if _, err := newQualityPolicy(&Config{Policy: config.Policy}); err != nil {
+		return config.settingError(err, "policy")
+	}
+	if _, err := newQualityPolicy(config); err != nil {
+		return config.settingError(err, "weights", "resolution-tolerance")
 	}
 
 	// Trim trailing slash from URL
@@ -204,72 +817,138 @@ func validateConfig(config *Config) error {
 }
 
 // processDuplicateGroup handles a single duplicate group
-func processDuplicateGroup(config *Config, groupNum, totalGroups int, group DuplicateGroup) error {
-	logInfo("\n📁 Processing group %d/%d (%d assets)", groupNum, totalGroups, len(group.Assets))
+func processDuplicateGroup(config *Config, stats *Stats, groupNum, totalGroups int, group immich.DuplicateGroup) error {
+	config.logInfo("\n📁 Processing group %d/%d (%d assets)", groupNum, totalGroups, len(group.Assets))
 
 	if len(group.Assets) < 2 {
-		logWarning("⚠️  Skipping group - less than 2 assets")
+		config.logWarning("⚠️  Skipping group - less than 2 assets")
 		return nil
 	}
 
-	// Step 1: Synchronize albums
-	syncCount, err := synchronizeAlbums(config, group)
-	if err != nil {
-		return fmt.Errorf("album synchronization failed: %w", err)
-	}
+	// Step 1: Synchronize albums, unless they were synchronized in batch
+	var assetAlbums map[string][]immich.Album
+	if config.albumPlan != nil {
+		albums, err := config.albumPlan.Albums(group.DuplicateID)
+		if err != nil {
+			return fmt.Errorf("album synchronization failed: %w", err)
+		}
+		assetAlbums = albums
 
-	if syncCount > 0 {
-		logInfo("✨ Synchronized %d asset(s) across albums", syncCount)
+		config.groupReport.setAlbums(assetAlbums)
+		for albumID, assetIDs := range config.albumPlan.placed[group.DuplicateID] {
+			config.groupReport.addToAlbum(albumID, assetIDs)
+		}
 	} else {
-		logInfo("✓ Albums already synchronized")
+		syncCount, albums, err := synchronizeAlbums(config, group)
+		if err != nil {
+			return fmt.Errorf("album synchronization failed: %w", err)
+		}
+		assetAlbums = albums
+
+		stats.Synced += syncCount
+		if syncCount > 0 {
+			config.logInfo("✨ Synchronized %d asset(s) across albums", syncCount)
+		} else {
+			config.logInfo("✓ Albums already synchronized")
+		}
 	}
 
-	// Step 2: Auto-delete if enabled
-	if config.AutoDelete {
-		if err := autoDeleteDuplicates(config, group); err != nil {
+	// Step 2: Resolve duplicates if enabled
+	switch {
+	case config.AutoDelete:
+		if err := autoDeleteDuplicates(config, stats, group, assetAlbums); err != nil {
 			return fmt.Errorf("auto-delete failed: %w", err)
 		}
+	case config.Stack:
+		if err := stackDuplicates(config, stats, group, assetAlbums); err != nil {
+			return fmt.Errorf("stacking failed: %w", err)
+		}
 	}
 
 	return nil
 }
 
-// synchronizeAlbums ensures all duplicates are in the same albums
-func synchronizeAlbums(config *Config, group DuplicateGroup) (int, error) {
-	// Fetch albums for each asset
-	assetAlbums := make(map[string][]Album)
-	allAlbumIDs := make(map[string]bool)
-
-	for _, asset := range group.Assets {
-		albums, err := getAlbumsForAsset(config, asset.ID)
-		if err != nil {
-			logWarning("⚠️  Failed to fetch albums for asset %s: %v", truncateID(asset.ID), err)
-			continue
-		}
-		assetAlbums[asset.ID] = albums
-
-		for _, album := range albums {
-			allAlbumIDs[album.ID] = true
-		}
-	}
+// synchronizeAlbums ensures all duplicates are in the same albums. It returns
+// the number of assets actually added and the album assignments found before
+// syncing. Assets the server refused to add are reported as warnings.
+func synchronizeAlbums(config *Config, group immich.DuplicateGroup) (int, map[string][]immich.Album, error) {
+	assetAlbums := fetchAssetAlbums(config, group)
+	config.groupReport.setAlbums(assetAlbums)
 
 	// Display current album assignments
 	if config.Verbose {
-		logInfo("📋 Current album assignments:")
+		config.logInfo("📋 Current album assignments:")
 		for assetID, albums := range assetAlbums {
 			albumNames := make([]string, len(albums))
 			for i, album := range albums {
 				albumNames[i] = album.AlbumName
 			}
-			logInfo("   Asset %s: %v", truncateID(assetID), albumNames)
+			config.logInfo("   Asset %s: %v", truncateID(assetID), albumNames)
 		}
 	}
 
 	// Synchronize albums
 	syncCount := 0
-	for albumID := range allAlbumIDs {
-		assetsToAdd := []string{}
+	for albumID, assetsToAdd := range missingAlbumAssets(group, assetAlbums, config.ownerID) {
+		if config.DryRun {
+			config.logInfo("   [DRY RUN] Would add %d asset(s) to album %s", len(assetsToAdd), truncateID(albumID))
+			config.groupReport.addToAlbum(albumID, assetsToAdd)
+			syncCount += len(assetsToAdd)
+			continue
+		}
 
+		addition, err := addAssetsToAlbum(config, albumID, assetsToAdd)
+		if err != nil {
+			config.logError("❌ Failed to add assets to album %s: %v", truncateID(albumID), err)
+			continue
+		}
+		for _, result := range addition.Failed {
+			config.logWarning("⚠️  Could not add asset %s to album %s: %s", truncateID(result.ID), truncateID(albumID), result.Error)
+		}
+		if len(addition.Added) > 0 {
+			config.logInfo("✅ Added %d asset(s) to album %s", len(addition.Added), truncateID(albumID))
+		}
+		if len(addition.Present) > 0 && config.Verbose {
+			config.logInfo("✓ %d asset(s) already in album %s", len(addition.Present), truncateID(albumID))
+		}
+		config.groupReport.addToAlbum(albumID, addition.Added)
+		config.groupReport.addToAlbum(albumID, addition.Present)
+		syncCount += len(addition.Added)
+	}
+
+	return syncCount, assetAlbums, nil
+}
+
+// fetchAssetAlbums returns the albums containing each asset of a group.
+// Assets whose albums could not be fetched are left out.
+func fetchAssetAlbums(config *Config, group immich.DuplicateGroup) map[string][]immich.Album {
+	assetAlbums := make(map[string][]immich.Album)
+	for _, asset := range group.Assets {
+		albums, err := albumsForAsset(config, asset.ID)
+		if err != nil {
+			config.logWarning("⚠️  Failed to fetch albums for asset %s: %v", truncateID(asset.ID), err)
+			continue
+		}
+		assetAlbums[asset.ID] = albums
+	}
+	return assetAlbums
+}
+
+// missingAlbumAssets returns, for every album containing an asset of the
+// group, the assets of the group missing from it. Unless ownerID is empty,
+// only albums owned by that user are considered.
+func missingAlbumAssets(group immich.DuplicateGroup, assetAlbums map[string][]immich.Album, ownerID string) map[string][]string {
+	allAlbumIDs := make(map[string]bool)
+	for _, albums := range assetAlbums {
+		for _, album := range albums {
+			if ownerID == "" || album.OwnerID == ownerID {
+				allAlbumIDs[album.ID] = true
+			}
+		}
+	}
+
+	missing := make(map[string][]string)
+	for albumID := range allAlbumIDs {
 		// Find assets not in this album
 		for _, asset := range group.Assets {
 			inAlbum := false
@@ -280,138 +959,278 @@ func synchronizeAlbums(config *Config, group DuplicateGroup) (int, error) {
 				}
 			}
 			if !inAlbum {
-				assetsToAdd = append(assetsToAdd, asset.ID)
-			}
-		}
-
-		// Add missing assets to album
-		if len(assetsToAdd) > 0 {
-			if config.DryRun {
-				logInfo("   [DRY RUN] Would add %d asset(s) to album %s", len(assetsToAdd), truncateID(albumID))
-				syncCount += len(assetsToAdd)
-			} else {
-				if err := addAssetsToAlbum(config, albumID, assetsToAdd); err != nil {
-					logError("❌ Failed to add assets to album %s: %v", truncateID(albumID), err)
-				} else {
-					logInfo("✅ Added %d asset(s) to album %s", len(assetsToAdd), truncateID(albumID))
-					syncCount += len(assetsToAdd)
-				}
+				missing[albumID] = append(missing[albumID], asset.ID)
 			}
 		}
 	}
+	return missing
+}
 
-	return syncCount, nil
+// Selection is the outcome of ranking the assets of a duplicate group
+type Selection struct {
+	KeeperID string                          // Asset to keep
+	Others   []string                        // Remaining assets, in group order
+	Details  map[string]*immich.AssetDetails // Details of every ranked asset
+	Ranked   []ScoredCandidate               // Policy ranking, best first
 }
 
-// autoDeleteDuplicates automatically deletes lower-quality duplicates
-func autoDeleteDuplicates(config *Config, group DuplicateGroup) error {
-	logInfo("\n🔍 Analyzing quality of %d duplicate(s)...", len(group.Assets))
+// selectKeeper fetches the details of every asset in a group and ranks them
+// with the configured policy. It returns nil if fewer than two assets could be
+// compared.
+func selectKeeper(config *Config, group immich.DuplicateGroup, assetAlbums map[string][]immich.Album) (*Selection, error) {
+	policy, err := newQualityPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	config.logInfo("\n🔍 Analyzing quality of %d duplicate(s)...", len(group.Assets))
 
 	// Fetch detailed info for all assets
-	assetDetails := make(map[string]*AssetDetails)
+	assetDetails := make(map[string]*immich.AssetDetails)
 	for _, asset := range group.Assets {
-		details, err := getAssetDetails(config, asset.ID)
+		details, err := config.api().GetAssetDetails(config.context(), asset.ID)
 		if err != nil {
-			logWarning("⚠️  Failed to fetch details for asset %s: %v", truncateID(asset.ID), err)
+			config.logWarning("⚠️  Failed to fetch details for asset %s: %v", truncateID(asset.ID), err)
 			continue
 		}
 		assetDetails[asset.ID] = details
 	}
 
 	if len(assetDetails) < 2 {
-		logWarning("⚠️  Not enough asset details to compare quality")
-		return nil
+		config.logWarning("⚠️  Not enough asset details to compare quality")
+		return nil, nil
+	}
+
+	// Rank candidates with the configured policy
+	candidates := make([]Candidate, 0, len(assetDetails))
+	for _, asset := range group.Assets {
+		if details, ok := assetDetails[asset.ID]; ok {
+			candidates = append(candidates, Candidate{Details: details, AlbumCount: len(assetAlbums[asset.ID])})
+		}
 	}
 
-	// Find the best quality asset
-	bestAssetID := selectBestQualityAsset(assetDetails)
-	if bestAssetID == "" {
-		return fmt.Errorf("failed to determine best quality asset")
+	ranked := policy.Rank(candidates)
+	if len(ranked) == 0 {
+		return nil, fmt.Errorf("failed to determine best quality asset")
 	}
+	bestAssetID := ranked[0].ID
+	config.groupReport.setSelection(policy.Name(), ranked)
 
-	logInfo("🏆 Best quality asset: %s", truncateID(bestAssetID))
+	config.logInfo("🏆 Best quality asset: %s (policy %s, score %.2f)", truncateID(bestAssetID), policy.Name(), ranked[0].Total)
+	config.logInfo("   Score breakdown: %s", formatBreakdown(ranked[0].Breakdown))
+	if ranked[0].Reason != "" {
+		config.logInfo("   Reason: %s", ranked[0].Reason)
+	}
+	if config.Verbose {
+		for _, scored := range ranked[1:] {
+			config.logInfo("   Candidate %s: score %.2f (%s)", truncateID(scored.ID), scored.Total, formatBreakdown(scored.Breakdown))
+		}
+	}
 	if config.Verbose && assetDetails[bestAssetID].ExifInfo != nil {
-		logInfo("   Size: %d bytes, Resolution: %dx%d",
+		config.logInfo("   Size: %d bytes, Resolution: %dx%d",
 			assetDetails[bestAssetID].ExifInfo.FileSizeInByte,
 			assetDetails[bestAssetID].ExifInfo.ImageWidth,
 			assetDetails[bestAssetID].ExifInfo.ImageHeight)
 	}
 
-	// Identify assets to delete
-	assetsToDelete := []string{}
-	for assetID := range assetDetails {
-		if assetID != bestAssetID {
-			assetsToDelete = append(assetsToDelete, assetID)
+	selection := &Selection{KeeperID: bestAssetID, Details: assetDetails, Ranked: ranked}
+	for _, asset := range group.Assets {
+		if _, ok := assetDetails[asset.ID]; ok && asset.ID != bestAssetID {
+			selection.Others = append(selection.Others, asset.ID)
+		}
+	}
+
+	return selection, nil
+}
+
+// withKeeper returns a copy of the selection keeping another of its assets
+func (s *Selection) withKeeper(group immich.DuplicateGroup, keeperID string) *Selection {
+	selection := &Selection{KeeperID: keeperID, Details: s.Details, Ranked: s.Ranked}
+	for _, asset := range group.Assets {
+		if _, ok := s.Details[asset.ID]; ok && asset.ID != keeperID {
+			selection.Others = append(selection.Others, asset.ID)
+		}
+	}
+	return selection
+}
+
+// autoDeleteDuplicates automatically deletes lower-quality duplicates
+func autoDeleteDuplicates(config *Config, stats *Stats, group immich.DuplicateGroup, assetAlbums map[string][]immich.Album) error {
+	selection, err := selectKeeper(config, group, assetAlbums)
+	if err != nil || selection == nil {
+		return err
+	}
+
+	// Let the user choose the asset to keep
+	if config.review != nil {
+		if selection, err = reviewSelection(config, stats, group, selection, assetAlbums); err != nil || selection == nil {
+			return err
 		}
 	}
 
+	assetsToDelete := selection.Others
 	if len(assetsToDelete) == 0 {
-		logInfo("✓ No duplicates to delete")
+		config.logInfo("✓ No duplicates to delete")
 		return nil
 	}
 
-	// Confirm deletion unless --yes flag is set
-	if !config.Yes && !config.DryRun {
-		fmt.Printf("\n⚠️  About to delete %d duplicate(s). Continue? [y/N]: ", len(assetsToDelete))
-		var response string
-		if _, err := fmt.Scanln(&response); err != nil {
+	// Confirm deletion unless --yes flag is set or the group was reviewed
+	if !config.Yes && !config.DryRun && config.review == nil {
+		response, err := config.prompt(fmt.Sprintf("\n⚠️  About to %s %d duplicate(s). Continue? [y/N]: ", deleteAction(config), len(assetsToDelete)))
+		if err != nil {
 			// User cancelled or error reading input
-			logInfo("❌ Deletion cancelled")
+			config.logInfo("❌ Deletion cancelled")
+			stats.Cancelled++
 			return nil
 		}
 		if !strings.EqualFold(response, "y") && !strings.EqualFold(response, "yes") {
-			logInfo("❌ Deletion cancelled by user")
+			config.logInfo("❌ Deletion cancelled by user")
+			stats.Cancelled++
 			return nil
 		}
 	}
 
-	// Delete duplicates
+	return deleteSelection(config, stats, selection)
+}
+
+// deleteAction describes how duplicates are deleted
+func deleteAction(config *Config) string {
+	if config.Permanent {
+		return "permanently delete"
+	}
+	return "move to trash"
+}
+
+// deleteSelection merges metadata into the kept asset if requested, then
+// deletes the other assets of the selection
+func deleteSelection(config *Config, stats *Stats, selection *Selection) error {
+	assetsToDelete := selection.Others
+	action := deleteAction(config)
+
+	// Carry metadata over to the keeper before anything is deleted
+	if config.Merge {
+		if err := mergeMetadata(config, selection); err != nil {
+			return fmt.Errorf("metadata merge failed, nothing deleted: %w", err)
+		}
+	}
+
+	// Delete duplicates in a single request
+	if config.DryRun {
+		for _, assetID := range assetsToDelete {
+			config.logInfo("   [DRY RUN] Would %s asset %s", action, truncateID(assetID))
+		}
+		if config.groupReport != nil {
+			config.groupReport.Deleted = assetsToDelete
+		}
+		return nil
+	}
+
+	if err := deleteAssets(config, assetsToDelete); err != nil {
+		return fmt.Errorf("failed to %s %d asset(s): %w", action, len(assetsToDelete), err)
+	}
+	if config.groupReport != nil {
+		config.groupReport.Deleted = assetsToDelete
+	}
+
 	for _, assetID := range assetsToDelete {
-		if config.DryRun {
-			logInfo("   [DRY RUN] Would delete asset %s", truncateID(assetID))
+		if config.Permanent {
+			config.logInfo("🗑️  Permanently deleted duplicate asset %s", truncateID(assetID))
 		} else {
-			if err := deleteAsset(config, assetID); err != nil {
-				logError("❌ Failed to delete asset %s: %v", truncateID(assetID), err)
-			} else {
-				logInfo("🗑️  Deleted duplicate asset %s", truncateID(assetID))
-			}
+			config.logInfo("🗑️  Moved duplicate asset %s to trash", truncateID(assetID))
+		}
+	}
+	if config.Permanent {
+		stats.Deleted += len(assetsToDelete)
+	} else {
+		stats.Trashed += len(assetsToDelete)
+	}
+
+	return nil
+}
+
+// stackDuplicates groups the duplicates into an Immich stack with the best
+// quality asset as the primary asset
+func stackDuplicates(config *Config, stats *Stats, group immich.DuplicateGroup, assetAlbums map[string][]immich.Album) error {
+	selection, err := selectKeeper(config, group, assetAlbums)
+	if err != nil || selection == nil {
+		return err
+	}
+
+	// Let the user choose the primary asset
+	if config.review != nil {
+		if selection, err = reviewSelection(config, stats, group, selection, assetAlbums); err != nil || selection == nil {
+			return err
 		}
 	}
 
+	return stackSelection(config, stats, selection)
+}
+
+// stackSelection stacks the assets of the selection with the kept asset as
+// the primary asset
+func stackSelection(config *Config, stats *Stats, selection *Selection) error {
+	// The first asset of a stack is its primary asset
+	assetIDs := append([]string{selection.KeeperID}, selection.Others...)
+
+	if config.DryRun {
+		config.logInfo("   [DRY RUN] Would stack %d asset(s) with primary asset %s", len(assetIDs), truncateID(selection.KeeperID))
+		return nil
+	}
+
+	stack, err := createStack(config, assetIDs)
+	if err != nil {
+		return fmt.Errorf("failed to stack %d asset(s): %w", len(assetIDs), err)
+	}
+
+	config.logInfo("📚 Stacked %d asset(s) with primary asset %s", len(assetIDs), truncateID(selection.KeeperID))
+	if config.groupReport != nil {
+		config.groupReport.StackID = stack.ID
+	}
+	if config.Verbose {
+		config.logInfo("   Stack ID: %s", stack.ID)
+	}
+	stats.Stacked++
+
 	return nil
 }
 
 // selectBestQualityAsset determines which asset has the best quality
-// Priority: 1) File size (larger is better), 2) Original filename, 3) Creation date
-func selectBestQualityAsset(assets map[string]*AssetDetails) string {
+// Priority: 1) File size (larger is better), 2) Original filename, 3) Creation
+// date (earlier is better), 4) Asset ID, so that the choice never depends on
+// the iteration order of assets
+func selectBestQualityAsset(assets map[string]*immich.AssetDetails) string {
 	var bestID string
-	var bestSize int64 = -1
 
 	for assetID, details := range assets {
 		if details.ExifInfo == nil {
 			continue
 		}
-
-		size := details.ExifInfo.FileSizeInByte
-
-		// Prefer larger files
-		if size > bestSize {
-			bestSize = size
+		if bestID == "" || betterQuality(assetID, details, bestID, assets[bestID]) {
 			bestID = assetID
-		} else if size == bestSize && bestID != "" {
-			// If same size, prefer original filename (no IMG_, DSC_, etc.)
-			if isOriginalFilename(details.OriginalFileName) && !isOriginalFilename(assets[bestID].OriginalFileName) {
-				bestID = assetID
-			} else if details.FileCreatedAt.Before(assets[bestID].FileCreatedAt) {
-				// If same size and both/neither original, prefer earlier creation date
-				bestID = assetID
-			}
 		}
 	}
 
 	return bestID
 }
 
+// betterQuality reports whether asset a ranks before asset b in the
+// selectBestQualityAsset ordering. Both assets must have EXIF information.
+func betterQuality(aID string, a *immich.AssetDetails, bID string, b *immich.AssetDetails) bool {
+	// Prefer larger files
+	if a.ExifInfo.FileSizeInByte != b.ExifInfo.FileSizeInByte {
+		return a.ExifInfo.FileSizeInByte > b.ExifInfo.FileSizeInByte
+	}
+	// If same size, prefer original filename (no IMG_, DSC_, etc.)
+	if aOriginal := isOriginalFilename(a.OriginalFileName); aOriginal != isOriginalFilename(b.OriginalFileName) {
+		return aOriginal
+	}
+	// If same size and both/neither original, prefer earlier creation date
+	if !a.FileCreatedAt.Equal(b.FileCreatedAt) {
+		return a.FileCreatedAt.Before(b.FileCreatedAt)
+	}
+	return aID < bID
+}
+
 // isOriginalFilename checks if a filename appears to be an original (not auto-generated)
 func isOriginalFilename(filename string) bool {
 	upper := strings.ToUpper(filename)
@@ -426,204 +1245,227 @@ func isOriginalFilename(filename string) bool {
 	return true
 }
 
-// getDuplicates fetches all duplicate groups from Immich
-func getDuplicates(config *Config) ([]DuplicateGroup, error) {
-	url := fmt.Sprintf("%s%s", config.ImmichURL, duplicatesEndpoint)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// albumsForAsset returns the albums containing an asset, from the album index
+// when one was built
+func albumsForAsset(config *Config, assetID string) ([]immich.Album, error) {
+	if config.albums != nil {
+		return config.albums.AlbumsForAsset(assetID), nil
 	}
+	return config.api().GetAlbumsForAsset(config.context(), assetID)
+}
 
-	req.Header.Set("x-api-key", config.APIKey)
-	req.Header.Set("Accept", "application/json")
+// AlbumAddition is the outcome of adding assets to an album
+type AlbumAddition struct {
+	Added   []string              // Assets added by the request
+	Present []string              // Assets that were already in the album
+	Failed  []immich.BulkIDResult // Assets that could not be added
+}
 
-	resp, err := httpClient.Do(req)
+// addAssetsToAlbum adds assets to an album, journals the assets actually added
+// and keeps the album index up to date. Assets without a result in the
+// response are assumed added.
+func addAssetsToAlbum(config *Config, albumID string, assetIDs []string) (*AlbumAddition, error) {
+	results, err := config.api().AddAssetsToAlbum(config.context(), albumID, assetIDs)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, err
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logError("Failed to close response body: %v", err)
-		}
-	}()
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to read response body: %w", resp.StatusCode, err)
-		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+	outcomes := make(map[string]immich.BulkIDResult, len(results))
+	for _, result := range results {
+		outcomes[result.ID] = result
 	}
 
-	var duplicates []DuplicateGroup
-	if err := json.NewDecoder(resp.Body).Decode(&duplicates); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	addition := &AlbumAddition{}
+	for _, assetID := range assetIDs {
+		result, ok := outcomes[assetID]
+		switch {
+		case !ok || result.Success:
+			addition.Added = append(addition.Added, assetID)
+		case result.Error == immich.BulkErrorDuplicate:
+			addition.Present = append(addition.Present, assetID)
+		default:
+			addition.Failed = append(addition.Failed, result)
+		}
 	}
 
-	return duplicates, nil
+	if len(addition.Added) > 0 {
+		config.journal.Record(JournalEntry{Action: actionAlbumAdd, AlbumID: albumID, AssetIDs: addition.Added})
+	}
+	config.albums.Add(albumID, addition.Added)
+	config.albums.Add(albumID, addition.Present)
+	return addition, nil
 }
 
-// getAlbumsForAsset fetches all albums containing a specific asset
-func getAlbumsForAsset(config *Config, assetID string) ([]Album, error) {
-	url := fmt.Sprintf("%s%s?assetId=%s", config.ImmichURL, albumsEndpoint, assetID)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// deleteAssets deletes assets in a single request and journals the change.
+// Assets are moved to the trash unless config.Permanent is set.
+func deleteAssets(config *Config, assetIDs []string) error {
+	if err := config.api().DeleteAssets(config.context(), assetIDs, config.Permanent); err != nil {
+		return err
 	}
+	config.journal.Record(JournalEntry{Action: actionDelete, AssetIDs: assetIDs, Permanent: config.Permanent})
+	return nil
+}
 
-	req.Header.Set("x-api-key", config.APIKey)
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := httpClient.Do(req)
+// createStack stacks assets, the first being the primary asset, and journals
+// the change
+func createStack(config *Config, assetIDs []string) (*immich.Stack, error) {
+	stack, err := config.api().CreateStack(config.context(), assetIDs)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, err
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logError("Failed to close response body: %v", err)
-		}
-	}()
+	config.journal.Record(JournalEntry{Action: actionStack, StackID: stack.ID, AssetIDs: assetIDs})
+	return stack, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to read response body: %w", resp.StatusCode, err)
-		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+// removeAssetsFromAlbum removes assets from an album, journals the change and
+// keeps the album index up to date
+func removeAssetsFromAlbum(config *Config, albumID string, assetIDs []string) error {
+	if err := config.api().RemoveAssetsFromAlbum(config.context(), albumID, assetIDs); err != nil {
+		return err
 	}
+	config.journal.Record(JournalEntry{Action: actionAlbumRemove, AlbumID: albumID, AssetIDs: assetIDs})
+	config.albums.Remove(albumID, assetIDs)
+	return nil
+}
 
-	var albums []Album
-	if err := json.NewDecoder(resp.Body).Decode(&albums); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+// restoreAssets restores assets from the trash and journals the change
+func restoreAssets(config *Config, assetIDs []string) error {
+	if err := config.api().RestoreAssets(config.context(), assetIDs); err != nil {
+		return err
 	}
-
-	return albums, nil
+	config.journal.Record(JournalEntry{Action: actionRestore, AssetIDs: assetIDs})
+	return nil
 }
 
-// getAssetDetails fetches detailed information about an asset
-func getAssetDetails(config *Config, assetID string) (*AssetDetails, error) {
-	url := fmt.Sprintf("%s%s/%s", config.ImmichURL, assetsEndpoint, assetID)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+// deleteStack removes a stack and journals the change
+func deleteStack(config *Config, stackID string, assetIDs []string) error {
+	if err := config.api().DeleteStack(config.context(), stackID); err != nil {
+		return err
 	}
+	config.journal.Record(JournalEntry{Action: actionStackDelete, StackID: stackID, AssetIDs: assetIDs})
+	return nil
+}
 
-	req.Header.Set("x-api-key", config.APIKey)
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+// untagAssets removes a tag from assets and journals the change
+func untagAssets(config *Config, tagID string, assetIDs []string) error {
+	if err := config.api().UntagAssets(config.context(), tagID, assetIDs); err != nil {
+		return err
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logError("Failed to close response body: %v", err)
-		}
-	}()
+	config.journal.Record(JournalEntry{Action: actionUntag, TagID: tagID, AssetIDs: assetIDs})
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to read response body: %w", resp.StatusCode, err)
-		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+// revertMetadata sets the metadata of an asset back to previous, replacing
+// the values of update, and journals the change
+func revertMetadata(config *Config, assetID string, previous immich.UpdateAssetRequest, update *immich.UpdateAssetRequest) error {
+	if err := config.api().UpdateAsset(config.context(), assetID, previous); err != nil {
+		return err
 	}
+	config.journal.Record(JournalEntry{Action: actionMetadataRevert, AssetIDs: []string{assetID}, Update: &previous, Previous: update})
+	return nil
+}
 
-	var details AssetDetails
-	if err := json.NewDecoder(resp.Body).Decode(&details); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+// markNotDuplicates tells the server that the assets of a group are not
+// duplicates of each other, so that the group is no longer reported, and
+// journals the change
+func markNotDuplicates(config *Config, group immich.DuplicateGroup) error {
+	assetIDs := make([]string, len(group.Assets))
+	for i, asset := range group.Assets {
+		assetIDs[i] = asset.ID
 	}
 
-	return &details, nil
+	if config.DryRun {
+		config.logInfo("   [DRY RUN] Would mark %d asset(s) as not duplicates", len(assetIDs))
+		return nil
+	}
+	if err := config.api().SetDuplicateID(config.context(), assetIDs, ""); err != nil {
+		return fmt.Errorf("failed to mark %d asset(s) as not duplicates: %w", len(assetIDs), err)
+	}
+	config.journal.Record(JournalEntry{Action: actionDuplicateClear, DuplicateID: group.DuplicateID, AssetIDs: assetIDs})
+	config.logInfo("🚫 Marked %d asset(s) as not duplicates", len(assetIDs))
+	return nil
 }
 
-// addAssetsToAlbum adds assets to an album
-func addAssetsToAlbum(config *Config, albumID string, assetIDs []string) error {
-	url := fmt.Sprintf("%s%s/%s/assets", config.ImmichURL, albumsEndpoint, albumID)
-
-	requestBody := AddAssetsRequest{IDs: assetIDs}
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+// restoreDuplicateStatus returns assets to their duplicate group and journals
+// the change
+func restoreDuplicateStatus(config *Config, duplicateID string, assetIDs []string) error {
+	if err := config.api().SetDuplicateID(config.context(), assetIDs, duplicateID); err != nil {
+		return err
 	}
+	config.journal.Record(JournalEntry{Action: actionDuplicateRestore, DuplicateID: duplicateID, AssetIDs: assetIDs})
+	return nil
+}
 
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
+// Logging functions
 
-	req.Header.Set("x-api-key", config.APIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
+// outputMu serializes flushes of grouped log output and interactive prompts
+var outputMu sync.Mutex
 
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logError("Failed to close response body: %v", err)
-		}
-	}()
+// groupOutput buffers the log lines of a duplicate group processed
+// concurrently so that they are printed together
+type groupOutput struct {
+	buf    bytes.Buffer
+	logger *log.Logger
+}
 
-	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("HTTP %d: failed to read response body: %w", resp.StatusCode, err)
-		}
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
-	}
+func newGroupOutput() *groupOutput {
+	out := &groupOutput{}
+	out.logger = log.New(redactingWriter{w: &out.buf}, log.Prefix(), log.Flags())
+	return out
+}
 
-	return nil
+// flush writes the buffered lines to the standard logger. The caller must
+// hold outputMu.
+func (o *groupOutput) flush() {
+	if _, err := log.Writer().Write(o.buf.Bytes()); err != nil {
+		log.Printf("❌ Failed to write log output: %v", err)
+	}
+	o.buf.Reset()
 }
 
-// deleteAsset deletes an asset from Immich
-func deleteAsset(config *Config, assetID string) error {
-	url := fmt.Sprintf("%s%s", config.ImmichURL, assetsEndpoint)
+// promptInput reads the answers to interactive prompts
+var promptInput = bufio.NewReader(os.Stdin)
 
-	requestBody := map[string]interface{}{
-		"ids":   []string{assetID},
-		"force": true,
-	}
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
-	}
+// prompt shows the group's pending output, prints question and reads a line
+// of input with surrounding spaces removed. Other groups wait until the
+// answer is given.
+func (c *Config) prompt(question string) (string, error) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
 
-	req, err := http.NewRequest("DELETE", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+	if c.output != nil {
+		c.output.flush()
 	}
 
-	req.Header.Set("x-api-key", config.APIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+	fmt.Print(question)
+	response, err := promptInput.ReadString('\n')
+	if err != nil && (err != io.EOF || response == "") {
+		return "", err
 	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			logError("Failed to close response body: %v", err)
-		}
-	}()
+	return strings.TrimSpace(response), nil
+}
 
-	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return fmt.Errorf("HTTP %d: failed to read response body: %w", resp.StatusCode, err)
-		}
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+func (c *Config) printf(format string, args ...interface{}) {
+	if c.output == nil {
+		log.Printf(format, args...)
+		return
 	}
+	c.output.logger.Printf(format, args...)
+}
 
-	return nil
+func (c *Config) logInfo(format string, args ...interface{}) {
+	c.printf(format, args...)
 }
 
-// Logging functions
+func (c *Config) logWarning(format string, args ...interface{}) {
+	c.printf("⚠️  "+format, args...)
+	c.report.recordLog(c.groupReport, false, fmt.Sprintf(format, args...))
+}
+
+func (c *Config) logError(format string, args ...interface{}) {
+	c.printf("❌ "+format, args...)
+	c.report.recordLog(c.groupReport, true, fmt.Sprintf(format, args...))
+}
 
 func logInfo(format string, args ...interface{}) {
 	log.Printf(format, args...)
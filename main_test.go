@@ -2,11 +2,16 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"immich-duplicate-cleaner/immich"
 )
 
 // MockHTTPClient is a mock implementation of HTTPClient for testing
@@ -47,6 +52,64 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "auto-delete and stack",
+			config: &Config{
+				ImmichURL:  "http://localhost:2283",
+				APIKey:     "test-key",
+				AutoDelete: true,
+				Stack:      true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "merge metadata without auto-delete",
+			config: &Config{
+				ImmichURL: "http://localhost:2283",
+				APIKey:    "test-key",
+				Merge:     true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "trash and permanent",
+			config: &Config{
+				ImmichURL: "http://localhost:2283",
+				APIKey:    "test-key",
+				Trash:     true,
+				Permanent: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative batch size",
+			config: &Config{
+				ImmichURL: "http://localhost:2283",
+				APIKey:    "test-key",
+				BatchSize: -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "interactive without a resolution mode",
+			config: &Config{
+				ImmichURL:   "http://localhost:2283",
+				APIKey:      "test-key",
+				Interactive: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "interactive and yes",
+			config: &Config{
+				ImmichURL:   "http://localhost:2283",
+				APIKey:      "test-key",
+				AutoDelete:  true,
+				Interactive: true,
+				Yes:         true,
+			},
+			wantErr: true,
+		},
 		{
 			name: "URL with trailing slash",
 			config: &Config{
@@ -72,27 +135,58 @@ func TestValidateConfig(t *testing.T) {
 	}
 }
 
+// TestParseCommand tests splitting the subcommand from its arguments
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		wantCommand    string
+		wantPositional []string
+		wantFlags      []string
+	}{
+		{"no command", []string{"--url", "x"}, "", nil, []string{"--url", "x"}},
+		{"empty", nil, "", nil, nil},
+		{"undo with run ID", []string{"undo", "run1", "-u", "x"}, "undo", []string{"run1"}, []string{"-u", "x"}},
+		{"command without flags", []string{"undo"}, "undo", []string{}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			command, positional, flags := parseCommand(tt.args)
+			if command != tt.wantCommand {
+				t.Errorf("parseCommand() command = %q, want %q", command, tt.wantCommand)
+			}
+			if strings.Join(positional, " ") != strings.Join(tt.wantPositional, " ") {
+				t.Errorf("parseCommand() positional = %v, want %v", positional, tt.wantPositional)
+			}
+			if strings.Join(flags, " ") != strings.Join(tt.wantFlags, " ") {
+				t.Errorf("parseCommand() flags = %v, want %v", flags, tt.wantFlags)
+			}
+		})
+	}
+}
+
 // TestSelectBestQualityAsset tests the quality comparison algorithm
 func TestSelectBestQualityAsset(t *testing.T) {
 	tests := []struct {
 		name   string
-		assets map[string]*AssetDetails
+		assets map[string]*immich.AssetDetails
 		want   string
 	}{
 		{
 			name: "prefer larger file size",
-			assets: map[string]*AssetDetails{
+			assets: map[string]*immich.AssetDetails{
 				"asset1": {
 					ID:               "asset1",
 					OriginalFileName: "photo.jpg",
-					ExifInfo: &ExifInfo{
+					ExifInfo: &immich.ExifInfo{
 						FileSizeInByte: 1000000,
 					},
 				},
 				"asset2": {
 					ID:               "asset2",
 					OriginalFileName: "photo.jpg",
-					ExifInfo: &ExifInfo{
+					ExifInfo: &immich.ExifInfo{
 						FileSizeInByte: 2000000,
 					},
 				},
@@ -101,18 +195,18 @@ func TestSelectBestQualityAsset(t *testing.T) {
 		},
 		{
 			name: "same size, prefer original filename",
-			assets: map[string]*AssetDetails{
+			assets: map[string]*immich.AssetDetails{
 				"asset1": {
 					ID:               "asset1",
 					OriginalFileName: "IMG_1234.jpg",
-					ExifInfo: &ExifInfo{
+					ExifInfo: &immich.ExifInfo{
 						FileSizeInByte: 1000000,
 					},
 				},
 				"asset2": {
 					ID:               "asset2",
 					OriginalFileName: "vacation.jpg",
-					ExifInfo: &ExifInfo{
+					ExifInfo: &immich.ExifInfo{
 						FileSizeInByte: 1000000,
 					},
 				},
@@ -121,12 +215,12 @@ func TestSelectBestQualityAsset(t *testing.T) {
 		},
 		{
 			name: "same size and filename type, prefer earlier date",
-			assets: map[string]*AssetDetails{
+			assets: map[string]*immich.AssetDetails{
 				"asset1": {
 					ID:               "asset1",
 					OriginalFileName: "photo.jpg",
 					FileCreatedAt:    time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
-					ExifInfo: &ExifInfo{
+					ExifInfo: &immich.ExifInfo{
 						FileSizeInByte: 1000000,
 					},
 				},
@@ -134,13 +228,22 @@ func TestSelectBestQualityAsset(t *testing.T) {
 					ID:               "asset2",
 					OriginalFileName: "image.jpg",
 					FileCreatedAt:    time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
-					ExifInfo: &ExifInfo{
+					ExifInfo: &immich.ExifInfo{
 						FileSizeInByte: 1000000,
 					},
 				},
 			},
 			want: "asset2",
 		},
+		{
+			name: "identical assets, prefer lowest ID",
+			assets: map[string]*immich.AssetDetails{
+				"asset3": {ID: "asset3", OriginalFileName: "photo.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 1000000}},
+				"asset1": {ID: "asset1", OriginalFileName: "photo.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 1000000}},
+				"asset2": {ID: "asset2", OriginalFileName: "photo.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 1000000}},
+			},
+			want: "asset1",
+		},
 	}
 
 	for _, tt := range tests {
@@ -180,253 +283,161 @@ func TestIsOriginalFilename(t *testing.T) {
 	}
 }
 
-// TestGetDuplicates tests the getDuplicates function with a mock HTTP client
-func TestGetDuplicates(t *testing.T) {
-	tests := []struct {
-		name       string
-		mockResp   *http.Response
-		mockErr    error
-		wantGroups int
-		wantErr    bool
-	}{
-		{
-			name: "successful response",
-			mockResp: &http.Response{
-				StatusCode: http.StatusOK,
-				Body: io.NopCloser(bytes.NewBufferString(`[
-					{
-						"duplicateId": "dup1",
-						"assets": [
-							{"id": "asset1"},
-							{"id": "asset2"}
-						]
-					}
-				]`)),
-			},
-			wantGroups: 1,
-			wantErr:    false,
-		},
-		{
-			name: "empty response",
-			mockResp: &http.Response{
-				StatusCode: http.StatusOK,
-				Body:       io.NopCloser(bytes.NewBufferString(`[]`)),
-			},
-			wantGroups: 0,
-			wantErr:    false,
-		},
-		{
-			name: "HTTP error",
-			mockResp: &http.Response{
-				StatusCode: http.StatusUnauthorized,
-				Body:       io.NopCloser(bytes.NewBufferString(`{"message": "Unauthorized"}`)),
-			},
-			wantErr: true,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			// Replace global HTTP client with mock
-			oldClient := httpClient
-			defer func() { httpClient = oldClient }()
-
-			httpClient = &MockHTTPClient{
-				DoFunc: func(req *http.Request) (*http.Response, error) {
-					return tt.mockResp, tt.mockErr
-				},
-			}
-
-			config := &Config{
-				ImmichURL: "http://localhost:2283",
-				APIKey:    "test-key",
-			}
-
-			groups, err := getDuplicates(config)
-
-			if (err != nil) != tt.wantErr {
-				t.Errorf("getDuplicates() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-
-			if !tt.wantErr && len(groups) != tt.wantGroups {
-				t.Errorf("getDuplicates() returned %d groups, want %d", len(groups), tt.wantGroups)
-			}
-		})
-	}
-}
-
-// TestGetAlbumsForAsset tests the getAlbumsForAsset function
-func TestGetAlbumsForAsset(t *testing.T) {
-	mockAlbums := []Album{
-		{ID: "album1", AlbumName: "Vacation", AssetCount: 10},
-		{ID: "album2", AlbumName: "Family", AssetCount: 20},
-	}
-
-	albumsJSON, _ := json.Marshal(mockAlbums)
-
+// TestProcessGroupsConcurrent tests that concurrent workers aggregate counters
+func TestProcessGroupsConcurrent(t *testing.T) {
 	oldClient := httpClient
 	defer func() { httpClient = oldClient }()
 
+	var mu sync.Mutex
+	added := 0
+
 	httpClient = &MockHTTPClient{
 		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := `[]`
+			switch {
+			case req.Method == "GET" && strings.HasSuffix(req.URL.Query().Get("assetId"), "-a"):
+				body = `[{"id": "album1", "albumName": "Vacation"}]`
+			case req.Method == "PUT":
+				mu.Lock()
+				added++
+				mu.Unlock()
+			}
 			return &http.Response{
 				StatusCode: http.StatusOK,
-				Body:       io.NopCloser(bytes.NewBuffer(albumsJSON)),
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
 			}, nil
 		},
 	}
 
-	config := &Config{
-		ImmichURL: "http://localhost:2283",
-		APIKey:    "test-key",
+	duplicates := []immich.DuplicateGroup{}
+	for _, id := range []string{"dup1", "dup2", "dup3", "dup4", "dup5"} {
+		duplicates = append(duplicates, immich.DuplicateGroup{
+			DuplicateID: id,
+			Assets:      []immich.DuplicateAsset{{ID: id + "-a"}, {ID: id + "-b"}},
+		})
 	}
+	duplicates = append(duplicates, immich.DuplicateGroup{DuplicateID: "single", Assets: []immich.DuplicateAsset{{ID: "x"}}})
 
-	albums, err := getAlbumsForAsset(config, "asset1")
-	if err != nil {
-		t.Errorf("getAlbumsForAsset() error = %v", err)
-		return
+	config := &Config{
+		ImmichURL:   "http://localhost:2283",
+		APIKey:      "test-key",
+		Concurrency: 3,
 	}
+	checkpoint := &Checkpoint{completed: map[string]bool{"dup5": true}}
 
-	if len(albums) != 2 {
-		t.Errorf("getAlbumsForAsset() returned %d albums, want 2", len(albums))
-	}
+	stats := processGroups(config, checkpoint, duplicates)
 
-	if albums[0].AlbumName != "Vacation" {
-		t.Errorf("First album name = %s, want Vacation", albums[0].AlbumName)
+	if stats.Groups != 5 || stats.Skipped != 1 || stats.Synced != 4 {
+		t.Errorf("processGroups() stats = %+v, want 5 groups, 1 skipped, 4 synced", *stats)
 	}
-}
-
-// TestGetAssetDetails tests the getAssetDetails function
-func TestGetAssetDetails(t *testing.T) {
-	mockAsset := &AssetDetails{
-		ID:               "asset1",
-		OriginalFileName: "photo.jpg",
-		FileCreatedAt:    time.Now(),
-		ExifInfo: &ExifInfo{
-			FileSizeInByte: 1500000,
-			ImageWidth:     1920,
-			ImageHeight:    1080,
-		},
+	if added != 4 {
+		t.Errorf("processGroups() sent %d album additions, want 4", added)
 	}
+}
 
-	assetJSON, _ := json.Marshal(mockAsset)
-
+// TestProcessGroupsCheckpoint tests that groups are checked and marked done
+// in a checkpoint file while other groups are processed. Run with -race.
+func TestProcessGroupsCheckpoint(t *testing.T) {
 	oldClient := httpClient
 	defer func() { httpClient = oldClient }()
 
 	httpClient = &MockHTTPClient{
 		DoFunc: func(req *http.Request) (*http.Response, error) {
-			return &http.Response{
-				StatusCode: http.StatusOK,
-				Body:       io.NopCloser(bytes.NewBuffer(assetJSON)),
-			}, nil
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(`[]`))}, nil
 		},
 	}
 
-	config := &Config{
-		ImmichURL: "http://localhost:2283",
-		APIKey:    "test-key",
+	duplicates := []immich.DuplicateGroup{}
+	for i := 1; i <= 20; i++ {
+		id := fmt.Sprintf("dup%d", i)
+		duplicates = append(duplicates, immich.DuplicateGroup{
+			DuplicateID: id,
+			Assets:      []immich.DuplicateAsset{{ID: id + "-a"}, {ID: id + "-b"}},
+		})
 	}
 
-	details, err := getAssetDetails(config, "asset1")
-	if err != nil {
-		t.Errorf("getAssetDetails() error = %v", err)
-		return
-	}
+	for _, concurrency := range []int{1, 4} {
+		t.Run(fmt.Sprintf("concurrency %d", concurrency), func(t *testing.T) {
+			config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", Concurrency: concurrency}
+			path := filepath.Join(t.TempDir(), "checkpoint.jsonl")
 
-	if details.ID != "asset1" {
-		t.Errorf("Asset ID = %s, want asset1", details.ID)
-	}
+			checkpoint, err := openCheckpoint(path, checkpointHeader(config), false)
+			if err != nil {
+				t.Fatalf("openCheckpoint() error = %v", err)
+			}
+			if stats := processGroups(config, checkpoint, duplicates[:10]); stats.Groups != 10 {
+				t.Errorf("first run processed %d group(s), want 10", stats.Groups)
+			}
+			if err := checkpoint.Close(); err != nil {
+				t.Fatal(err)
+			}
 
-	if details.ExifInfo.FileSizeInByte != 1500000 {
-		t.Errorf("File size = %d, want 1500000", details.ExifInfo.FileSizeInByte)
+			checkpoint, err = openCheckpoint(path, checkpointHeader(config), true)
+			if err != nil {
+				t.Fatalf("openCheckpoint() resume error = %v", err)
+			}
+			defer checkpoint.Close()
+			if stats := processGroups(config, checkpoint, duplicates); stats.Groups != 10 || stats.Skipped != 10 {
+				t.Errorf("resumed run stats = %+v, want 10 groups and 10 skipped", *stats)
+			}
+		})
 	}
 }
 
-// TestAddAssetsToAlbum tests the addAssetsToAlbum function
-func TestAddAssetsToAlbum(t *testing.T) {
+// TestSynchronizeAlbumsResults tests that the per-asset results of an album
+// addition drive the sync count and the journal
+func TestSynchronizeAlbumsResults(t *testing.T) {
 	oldClient := httpClient
 	defer func() { httpClient = oldClient }()
 
-	var capturedRequest *http.Request
-
 	httpClient = &MockHTTPClient{
 		DoFunc: func(req *http.Request) (*http.Response, error) {
-			capturedRequest = req
+			body := `[]`
+			switch {
+			case req.Method == "GET" && req.URL.Query().Get("assetId") == "asset1":
+				body = `[{"id": "album1", "albumName": "Vacation"}]`
+			case req.Method == "PUT":
+				body = `[
+					{"id": "asset2", "success": true},
+					{"id": "asset3", "success": false, "error": "no_permission"},
+					{"id": "asset4", "success": false, "error": "duplicate"}
+				]`
+			}
 			return &http.Response{
 				StatusCode: http.StatusOK,
-				Body:       io.NopCloser(bytes.NewBufferString(`{"success": true}`)),
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
 			}, nil
 		},
 	}
 
-	config := &Config{
-		ImmichURL: "http://localhost:2283",
-		APIKey:    "test-key",
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := openJournal(journalPath, "run1", "http://localhost:2283")
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", journal: journal}
+	group := immich.DuplicateGroup{
+		DuplicateID: "dup1",
+		Assets:      []immich.DuplicateAsset{{ID: "asset1"}, {ID: "asset2"}, {ID: "asset3"}, {ID: "asset4"}},
 	}
 
-	assetIDs := []string{"asset1", "asset2", "asset3"}
-	err := addAssetsToAlbum(config, "album1", assetIDs)
-
+	syncCount, _, err := synchronizeAlbums(config, group)
 	if err != nil {
-		t.Errorf("addAssetsToAlbum() error = %v", err)
-		return
+		t.Fatalf("synchronizeAlbums() error = %v", err)
 	}
-
-	// Verify request method
-	if capturedRequest.Method != "PUT" {
-		t.Errorf("Request method = %s, want PUT", capturedRequest.Method)
+	if syncCount != 1 {
+		t.Errorf("synchronizeAlbums() syncCount = %d, want 1", syncCount)
 	}
 
-	// Verify request body
-	var body AddAssetsRequest
-	bodyBytes, _ := io.ReadAll(capturedRequest.Body)
-	json.Unmarshal(bodyBytes, &body)
-
-	if len(body.IDs) != 3 {
-		t.Errorf("Request body contains %d IDs, want 3", len(body.IDs))
+	if err := journal.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
 	}
-}
-
-// TestDeleteAsset tests the deleteAsset function
-func TestDeleteAsset(t *testing.T) {
-	tests := []struct {
-		name       string
-		statusCode int
-		wantErr    bool
-	}{
-		{"successful delete - 204", http.StatusNoContent, false},
-		{"successful delete - 200", http.StatusOK, false},
-		{"failed delete - 404", http.StatusNotFound, true},
-		{"failed delete - 500", http.StatusInternalServerError, true},
+	entries, err := readJournal(journalPath, "run1")
+	if err != nil {
+		t.Fatalf("readJournal() error = %v", err)
 	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			oldClient := httpClient
-			defer func() { httpClient = oldClient }()
-
-			httpClient = &MockHTTPClient{
-				DoFunc: func(req *http.Request) (*http.Response, error) {
-					return &http.Response{
-						StatusCode: tt.statusCode,
-						Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
-					}, nil
-				},
-			}
-
-			config := &Config{
-				ImmichURL: "http://localhost:2283",
-				APIKey:    "test-key",
-			}
-
-			err := deleteAsset(config, "asset1")
-
-			if (err != nil) != tt.wantErr {
-				t.Errorf("deleteAsset() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
+	if len(entries) != 1 || strings.Join(entries[0].AssetIDs, ",") != "asset2" {
+		t.Errorf("journal entries = %+v, want a single addition of asset2", entries)
 	}
 }
 
@@ -454,12 +465,12 @@ func TestTruncateID(t *testing.T) {
 
 // BenchmarkSelectBestQualityAsset benchmarks the quality selection algorithm
 func BenchmarkSelectBestQualityAsset(b *testing.B) {
-	assets := map[string]*AssetDetails{
+	assets := map[string]*immich.AssetDetails{
 		"asset1": {
 			ID:               "asset1",
 			OriginalFileName: "IMG_1234.jpg",
 			FileCreatedAt:    time.Now(),
-			ExifInfo: &ExifInfo{
+			ExifInfo: &immich.ExifInfo{
 				FileSizeInByte: 1000000,
 			},
 		},
@@ -467,7 +478,7 @@ func BenchmarkSelectBestQualityAsset(b *testing.B) {
 			ID:               "asset2",
 			OriginalFileName: "photo.jpg",
 			FileCreatedAt:    time.Now(),
-			ExifInfo: &ExifInfo{
+			ExifInfo: &immich.ExifInfo{
 				FileSizeInByte: 2000000,
 			},
 		},
@@ -475,7 +486,7 @@ func BenchmarkSelectBestQualityAsset(b *testing.B) {
 			ID:               "asset3",
 			OriginalFileName: "DSC_5678.jpg",
 			FileCreatedAt:    time.Now(),
-			ExifInfo: &ExifInfo{
+			ExifInfo: &immich.ExifInfo{
 				FileSizeInByte: 1500000,
 			},
 		},
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// MetadataMerge describes the changes needed to carry the metadata of the
+// deleted duplicates over to the kept asset.
+//
+// Conflict policy:
+//   - Favorite: the keeper becomes a favorite if any duplicate is a favorite
+//   - Rating: the highest rating of the group wins
+//   - Description: the keeper's description is kept if set, otherwise the
+//     distinct descriptions of the duplicates are joined, one per line
+//   - Archived: the keeper is archived only if every duplicate is archived,
+//     so merging never hides a photo that was visible in the timeline
+//   - Tags: the keeper receives the union of all tags
+type MetadataMerge struct {
+	Update  immich.UpdateAssetRequest `json:"update"`           // Fields to update on the keeper
+	TagIDs  []string                  `json:"tagIds,omitempty"` // Tags to add to the keeper
+	Changes []string                  `json:"changes"`          // Human-readable description of each change
+	Sources []MergeSource             `json:"sources"`          // Metadata of the group the merge was computed from
+}
+
+// MergeSource records the metadata of an asset when a merge was planned, so
+// that a plan can be refused if it changed since
+type MergeSource struct {
+	AssetID     string   `json:"assetId"`
+	Favorite    bool     `json:"favorite"`
+	Archived    bool     `json:"archived"`
+	Rating      int      `json:"rating"`
+	Description string   `json:"description,omitempty"`
+	TagIDs      []string `json:"tagIds,omitempty"` // Sorted
+}
+
+// mergeSource returns the metadata of an asset that a merge depends on
+func mergeSource(details *immich.AssetDetails) MergeSource {
+	source := MergeSource{
+		AssetID:     details.ID,
+		Favorite:    details.IsFavorite,
+		Archived:    details.IsArchived,
+		Rating:      rating(details),
+		Description: description(details),
+	}
+	for _, tag := range details.Tags {
+		source.TagIDs = append(source.TagIDs, tag.ID)
+	}
+	sort.Strings(source.TagIDs)
+	return source
+}
+
+// changedFields names the metadata that differs between two sources
+func (s MergeSource) changedFields(other MergeSource) []string {
+	fields := []string{}
+	if s.Favorite != other.Favorite {
+		fields = append(fields, "favorite")
+	}
+	if s.Archived != other.Archived {
+		fields = append(fields, "archived")
+	}
+	if s.Rating != other.Rating {
+		fields = append(fields, "rating")
+	}
+	if s.Description != other.Description {
+		fields = append(fields, "description")
+	}
+	if strings.Join(s.TagIDs, ",") != strings.Join(other.TagIDs, ",") {
+		fields = append(fields, "tags")
+	}
+	return fields
+}
+
+// IsEmpty reports whether the merge changes nothing
+func (m *MetadataMerge) IsEmpty() bool {
+	return len(m.Changes) == 0
+}
+
+// splitChanges returns the changes made by the update of the keeper and the
+// change made by each tag of TagIDs. The changes of the tags come last, in
+// TagIDs order; a plan edited by hand falls back to naming the tag.
+func (m *MetadataMerge) splitChanges() (updateChanges, tagChanges []string) {
+	split := len(m.Changes) - len(m.TagIDs)
+	if split < 0 {
+		split = len(m.Changes)
+	}
+	updateChanges = m.Changes[:split]
+	for i, tagID := range m.TagIDs {
+		if split+i < len(m.Changes) {
+			tagChanges = append(tagChanges, m.Changes[split+i])
+		} else {
+			tagChanges = append(tagChanges, fmt.Sprintf("tag: added %q", truncateID(tagID)))
+		}
+	}
+	return updateChanges, tagChanges
+}
+
+// previousValues returns the values the keeper had, when the merge was
+// planned, for the fields the merge updates, or nil if they were not recorded
+func (m *MetadataMerge) previousValues(keeperID string) *immich.UpdateAssetRequest {
+	for _, source := range m.Sources {
+		if source.AssetID != keeperID {
+			continue
+		}
+		previous := &immich.UpdateAssetRequest{}
+		if m.Update.IsFavorite != nil {
+			previous.IsFavorite = &source.Favorite
+		}
+		if m.Update.IsArchived != nil {
+			previous.IsArchived = &source.Archived
+		}
+		if m.Update.Rating != nil {
+			previous.Rating = &source.Rating
+		}
+		if m.Update.Description != nil {
+			previous.Description = &source.Description
+		}
+		return previous
+	}
+	return nil
+}
+
+// planMetadataMerge reconciles the metadata of the losers onto the keeper
+func planMetadataMerge(keeper *immich.AssetDetails, losers []*immich.AssetDetails) *MetadataMerge {
+	merge := &MetadataMerge{Sources: []MergeSource{mergeSource(keeper)}}
+	for _, loser := range losers {
+		merge.Sources = append(merge.Sources, mergeSource(loser))
+	}
+
+	// Favorite: union
+	if !keeper.IsFavorite {
+		for _, loser := range losers {
+			if loser.IsFavorite {
+				favorite := true
+				merge.Update.IsFavorite = &favorite
+				merge.Changes = append(merge.Changes, fmt.Sprintf("favorite: false → true (from %s)", truncateID(loser.ID)))
+				break
+			}
+		}
+	}
+
+	// Rating: highest wins
+	keeperRating := rating(keeper)
+	bestRating, bestFrom := keeperRating, ""
+	for _, loser := range losers {
+		if r := rating(loser); r > bestRating {
+			bestRating, bestFrom = r, loser.ID
+		}
+	}
+	if bestFrom != "" {
+		merge.Update.Rating = &bestRating
+		merge.Changes = append(merge.Changes, fmt.Sprintf("rating: %d → %d (from %s)", keeperRating, bestRating, truncateID(bestFrom)))
+	}
+
+	// Description: keeper wins, otherwise join the distinct loser descriptions
+	if description(keeper) == "" {
+		seen := make(map[string]bool)
+		descriptions := []string{}
+		for _, loser := range losers {
+			if d := description(loser); d != "" && !seen[d] {
+				seen[d] = true
+				descriptions = append(descriptions, d)
+			}
+		}
+		if len(descriptions) > 0 {
+			merged := strings.Join(descriptions, "\n")
+			merge.Update.Description = &merged
+			merge.Changes = append(merge.Changes, fmt.Sprintf("description: set to %q", merged))
+		}
+	}
+
+	// Archived: only if every duplicate is archived
+	if !keeper.IsArchived && len(losers) > 0 {
+		allArchived := true
+		for _, loser := range losers {
+			if !loser.IsArchived {
+				allArchived = false
+				break
+			}
+		}
+		if allArchived {
+			archived := true
+			merge.Update.IsArchived = &archived
+			merge.Changes = append(merge.Changes, "archived: false → true (all duplicates were archived)")
+		}
+	}
+
+	// Tags: union
+	keeperTags := make(map[string]bool, len(keeper.Tags))
+	for _, tag := range keeper.Tags {
+		keeperTags[tag.ID] = true
+	}
+	for _, loser := range losers {
+		for _, tag := range loser.Tags {
+			if keeperTags[tag.ID] {
+				continue
+			}
+			keeperTags[tag.ID] = true
+			merge.TagIDs = append(merge.TagIDs, tag.ID)
+			merge.Changes = append(merge.Changes, fmt.Sprintf("tag: added %q (from %s)", tagName(tag), truncateID(loser.ID)))
+		}
+	}
+
+	return merge
+}
+
+// mergeMetadata copies the metadata of the other assets of a selection onto
+// the keeper, following the conflict policy of MetadataMerge
+func mergeMetadata(config *Config, selection *Selection) error {
+	keeper := selection.Details[selection.KeeperID]
+	losers := make([]*immich.AssetDetails, 0, len(selection.Others))
+	for _, id := range selection.Others {
+		losers = append(losers, selection.Details[id])
+	}
+
+	merge := planMetadataMerge(keeper, losers)
+	if merge.IsEmpty() {
+		if config.Verbose {
+			config.logInfo("✓ No metadata to merge into %s", truncateID(keeper.ID))
+		}
+		return nil
+	}
+
+	if config.DryRun {
+		for _, change := range merge.Changes {
+			config.logInfo("   [DRY RUN] Would merge into %s: %s", truncateID(keeper.ID), change)
+		}
+		return nil
+	}
+
+	return applyMetadataMerge(config, keeper.ID, merge)
+}
+
+// applyMetadataMerge updates and tags the keeper as planned by merge, and
+// logs and journals each change once the server has made it
+func applyMetadataMerge(config *Config, keeperID string, merge *MetadataMerge) error {
+	updateChanges, tagChanges := merge.splitChanges()
+	if merge.Update != (immich.UpdateAssetRequest{}) {
+		if err := config.api().UpdateAsset(config.context(), keeperID, merge.Update); err != nil {
+			return fmt.Errorf("failed to update asset %s: %w", truncateID(keeperID), err)
+		}
+		update := merge.Update
+		config.journal.Record(JournalEntry{Action: actionMetadataUpdate, AssetIDs: []string{keeperID}, Update: &update, Previous: merge.previousValues(keeperID)})
+		for _, change := range updateChanges {
+			config.logInfo("🔀 Merged into %s: %s", truncateID(keeperID), change)
+		}
+	}
+	for i, tagID := range merge.TagIDs {
+		if err := config.api().TagAssets(config.context(), tagID, []string{keeperID}); err != nil {
+			return fmt.Errorf("failed to tag asset %s: %w", truncateID(keeperID), err)
+		}
+		config.journal.Record(JournalEntry{Action: actionTag, TagID: tagID, AssetIDs: []string{keeperID}})
+		config.logInfo("🔀 Merged into %s: %s", truncateID(keeperID), tagChanges[i])
+	}
+
+	return nil
+}
+
+// tagName returns the full path of a tag, falling back to its name
+func tagName(tag immich.Tag) string {
+	if tag.Value != "" {
+		return tag.Value
+	}
+	return tag.Name
+}
+
+func rating(details *immich.AssetDetails) int {
+	if details.ExifInfo == nil || details.ExifInfo.Rating == nil {
+		return 0
+	}
+	return *details.ExifInfo.Rating
+}
+
+func description(details *immich.AssetDetails) string {
+	if details.ExifInfo == nil {
+		return ""
+	}
+	return strings.TrimSpace(details.ExifInfo.Description)
+}
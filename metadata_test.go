@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// TestPlanMetadataMerge tests the metadata conflict policy
+func TestPlanMetadataMerge(t *testing.T) {
+	three, five := 3, 5
+
+	tests := []struct {
+		name        string
+		keeper      *immich.AssetDetails
+		losers      []*immich.AssetDetails
+		wantChanges int
+		check       func(t *testing.T, merge *MetadataMerge)
+	}{
+		{
+			name:   "nothing to merge",
+			keeper: &immich.AssetDetails{ID: "keeper", IsFavorite: true},
+			losers: []*immich.AssetDetails{{ID: "loser"}},
+		},
+		{
+			name:        "favorite is a union",
+			keeper:      &immich.AssetDetails{ID: "keeper"},
+			losers:      []*immich.AssetDetails{{ID: "loser1"}, {ID: "loser2", IsFavorite: true}},
+			wantChanges: 1,
+			check: func(t *testing.T, merge *MetadataMerge) {
+				if merge.Update.IsFavorite == nil || !*merge.Update.IsFavorite {
+					t.Error("keeper should become a favorite")
+				}
+			},
+		},
+		{
+			name:        "highest rating wins",
+			keeper:      &immich.AssetDetails{ID: "keeper", ExifInfo: &immich.ExifInfo{Rating: &three}},
+			losers:      []*immich.AssetDetails{{ID: "loser", ExifInfo: &immich.ExifInfo{Rating: &five}}},
+			wantChanges: 1,
+			check: func(t *testing.T, merge *MetadataMerge) {
+				if merge.Update.Rating == nil || *merge.Update.Rating != 5 {
+					t.Errorf("rating = %v, want 5", merge.Update.Rating)
+				}
+			},
+		},
+		{
+			name:   "keeper description wins",
+			keeper: &immich.AssetDetails{ID: "keeper", ExifInfo: &immich.ExifInfo{Description: "Kept"}},
+			losers: []*immich.AssetDetails{{ID: "loser", ExifInfo: &immich.ExifInfo{Description: "Lost"}}},
+		},
+		{
+			name:   "distinct loser descriptions are joined",
+			keeper: &immich.AssetDetails{ID: "keeper"},
+			losers: []*immich.AssetDetails{
+				{ID: "loser1", ExifInfo: &immich.ExifInfo{Description: "Beach"}},
+				{ID: "loser2", ExifInfo: &immich.ExifInfo{Description: "Beach"}},
+				{ID: "loser3", ExifInfo: &immich.ExifInfo{Description: "Sunset"}},
+			},
+			wantChanges: 1,
+			check: func(t *testing.T, merge *MetadataMerge) {
+				if merge.Update.Description == nil || *merge.Update.Description != "Beach\nSunset" {
+					t.Errorf("description = %v, want Beach\\nSunset", merge.Update.Description)
+				}
+			},
+		},
+		{
+			name:   "archived only when all duplicates are archived",
+			keeper: &immich.AssetDetails{ID: "keeper"},
+			losers: []*immich.AssetDetails{{ID: "loser1", IsArchived: true}, {ID: "loser2"}},
+		},
+		{
+			name:        "all archived",
+			keeper:      &immich.AssetDetails{ID: "keeper"},
+			losers:      []*immich.AssetDetails{{ID: "loser1", IsArchived: true}, {ID: "loser2", IsArchived: true}},
+			wantChanges: 1,
+		},
+		{
+			name:   "tags are a union",
+			keeper: &immich.AssetDetails{ID: "keeper", Tags: []immich.Tag{{ID: "t1", Name: "Family"}}},
+			losers: []*immich.AssetDetails{
+				{ID: "loser1", Tags: []immich.Tag{{ID: "t1", Name: "Family"}, {ID: "t2", Name: "Trip", Value: "Travel/Trip"}}},
+				{ID: "loser2", Tags: []immich.Tag{{ID: "t2", Name: "Trip", Value: "Travel/Trip"}}},
+			},
+			wantChanges: 1,
+			check: func(t *testing.T, merge *MetadataMerge) {
+				if len(merge.TagIDs) != 1 || merge.TagIDs[0] != "t2" {
+					t.Errorf("tags = %v, want [t2]", merge.TagIDs)
+				}
+				if !strings.Contains(merge.Changes[0], "Travel/Trip") {
+					t.Errorf("change %q should name the tag path", merge.Changes[0])
+				}
+				if len(merge.Sources) != 3 || !reflect.DeepEqual(merge.Sources[1].TagIDs, []string{"t1", "t2"}) {
+					t.Errorf("sources = %+v, want the keeper and both losers with their tags", merge.Sources)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merge := planMetadataMerge(tt.keeper, tt.losers)
+			if len(merge.Changes) != tt.wantChanges {
+				t.Errorf("planMetadataMerge() made %d change(s), want %d: %v", len(merge.Changes), tt.wantChanges, merge.Changes)
+			}
+			if tt.check != nil {
+				tt.check(t, merge)
+			}
+		})
+	}
+}
+
+// TestMergeMetadata tests that the merge is applied to the keeper
+func TestMergeMetadata(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	var paths []string
+	var update immich.UpdateAssetRequest
+
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			paths = append(paths, req.Method+" "+req.URL.Path)
+			if strings.HasPrefix(req.URL.Path, "/api/assets") {
+				bodyBytes, _ := io.ReadAll(req.Body)
+				json.Unmarshal(bodyBytes, &update)
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
+			}, nil
+		},
+	}
+
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := openJournal(journalPath, "run1", "http://localhost:2283")
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	config := &Config{
+		ImmichURL: "http://localhost:2283",
+		APIKey:    "test-key",
+		journal:   journal,
+	}
+
+	selection := &Selection{
+		KeeperID: "keeper",
+		Others:   []string{"loser"},
+		Details: map[string]*immich.AssetDetails{
+			"keeper": {ID: "keeper"},
+			"loser":  {ID: "loser", IsFavorite: true, Tags: []immich.Tag{{ID: "t1", Name: "Trip"}}},
+		},
+	}
+
+	if err := mergeMetadata(config, selection); err != nil {
+		t.Fatalf("mergeMetadata() error = %v", err)
+	}
+
+	want := []string{"PUT /api/assets/keeper", "PUT /api/tags/t1/assets"}
+	if strings.Join(paths, ",") != strings.Join(want, ",") {
+		t.Errorf("requests = %v, want %v", paths, want)
+	}
+	if update.IsFavorite == nil || !*update.IsFavorite {
+		t.Error("update should mark the keeper as favorite")
+	}
+	if update.Rating != nil || update.Description != nil || update.IsArchived != nil {
+		t.Errorf("update should only set isFavorite, got %+v", update)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readJournal(journalPath, "run1")
+	if err != nil {
+		t.Fatalf("readJournal() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != actionMetadataUpdate || entries[1].Action != actionTag || entries[1].TagID != "t1" {
+		t.Errorf("journal = %+v, want a metadata update and a tag entry", entries)
+	}
+	if previous := entries[0].Previous; previous == nil || previous.IsFavorite == nil || *previous.IsFavorite || previous.Rating != nil {
+		t.Errorf("journaled previous values = %+v, want only isFavorite false", previous)
+	}
+
+	// Dry run must not send any request
+	paths = nil
+	config.DryRun = true
+	if err := mergeMetadata(config, selection); err != nil {
+		t.Fatalf("mergeMetadata() dry run error = %v", err)
+	}
+	if len(paths) != 0 {
+		t.Errorf("dry run sent %d request(s), want 0", len(paths))
+	}
+}
+
+// TestMergeMetadataFailure tests that a change the server refused is not
+// logged as merged
+func TestMergeMetadataFailure(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			status := http.StatusOK
+			if strings.HasPrefix(req.URL.Path, "/api/tags") {
+				status = http.StatusForbidden
+			}
+			return &http.Response{
+				StatusCode: status,
+				Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
+			}, nil
+		},
+	}
+
+	var logs bytes.Buffer
+	logOutput := log.Writer()
+	log.SetOutput(&logs)
+	defer log.SetOutput(logOutput)
+
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key"}
+	selection := &Selection{
+		KeeperID: "keeper",
+		Others:   []string{"loser"},
+		Details: map[string]*immich.AssetDetails{
+			"keeper": {ID: "keeper"},
+			"loser":  {ID: "loser", IsFavorite: true, Tags: []immich.Tag{{ID: "t1", Name: "Trip"}}},
+		},
+	}
+	if err := mergeMetadata(config, selection); err == nil {
+		t.Fatal("mergeMetadata() should fail when tagging fails")
+	}
+
+	if !strings.Contains(logs.String(), "Merged into keeper: favorite") {
+		t.Errorf("the accepted update should be logged, got:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), "Merged into keeper: tag") {
+		t.Errorf("the refused tag should not be logged as merged, got:\n%s", logs.String())
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// runNotDuplicates marks the duplicate groups listed by ID as not duplicates
+// on the server, so that they stop being reported. IDs are taken from the
+// command line and from config.IDsFile.
+func runNotDuplicates(config *Config, ids []string) error {
+	if config.IDsFile == "-" && !config.Yes && !config.DryRun {
+		// The confirmation would be read from the exhausted standard input
+		return fmt.Errorf("--ids-file - reads the standard input, so the confirmation cannot be asked; pass --yes to mark the groups without asking")
+	}
+	if config.IDsFile != "" {
+		fileIDs, err := readIDsFile(config.IDsFile)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, fileIDs...)
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("no duplicate group IDs given")
+	}
+
+	logInfo("🔍 Fetching duplicate groups...")
+	duplicates, err := config.api().GetDuplicates(config.context())
+	if err != nil {
+		return fmt.Errorf("failed to fetch duplicates: %w", err)
+	}
+	byID := make(map[string]immich.DuplicateGroup, len(duplicates))
+	for _, group := range duplicates {
+		byID[group.DuplicateID] = group
+	}
+
+	groups := []immich.DuplicateGroup{}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		group, ok := byID[id]
+		if !ok {
+			logWarning("⚠️  Group %s is not reported as duplicates by the server", id)
+			continue
+		}
+		groups = append(groups, group)
+	}
+	if len(groups) == 0 {
+		return fmt.Errorf("none of the %d given group(s) are reported as duplicates", len(seen))
+	}
+
+	assets := 0
+	for _, group := range groups {
+		assets += len(group.Assets)
+	}
+	logInfo("✅ %d group(s) with %d asset(s) to mark as not duplicates", len(groups), assets)
+
+	if !config.Yes && !config.DryRun {
+		response, err := config.prompt(fmt.Sprintf("\n⚠️  Mark %d group(s) as not duplicates? [y/N]: ", len(groups)))
+		if err != nil || (!strings.EqualFold(response, "y") && !strings.EqualFold(response, "yes")) {
+			logInfo("❌ Cancelled")
+			return nil
+		}
+	}
+
+	stats := &Stats{}
+	for i, group := range groups {
+		logInfo("\n📁 Group %d/%d (%s, %d assets)", i+1, len(groups), truncateID(group.DuplicateID), len(group.Assets))
+		if err := markNotDuplicates(config, group); err != nil {
+			logError("Failed to process group %d: %v", i+1, err)
+			stats.Failed++
+			continue
+		}
+		stats.Groups++
+		stats.NotDuplicates++
+	}
+
+	logInfo("\n📊 Summary: %d group(s) marked as not duplicates, %d failed", stats.NotDuplicates, stats.Failed)
+	if config.RunID != "" && !config.DryRun {
+		logInfo("↩️  To revert this run: %s undo %s --url %s --api-key YOUR_KEY", os.Args[0], config.RunID, config.ImmichURL)
+	}
+	if stats.Failed > 0 {
+		return fmt.Errorf("%d group(s) could not be marked", stats.Failed)
+	}
+	return nil
+}
+
+// readIDsFile reads one ID per line from path, or from the standard input if
+// path is "-". Blank lines and lines starting with # are ignored.
+func readIDsFile(path string) (ids []string, err error) {
+	var input io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open IDs file: %w", err)
+		}
+		defer func() {
+			if closeErr := file.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("failed to close IDs file: %w", closeErr)
+			}
+		}()
+		input = file
+	}
+
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read IDs file: %w", err)
+	}
+	return ids, nil
+}
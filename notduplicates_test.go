@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// TestRunNotDuplicates tests clearing the duplicate status of listed groups
+func TestRunNotDuplicates(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	var cleared [][]string
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := `[]`
+			switch {
+			case req.Method == "GET" && req.URL.Path == "/api/duplicates":
+				body = `[{"duplicateId": "dup1", "assets": [{"id": "a1"}, {"id": "a2"}]}, {"duplicateId": "dup2", "assets": [{"id": "b1"}, {"id": "b2"}]}]`
+			case req.Method == "PUT" && req.URL.Path == "/api/assets":
+				var update immich.UpdateAssetsRequest
+				if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+					t.Errorf("failed to decode update: %v", err)
+				}
+				if update.DuplicateID != nil {
+					t.Errorf("update sets duplicate ID %q, want null", *update.DuplicateID)
+				}
+				cleared = append(cleared, update.IDs)
+				return &http.Response{StatusCode: http.StatusNoContent, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+			default:
+				t.Errorf("Unexpected request %s %s", req.Method, req.URL)
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		},
+	}
+
+	dir := t.TempDir()
+	idsFile := filepath.Join(dir, "ids.txt")
+	if err := os.WriteFile(idsFile, []byte("# false positives\ndup2\n\nunknown\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	journalPath := filepath.Join(dir, "journal.jsonl")
+	journal, err := openJournal(journalPath, "run1", "http://localhost:2283")
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", Yes: true, IDsFile: idsFile, journal: journal}
+	if err := runNotDuplicates(config, []string{"dup1", "dup2"}); err != nil {
+		t.Fatalf("runNotDuplicates() error = %v", err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{{"a1", "a2"}, {"b1", "b2"}}
+	if !reflect.DeepEqual(cleared, want) {
+		t.Errorf("cleared = %v, want %v", cleared, want)
+	}
+
+	entries, err := readJournal(journalPath, "run1")
+	if err != nil {
+		t.Fatalf("readJournal() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].Action != actionDuplicateClear || entries[0].DuplicateID != "dup1" {
+		t.Errorf("journal = %+v, want 2 duplicate_clear entries starting with dup1", entries)
+	}
+
+	// Nothing is changed when no listed group is reported
+	cleared = nil
+	config.IDsFile = ""
+	if err := runNotDuplicates(config, []string{"unknown"}); err == nil {
+		t.Error("runNotDuplicates() with unknown groups should fail")
+	}
+	if len(cleared) != 0 {
+		t.Errorf("cleared = %v, want nothing", cleared)
+	}
+}
+
+// TestRunNotDuplicatesStdin tests reading IDs from the standard input, which
+// leaves no input for the confirmation prompt
+func TestRunNotDuplicatesStdin(t *testing.T) {
+	oldClient, oldStdin := httpClient, os.Stdin
+	defer func() { httpClient, os.Stdin = oldClient, oldStdin }()
+
+	var cleared [][]string
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if req.Method == "PUT" {
+				var update immich.UpdateAssetsRequest
+				if err := json.NewDecoder(req.Body).Decode(&update); err != nil {
+					t.Errorf("failed to decode update: %v", err)
+				}
+				cleared = append(cleared, update.IDs)
+				return &http.Response{StatusCode: http.StatusNoContent, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+			}
+			body := `[{"duplicateId": "dup1", "assets": [{"id": "a1"}, {"id": "a2"}]}]`
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		},
+	}
+
+	stdin := filepath.Join(t.TempDir(), "stdin")
+	if err := os.WriteFile(stdin, []byte("dup1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, yes := range []bool{false, true} {
+		file, err := os.Open(stdin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		os.Stdin = file
+
+		config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", IDsFile: "-", Yes: yes}
+		err = runNotDuplicates(config, nil)
+		switch {
+		case !yes && (err == nil || !strings.Contains(err.Error(), "pass --yes")):
+			t.Errorf("runNotDuplicates() without --yes error = %v, want a request for --yes", err)
+		case !yes && len(cleared) != 0:
+			t.Errorf("runNotDuplicates() without --yes cleared %v", cleared)
+		case yes && err != nil:
+			t.Errorf("runNotDuplicates() with --yes error = %v", err)
+		}
+	}
+	if want := [][]string{{"a1", "a2"}}; !reflect.DeepEqual(cleared, want) {
+		t.Errorf("cleared = %v, want %v", cleared, want)
+	}
+}
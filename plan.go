@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+const (
+	// Version of the plan file format written by the plan command. Version 2
+	// records the metadata that merges were computed from.
+	planVersion = 2
+)
+
+// errDrift is returned when the server no longer matches a plan
+var errDrift = errors.New("server state has drifted since the plan was made")
+
+// Plan lists every change a run would make, so that it can be reviewed and
+// later applied exactly as written
+type Plan struct {
+	Version   int         `json:"version"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Server    string      `json:"server"`
+	Mode      string      `json:"mode"`
+	Policy    string      `json:"policy"`
+	Permanent bool        `json:"permanent,omitempty"`
+	Groups    []GroupPlan `json:"groups"`
+}
+
+// GroupPlan lists the changes planned for a duplicate group
+type GroupPlan struct {
+	DuplicateID    string         `json:"duplicateId"`
+	Assets         []string       `json:"assets"` // Assets of the group when the plan was made
+	AlbumAdditions []PlanAddition `json:"albumAdditions,omitempty"`
+	Keeper         string         `json:"keeper,omitempty"`
+	Merge          *MetadataMerge `json:"merge,omitempty"`  // Metadata carried over to the keeper
+	Delete         []string       `json:"delete,omitempty"` // Assets to delete
+	Stack          []string       `json:"stack,omitempty"`  // Assets to stack, primary asset first
+}
+
+// PlanAddition is a planned addition of assets to an album
+type PlanAddition struct {
+	AlbumID   string   `json:"albumId"`
+	AlbumName string   `json:"albumName"`
+	AssetIDs  []string `json:"assetIds"`
+}
+
+// runPlan writes the plan of a run with the current flags to path
+func runPlan(config *Config, path string) {
+	plan, err := buildPlan(config)
+	if err != nil {
+		log.Fatalf("Planning failed: %v", err)
+	}
+	if err := writePlan(path, plan); err != nil {
+		log.Fatalf("Planning failed: %v", err)
+	}
+
+	additions, deletions, stacks := plan.counts()
+	logInfo("\n📝 Plan written to %s: %d album addition(s), %d deletion(s), %d stack(s) in %d group(s)",
+		path, additions, deletions, stacks, len(plan.Groups))
+	logInfo("💡 Review it, then run: %s apply %s --url %s --api-key YOUR_KEY", os.Args[0], path, config.ImmichURL)
+}
+
+// runApply applies the plan stored at path
+func runApply(config *Config, path string) {
+	plan, err := readPlan(path)
+	if err != nil {
+		log.Fatalf("Apply failed: %v", err)
+	}
+
+	closeJournal, err := startJournal(config)
+	if err != nil {
+		log.Fatalf("Apply failed: %v", err)
+	}
+	defer closeJournal()
+
+	stats, err := applyPlan(config, plan)
+	if err != nil {
+		closeJournal()
+		log.Fatalf("Apply failed: %v", err)
+	}
+
+	logInfo("\n🎉 Plan applied!")
+	printSummary(config, stats)
+	if config.RunID != "" {
+		logInfo("↩️  To revert this run: %s undo %s --url %s --api-key YOUR_KEY", os.Args[0], config.RunID, config.ImmichURL)
+	}
+}
+
+// buildPlan resolves every duplicate group as a run with the same flags
+// would, without changing anything on the server
+func buildPlan(config *Config) (*Plan, error) {
+	logInfo("🔍 Fetching duplicate groups...")
+	duplicates, err := config.api().GetDuplicates(config.context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch duplicates: %w", err)
+	}
+	logInfo("✅ Found %d duplicate group(s)", len(duplicates))
+
+	plan := &Plan{
+		Version:   planVersion,
+		CreatedAt: time.Now().UTC(),
+		Server:    config.ImmichURL,
+		Mode:      runMode(config),
+		Policy:    policyName(config),
+		Permanent: config.Permanent,
+		Groups:    []GroupPlan{},
+	}
+
+	for i, group := range duplicates {
+		if len(group.Assets) < 2 {
+			continue
+		}
+		logInfo("\n📁 Planning group %d/%d (%d assets)", i+1, len(duplicates), len(group.Assets))
+
+		groupPlan, err := planGroup(config, group)
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %w", i+1, err)
+		}
+		plan.Groups = append(plan.Groups, *groupPlan)
+	}
+
+	return plan, nil
+}
+
+// planGroup plans the album additions and the resolution of a group
+func planGroup(config *Config, group immich.DuplicateGroup) (*GroupPlan, error) {
+	groupPlan := &GroupPlan{DuplicateID: group.DuplicateID}
+	for _, asset := range group.Assets {
+		groupPlan.Assets = append(groupPlan.Assets, asset.ID)
+	}
+
+	assetAlbums := fetchAssetAlbums(config, group)
+	albumNames := make(map[string]string)
+	for _, albums := range assetAlbums {
+		for _, album := range albums {
+			albumNames[album.ID] = album.AlbumName
+		}
+	}
+	for albumID, assetIDs := range missingAlbumAssets(group, assetAlbums, config.ownerID) {
+		groupPlan.AlbumAdditions = append(groupPlan.AlbumAdditions, PlanAddition{
+			AlbumID:   albumID,
+			AlbumName: albumNames[albumID],
+			AssetIDs:  assetIDs,
+		})
+	}
+	sort.Slice(groupPlan.AlbumAdditions, func(i, j int) bool {
+		return groupPlan.AlbumAdditions[i].AlbumID < groupPlan.AlbumAdditions[j].AlbumID
+	})
+
+	if !config.AutoDelete && !config.Stack {
+		return groupPlan, nil
+	}
+
+	selection, err := selectKeeper(config, group, assetAlbums)
+	if err != nil || selection == nil {
+		return groupPlan, err
+	}
+	groupPlan.Keeper = selection.KeeperID
+
+	switch {
+	case config.AutoDelete:
+		groupPlan.Delete = selection.Others
+		if config.Merge {
+			losers := make([]*immich.AssetDetails, 0, len(selection.Others))
+			for _, id := range selection.Others {
+				losers = append(losers, selection.Details[id])
+			}
+			if merge := planMetadataMerge(selection.Details[selection.KeeperID], losers); !merge.IsEmpty() {
+				groupPlan.Merge = merge
+			}
+		}
+	case config.Stack:
+		groupPlan.Stack = append([]string{selection.KeeperID}, selection.Others...)
+	}
+
+	return groupPlan, nil
+}
+
+// writePlan saves a plan as indented JSON
+func writePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write plan: %w", err)
+	}
+	return nil
+}
+
+// readPlan loads a plan and checks that its format is supported
+func readPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan: %w", err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("invalid plan %s: %w", path, err)
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("plan %s has version %d, this tool applies version %d", path, plan.Version, planVersion)
+	}
+
+	return &plan, nil
+}
+
+// permissions returns the API key permissions the deletions, stacks and
+// metadata merges of the plan need
+func (p *Plan) permissions() []string {
+	var deletes, stacks, updates, tags bool
+	for _, group := range p.Groups {
+		deletes = deletes || len(group.Delete) > 0
+		stacks = stacks || len(group.Stack) > 0
+		if group.Merge != nil {
+			updates = updates || group.Merge.Update != (immich.UpdateAssetRequest{})
+			tags = tags || len(group.Merge.TagIDs) > 0
+		}
+	}
+
+	var permissions []string
+	if deletes {
+		permissions = append(permissions, "asset.delete")
+	}
+	if stacks {
+		permissions = append(permissions, "stack.create")
+	}
+	if updates {
+		permissions = append(permissions, "asset.update")
+	}
+	if tags {
+		permissions = append(permissions, "tag.asset")
+	}
+	return permissions
+}
+
+// checkDrift compares a plan with the current server state and returns a
+// description of every difference that makes the plan unsafe to apply
+func checkDrift(config *Config, plan *Plan) ([]string, error) {
+	duplicates, err := config.api().GetDuplicates(config.context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch duplicates: %w", err)
+	}
+	current := make(map[string][]string, len(duplicates))
+	for _, group := range duplicates {
+		ids := make([]string, len(group.Assets))
+		for i, asset := range group.Assets {
+			ids[i] = asset.ID
+		}
+		current[group.DuplicateID] = ids
+	}
+
+	albums, err := config.api().GetAlbums(config.context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch albums: %w", err)
+	}
+	albumExists := make(map[string]bool, len(albums))
+	for _, album := range albums {
+		albumExists[album.ID] = true
+	}
+
+	drift := []string{}
+	for _, group := range plan.Groups {
+		assets, ok := current[group.DuplicateID]
+		if !ok {
+			drift = append(drift, fmt.Sprintf("duplicate group %s no longer exists", truncateID(group.DuplicateID)))
+			continue
+		}
+		if !sameIDs(assets, group.Assets) {
+			drift = append(drift, fmt.Sprintf("duplicate group %s changed: planned %v, now %v",
+				truncateID(group.DuplicateID), truncateIDs(group.Assets), truncateIDs(assets)))
+			continue
+		}
+
+		for _, addition := range group.AlbumAdditions {
+			if !albumExists[addition.AlbumID] {
+				drift = append(drift, fmt.Sprintf("album %q (%s) no longer exists", addition.AlbumName, truncateID(addition.AlbumID)))
+			}
+		}
+		sources := make(map[string]MergeSource)
+		if group.Merge != nil {
+			for _, source := range group.Merge.Sources {
+				sources[source.AssetID] = source
+			}
+		}
+		for _, assetID := range group.Assets {
+			details, err := config.api().GetAssetDetails(config.context(), assetID)
+			switch {
+			case err != nil:
+				drift = append(drift, fmt.Sprintf("asset %s of group %s is no longer available: %v", truncateID(assetID), truncateID(group.DuplicateID), err))
+				continue
+			case details.IsTrashed:
+				drift = append(drift, fmt.Sprintf("asset %s of group %s is in the trash", truncateID(assetID), truncateID(group.DuplicateID)))
+				continue
+			}
+			if source, ok := sources[assetID]; ok {
+				if fields := source.changedFields(mergeSource(details)); len(fields) > 0 {
+					drift = append(drift, fmt.Sprintf("the %s of asset %s changed since the metadata merge was planned",
+						strings.Join(fields, ", "), truncateID(assetID)))
+				}
+			}
+		}
+	}
+
+	return drift, nil
+}
+
+// applyPlan executes a plan after checking that the server has not drifted.
+// Nothing is changed if any drift is found. A group whose album additions
+// fail is not resolved.
+func applyPlan(config *Config, plan *Plan) (*Stats, error) {
+	if plan.Server != config.ImmichURL {
+		return nil, fmt.Errorf("plan was made for %s, not %s", plan.Server, config.ImmichURL)
+	}
+
+	logInfo("🔍 Checking plan against the server...")
+	drift, err := checkDrift(config, plan)
+	if err != nil {
+		return nil, err
+	}
+	if len(drift) > 0 {
+		for _, problem := range drift {
+			logError("❌ %s", problem)
+		}
+		return nil, fmt.Errorf("%w (%d difference(s)); create a new plan", errDrift, len(drift))
+	}
+
+	additions, deletions, stacks := plan.counts()
+	logInfo("✅ Plan matches the server: %d album addition(s), %d deletion(s), %d stack(s) in %d group(s)",
+		additions, deletions, stacks, len(plan.Groups))
+
+	if !config.Yes && !config.DryRun && (additions > 0 || deletions > 0 || stacks > 0) {
+		response, err := config.prompt("\n⚠️  Apply this plan? [y/N]: ")
+		if err != nil || (!strings.EqualFold(response, "y") && !strings.EqualFold(response, "yes")) {
+			logInfo("❌ Plan not applied")
+			return &Stats{Cancelled: len(plan.Groups)}, nil
+		}
+	}
+
+	// Deletions follow the plan, not the command line
+	config.Permanent = plan.Permanent
+
+	stats := &Stats{}
+	for i, group := range plan.Groups {
+		logInfo("\n📁 Applying group %d/%d (%s)", i+1, len(plan.Groups), truncateID(group.DuplicateID))
+		if err := applyGroupPlan(config, stats, group); err != nil {
+			logError("Failed to apply group %d: %v", i+1, err)
+			stats.Failed++
+			continue
+		}
+		stats.Groups++
+	}
+
+	return stats, nil
+}
+
+// applyGroupPlan executes the changes planned for a single group
+func applyGroupPlan(config *Config, stats *Stats, group GroupPlan) error {
+	for _, addition := range group.AlbumAdditions {
+		if config.DryRun {
+			logInfo("   [DRY RUN] Would add %d asset(s) to album %q", len(addition.AssetIDs), addition.AlbumName)
+			stats.Synced += len(addition.AssetIDs)
+			continue
+		}
+
+		result, err := addAssetsToAlbum(config, addition.AlbumID, addition.AssetIDs)
+		if err != nil {
+			return fmt.Errorf("failed to add assets to album %q: %w", addition.AlbumName, err)
+		}
+		if len(result.Failed) > 0 {
+			return fmt.Errorf("%d asset(s) could not be added to album %q", len(result.Failed), addition.AlbumName)
+		}
+		logInfo("✅ Added %d asset(s) to album %q", len(result.Added), addition.AlbumName)
+		stats.Synced += len(result.Added)
+	}
+
+	if group.Merge != nil {
+		if config.DryRun {
+			for _, change := range group.Merge.Changes {
+				logInfo("   [DRY RUN] Would merge into %s: %s", truncateID(group.Keeper), change)
+			}
+		} else if err := applyMetadataMerge(config, group.Keeper, group.Merge); err != nil {
+			return fmt.Errorf("metadata merge failed, nothing deleted: %w", err)
+		}
+	}
+
+	switch {
+	case len(group.Delete) > 0:
+		if config.DryRun {
+			logInfo("   [DRY RUN] Would delete %d asset(s), keeping %s", len(group.Delete), truncateID(group.Keeper))
+			return nil
+		}
+		if err := deleteAssets(config, group.Delete); err != nil {
+			return fmt.Errorf("failed to delete %d asset(s): %w", len(group.Delete), err)
+		}
+		logInfo("🗑️  Deleted %d asset(s), kept %s", len(group.Delete), truncateID(group.Keeper))
+		if config.Permanent {
+			stats.Deleted += len(group.Delete)
+		} else {
+			stats.Trashed += len(group.Delete)
+		}
+	case len(group.Stack) > 0:
+		if config.DryRun {
+			logInfo("   [DRY RUN] Would stack %d asset(s) with primary asset %s", len(group.Stack), truncateID(group.Stack[0]))
+			return nil
+		}
+		if _, err := createStack(config, group.Stack); err != nil {
+			return fmt.Errorf("failed to stack %d asset(s): %w", len(group.Stack), err)
+		}
+		logInfo("📚 Stacked %d asset(s) with primary asset %s", len(group.Stack), truncateID(group.Stack[0]))
+		stats.Stacked++
+	}
+
+	return nil
+}
+
+// counts returns the number of assets to add to albums, assets to delete and
+// stacks to create
+func (p *Plan) counts() (additions, deletions, stacks int) {
+	for _, group := range p.Groups {
+		for _, addition := range group.AlbumAdditions {
+			additions += len(addition.AssetIDs)
+		}
+		deletions += len(group.Delete)
+		if len(group.Stack) > 0 {
+			stacks++
+		}
+	}
+	return additions, deletions, stacks
+}
+
+// sameIDs reports whether two lists hold the same IDs, in any order
+func sameIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// truncateIDs shortens a list of IDs for display
+func truncateIDs(ids []string) []string {
+	short := make([]string, len(ids))
+	for i, id := range ids {
+		short[i] = truncateID(id)
+	}
+	return short
+}
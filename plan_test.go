@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// planServer is a mock Immich server holding a single duplicate group
+type planServer struct {
+	mu         sync.Mutex
+	duplicates string
+	trashed    string
+	favorite   string
+	mutations  []string
+}
+
+func (s *planServer) Do(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	body := `[]`
+	switch {
+	case req.Method == "GET" && req.URL.Path == "/api/duplicates":
+		body = s.duplicates
+	case req.Method == "GET" && req.URL.Path == "/api/albums":
+		body = `[{"id": "album1", "albumName": "Vacation"}]`
+		if assetID := req.URL.Query().Get("assetId"); assetID != "" && assetID != "small" {
+			body = `[]`
+		}
+	case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/api/assets/"):
+		id := strings.TrimPrefix(req.URL.Path, "/api/assets/")
+		size := "1000"
+		if id == "big" {
+			size = "2000"
+		}
+		body = `{"id": "` + id + `", "isTrashed": ` + boolJSON(id == s.trashed) + `, "isFavorite": ` + boolJSON(id == s.favorite) + `, "exifInfo": {"fileSizeInByte": ` + size + `}}`
+	case req.Method == "POST" && req.URL.Path == "/api/stacks":
+		s.mutations = append(s.mutations, "POST /api/stacks")
+		body = `{"id": "stack1", "primaryAssetId": "big"}`
+	case req.Method == "DELETE" && req.URL.Path == "/api/assets":
+		s.mutations = append(s.mutations, "DELETE /api/assets")
+		return &http.Response{StatusCode: http.StatusNoContent, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+	default:
+		s.mutations = append(s.mutations, req.Method+" "+req.URL.Path)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+}
+
+func boolJSON(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
+
+// TestPlanApply tests that a plan round-trips through its file and is applied
+func TestPlanApply(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	server := &planServer{duplicates: `[{"duplicateId": "dup1", "assets": [{"id": "big"}, {"id": "small"}]}]`}
+	httpClient = server
+
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", AutoDelete: true, Yes: true}
+	plan, err := buildPlan(config)
+	if err != nil {
+		t.Fatalf("buildPlan() error = %v", err)
+	}
+	if len(server.mutations) != 0 {
+		t.Fatalf("buildPlan() changed the server: %v", server.mutations)
+	}
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := writePlan(path, plan); err != nil {
+		t.Fatalf("writePlan() error = %v", err)
+	}
+	loaded, err := readPlan(path)
+	if err != nil {
+		t.Fatalf("readPlan() error = %v", err)
+	}
+
+	if len(loaded.Groups) != 1 {
+		t.Fatalf("plan has %d group(s), want 1", len(loaded.Groups))
+	}
+	group := loaded.Groups[0]
+	if group.Keeper != "big" || !reflect.DeepEqual(group.Delete, []string{"small"}) {
+		t.Errorf("plan keeps %s and deletes %v, want big and [small]", group.Keeper, group.Delete)
+	}
+	if len(group.AlbumAdditions) != 1 || !reflect.DeepEqual(group.AlbumAdditions[0].AssetIDs, []string{"big"}) {
+		t.Errorf("plan album additions = %+v, want big added to album1", group.AlbumAdditions)
+	}
+
+	stats, err := applyPlan(config, loaded)
+	if err != nil {
+		t.Fatalf("applyPlan() error = %v", err)
+	}
+	want := []string{"PUT /api/albums/album1/assets", "DELETE /api/assets"}
+	if !reflect.DeepEqual(server.mutations, want) {
+		t.Errorf("applyPlan() mutations = %v, want %v", server.mutations, want)
+	}
+	if stats.Groups != 1 || stats.Trashed != 1 || stats.Synced != 1 {
+		t.Errorf("applyPlan() stats = %+v", *stats)
+	}
+}
+
+// TestApplyPlanStack tests that stacks created by a plan are journaled
+func TestApplyPlanStack(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+	httpClient = &planServer{duplicates: `[{"duplicateId": "dup1", "assets": [{"id": "big"}, {"id": "small"}]}]`}
+
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := openJournal(path, "run1", "http://localhost:2283")
+	if err != nil {
+		t.Fatalf("openJournal() error = %v", err)
+	}
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", Yes: true, journal: journal}
+	plan := &Plan{
+		Version: planVersion,
+		Server:  "http://localhost:2283",
+		Groups:  []GroupPlan{{DuplicateID: "dup1", Assets: []string{"big", "small"}, Keeper: "big", Stack: []string{"big", "small"}}},
+	}
+
+	stats, err := applyPlan(config, plan)
+	if err != nil {
+		t.Fatalf("applyPlan() error = %v", err)
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readJournal(path, "run1")
+	if err != nil {
+		t.Fatalf("readJournal() error = %v", err)
+	}
+	if stats.Stacked != 1 || len(entries) != 1 || entries[0].Action != actionStack || entries[0].StackID != "stack1" {
+		t.Errorf("applyPlan() stacked %d and journaled %+v, want stack1 journaled", stats.Stacked, entries)
+	}
+}
+
+// TestApplyPlanDrift tests that a plan is refused when the server changed
+func TestApplyPlanDrift(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	tests := []struct {
+		name       string
+		duplicates string
+		trashed    string
+	}{
+		{"group gone", `[]`, ""},
+		{"group changed", `[{"duplicateId": "dup1", "assets": [{"id": "big"}, {"id": "small"}, {"id": "new"}]}]`, ""},
+		{"asset trashed", `[{"duplicateId": "dup1", "assets": [{"id": "big"}, {"id": "small"}]}]`, "small"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &planServer{duplicates: tt.duplicates, trashed: tt.trashed}
+			httpClient = server
+
+			plan := &Plan{
+				Version: planVersion,
+				Server:  "http://localhost:2283",
+				Groups: []GroupPlan{{
+					DuplicateID: "dup1",
+					Assets:      []string{"small", "big"},
+					Keeper:      "big",
+					Delete:      []string{"small"},
+				}},
+			}
+			config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", Yes: true}
+
+			_, err := applyPlan(config, plan)
+			if !errors.Is(err, errDrift) {
+				t.Errorf("applyPlan() error = %v, want drift", err)
+			}
+			if len(server.mutations) != 0 {
+				t.Errorf("applyPlan() changed the server despite drift: %v", server.mutations)
+			}
+		})
+	}
+}
+
+// TestApplyPlanMergeDrift tests that a plan is refused when the metadata a
+// merge was computed from changed
+func TestApplyPlanMergeDrift(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	favorite := true
+	tests := []struct {
+		name      string
+		small     MergeSource // Metadata of small when the plan was made
+		favorite  string      // Favorite asset on the server now
+		wantDrift string
+	}{
+		{"unchanged", MergeSource{AssetID: "small", Favorite: true}, "small", ""},
+		{"unfavorited", MergeSource{AssetID: "small", Favorite: true}, "", "the favorite of asset small changed"},
+		{"rating and tags removed", MergeSource{AssetID: "small", Favorite: true, Rating: 3, TagIDs: []string{"t1"}}, "small", "the rating, tags of asset small changed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &planServer{duplicates: `[{"duplicateId": "dup1", "assets": [{"id": "big"}, {"id": "small"}]}]`, favorite: tt.favorite}
+			httpClient = server
+
+			plan := &Plan{
+				Version: planVersion,
+				Server:  "http://localhost:2283",
+				Groups: []GroupPlan{{
+					DuplicateID: "dup1",
+					Assets:      []string{"small", "big"},
+					Keeper:      "big",
+					Merge: &MetadataMerge{
+						Update:  immich.UpdateAssetRequest{IsFavorite: &favorite},
+						Changes: []string{"favorite: false → true (from small)"},
+						Sources: []MergeSource{{AssetID: "big"}, tt.small},
+					},
+					Delete: []string{"small"},
+				}},
+			}
+			config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", Yes: true}
+
+			drift, err := checkDrift(config, plan)
+			if err != nil {
+				t.Fatalf("checkDrift() error = %v", err)
+			}
+			if tt.wantDrift == "" && len(drift) != 0 || tt.wantDrift != "" && (len(drift) != 1 || !strings.Contains(drift[0], tt.wantDrift)) {
+				t.Errorf("checkDrift() = %q, want %q", drift, tt.wantDrift)
+			}
+		})
+	}
+}
+
+// TestReadPlanVersion tests that unknown plan versions are refused
+func TestReadPlanVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := os.WriteFile(path, []byte(`{"version": 99, "groups": []}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := readPlan(path); err == nil || !strings.Contains(err.Error(), "version 99") {
+		t.Errorf("readPlan() error = %v, want a version error", err)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// Report statuses of a duplicate group
+const (
+	groupStatusDone         = "done"
+	groupStatusFailed       = "failed"
+	groupStatusSkipped      = "skipped"
+	groupStatusCancelled    = "cancelled"
+	groupStatusKept         = "kept"
+	groupStatusNotDuplicate = "not-duplicate"
+)
+
+// Report is the machine-readable record of a run written with --report
+type Report struct {
+	Version    string         `json:"version"`
+	RunID      string         `json:"runId,omitempty"`
+	Server     string         `json:"server"`
+	Mode       string         `json:"mode"`
+	Policy     string         `json:"policy"`
+	DryRun     bool           `json:"dryRun"`
+	Permanent  bool           `json:"permanent,omitempty"`
+	StartedAt  time.Time      `json:"startedAt"`
+	FinishedAt time.Time      `json:"finishedAt"`
+	DurationMs int64          `json:"durationMs"`
+	Totals     Stats          `json:"totals"`
+	Groups     []*GroupReport `json:"groups"`
+	Warnings   []string       `json:"warnings"` // Warnings logged outside of any group
+	Errors     []string       `json:"errors"`   // Errors logged outside of any group
+}
+
+// GroupReport records what happened to a single duplicate group. Album
+// assignments and deleted assets are those that would result from the run
+// in a dry run.
+type GroupReport struct {
+	Index        int                      `json:"index"`
+	DuplicateID  string                   `json:"duplicateId"`
+	Status       string                   `json:"status"`
+	Assets       []string                 `json:"assets"`
+	AlbumsBefore map[string][]ReportAlbum `json:"albumsBefore"` // Albums of each asset before synchronization
+	AlbumsAfter  map[string][]ReportAlbum `json:"albumsAfter"`  // Albums of each asset after synchronization
+	Keeper       *ReportScore             `json:"keeper,omitempty"`
+	Candidates   []ReportScore            `json:"candidates,omitempty"` // Every ranked asset, best first
+	Deleted      []string                 `json:"deleted,omitempty"`
+	StackID      string                   `json:"stackId,omitempty"`
+	Warnings     []string                 `json:"warnings,omitempty"`
+	Errors       []string                 `json:"errors,omitempty"`
+	StartedAt    time.Time                `json:"startedAt"`
+	DurationMs   int64                    `json:"durationMs"`
+
+	albumNames map[string]string // Names of the albums seen in the group, by ID
+}
+
+// ReportAlbum identifies an album in a report
+type ReportAlbum struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// ReportScore is the score of an asset under the quality policy
+type ReportScore struct {
+	ID        string                `json:"id"`
+	Policy    string                `json:"policy,omitempty"`
+	Score     float64               `json:"score"`
+	Breakdown map[Criterion]float64 `json:"breakdown"`
+	Reason    string                `json:"reason,omitempty"`
+}
+
+// Run modes recorded in reports and plans
+const (
+	modeSync   = "sync"
+	modeDelete = "delete"
+	modeStack  = "stack"
+)
+
+// runMode returns how a run resolves duplicates
+func runMode(config *Config) string {
+	switch {
+	case config.AutoDelete:
+		return modeDelete
+	case config.Stack:
+		return modeStack
+	}
+	return modeSync
+}
+
+// policyName returns the name of the configured quality policy
+func policyName(config *Config) string {
+	if config.Policy == "" {
+		return policyLegacy
+	}
+	return config.Policy
+}
+
+// newReport starts the report of a run
+func newReport(config *Config) *Report {
+	return &Report{
+		Version:   version,
+		Server:    config.ImmichURL,
+		Mode:      runMode(config),
+		Policy:    policyName(config),
+		DryRun:    config.DryRun,
+		Permanent: config.Permanent,
+		StartedAt: time.Now().UTC(),
+		Groups:    []*GroupReport{},
+		Warnings:  []string{},
+		Errors:    []string{},
+	}
+}
+
+// newGroupReport starts the report of a duplicate group
+func newGroupReport(groupNum int, group immich.DuplicateGroup) *GroupReport {
+	report := &GroupReport{
+		Index:        groupNum,
+		DuplicateID:  group.DuplicateID,
+		Status:       groupStatusDone,
+		Assets:       make([]string, len(group.Assets)),
+		AlbumsBefore: make(map[string][]ReportAlbum),
+		AlbumsAfter:  make(map[string][]ReportAlbum),
+		StartedAt:    time.Now().UTC(),
+		albumNames:   make(map[string]string),
+	}
+	for i, asset := range group.Assets {
+		report.Assets[i] = asset.ID
+	}
+	return report
+}
+
+// addGroup appends the report of a finished group. A nil report records
+// nothing.
+func (r *Report) addGroup(group *GroupReport) {
+	if r == nil || group == nil {
+		return
+	}
+	r.Groups = append(r.Groups, group)
+}
+
+// recordLog records a warning or error logged while processing group, or
+// outside of any group when group is nil. A nil report records nothing.
+func (r *Report) recordLog(group *GroupReport, isError bool, message string) {
+	if r == nil {
+		return
+	}
+
+	message = redact(plainMessage(message))
+	switch {
+	case group != nil && isError:
+		group.Errors = append(group.Errors, message)
+	case group != nil:
+		group.Warnings = append(group.Warnings, message)
+	case isError:
+		r.Errors = append(r.Errors, message)
+	default:
+		r.Warnings = append(r.Warnings, message)
+	}
+}
+
+// write completes the report with the run totals and saves it to path
+func (r *Report) write(path, runID string, stats *Stats) error {
+	r.RunID = runID
+	r.FinishedAt = time.Now().UTC()
+	r.DurationMs = r.FinishedAt.Sub(r.StartedAt).Milliseconds()
+	if stats != nil {
+		r.Totals = *stats
+	}
+	sort.SliceStable(r.Groups, func(i, j int) bool { return r.Groups[i].Index < r.Groups[j].Index })
+
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// setAlbums records the album assignments of the group before
+// synchronization. A nil report records nothing.
+func (g *GroupReport) setAlbums(assetAlbums map[string][]immich.Album) {
+	if g == nil {
+		return
+	}
+
+	for assetID, albums := range assetAlbums {
+		before := make([]ReportAlbum, 0, len(albums))
+		for _, album := range albums {
+			g.albumNames[album.ID] = album.AlbumName
+			before = append(before, ReportAlbum{ID: album.ID, Name: album.AlbumName})
+		}
+		g.AlbumsBefore[assetID] = before
+		g.AlbumsAfter[assetID] = append([]ReportAlbum{}, before...)
+	}
+}
+
+// addToAlbum records assets placed in an album by the synchronization
+func (g *GroupReport) addToAlbum(albumID string, assetIDs []string) {
+	if g == nil {
+		return
+	}
+
+	album := ReportAlbum{ID: albumID, Name: g.albumNames[albumID]}
+	for _, assetID := range assetIDs {
+		g.AlbumsAfter[assetID] = append(g.AlbumsAfter[assetID], album)
+	}
+}
+
+// setSelection records the ranking of the group's assets
+func (g *GroupReport) setSelection(policy string, ranked []ScoredCandidate) {
+	if g == nil || len(ranked) == 0 {
+		return
+	}
+
+	g.Candidates = make([]ReportScore, len(ranked))
+	for i, scored := range ranked {
+		g.Candidates[i] = ReportScore{ID: scored.ID, Score: scored.Total, Breakdown: scored.Breakdown, Reason: scored.Reason}
+	}
+	keeper := g.Candidates[0]
+	keeper.Policy = policy
+	g.Keeper = &keeper
+}
+
+// setKeeper records that another asset than the policy's choice was kept at
+// the review prompt
+func (g *GroupReport) setKeeper(assetID string) {
+	if g == nil {
+		return
+	}
+	for _, candidate := range g.Candidates {
+		if candidate.ID == assetID {
+			keeper := candidate
+			keeper.Policy = "review"
+			g.Keeper = &keeper
+		}
+	}
+}
+
+// finish records the status and duration of the group
+func (g *GroupReport) finish(status string) {
+	if g == nil {
+		return
+	}
+	g.Status = status
+	g.DurationMs = time.Since(g.StartedAt).Milliseconds()
+}
+
+// plainMessage strips the leading emoji and spacing of a log message
+func plainMessage(message string) string {
+	return strings.TrimLeftFunc(message, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '['
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// TestReport tests the report of a run deleting duplicates
+func TestReport(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := `[]`
+			switch {
+			case req.Method == "GET" && req.URL.Query().Get("assetId") == "big":
+				body = `[{"id": "album1", "albumName": "Vacation"}]`
+			case req.Method == "GET" && req.URL.Path == "/api/assets/big":
+				body = `{"id": "big", "originalFileName": "a.jpg", "exifInfo": {"fileSizeInByte": 2000}}`
+			case req.Method == "GET" && req.URL.Path == "/api/assets/small":
+				body = `{"id": "small", "originalFileName": "b.jpg", "exifInfo": {"fileSizeInByte": 1000}}`
+			case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/api/assets/"):
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString(`{}`))}, nil
+			case req.Method == "DELETE":
+				return &http.Response{StatusCode: http.StatusNoContent, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+			}
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewBufferString(body)),
+			}, nil
+		},
+	}
+
+	config := &Config{
+		ImmichURL:  "http://localhost:2283",
+		APIKey:     "test-key",
+		AutoDelete: true,
+		Yes:        true,
+		ReportPath: filepath.Join(t.TempDir(), "report.json"),
+	}
+	config.report = newReport(config)
+
+	duplicates := []immich.DuplicateGroup{
+		{DuplicateID: "dup1", Assets: []immich.DuplicateAsset{{ID: "big"}, {ID: "small"}}},
+		{DuplicateID: "dup2", Assets: []immich.DuplicateAsset{{ID: "gone1"}, {ID: "gone2"}}},
+		{DuplicateID: "dup3", Assets: []immich.DuplicateAsset{{ID: "done1"}, {ID: "done2"}}},
+	}
+	checkpoint := &Checkpoint{completed: map[string]bool{"dup3": true}}
+
+	stats := processGroups(config, checkpoint, duplicates)
+	writeReport(config, stats)
+
+	data, err := os.ReadFile(config.ReportPath)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("failed to decode report: %v", err)
+	}
+
+	if report.Mode != modeDelete || report.Policy != policyLegacy || report.Totals.Trashed != 1 || report.Totals.Skipped != 1 {
+		t.Errorf("report header = %s/%s, totals %+v", report.Mode, report.Policy, report.Totals)
+	}
+	if len(report.Groups) != 3 {
+		t.Fatalf("report has %d group(s), want 3", len(report.Groups))
+	}
+
+	done := report.Groups[0]
+	if done.Status != groupStatusDone || done.Keeper == nil || done.Keeper.ID != "big" {
+		t.Errorf("group 1 = status %s, keeper %+v, want done with keeper big", done.Status, done.Keeper)
+	}
+	if len(done.Candidates) != 2 || done.Keeper.Breakdown[CriterionFileSize] == 0 {
+		t.Errorf("group 1 candidates = %+v, want 2 with a size breakdown", done.Candidates)
+	}
+	if !reflect.DeepEqual(done.Deleted, []string{"small"}) {
+		t.Errorf("group 1 deleted = %v, want [small]", done.Deleted)
+	}
+	if len(done.AlbumsBefore["small"]) != 0 || len(done.AlbumsAfter["small"]) != 1 || done.AlbumsAfter["small"][0].Name != "Vacation" {
+		t.Errorf("group 1 albums of small = %v → %v, want [] → [Vacation]", done.AlbumsBefore["small"], done.AlbumsAfter["small"])
+	}
+
+	if failed := report.Groups[1]; len(failed.Warnings) == 0 || failed.Deleted != nil {
+		t.Errorf("group 2 = %+v, want warnings and nothing deleted", failed)
+	} else if strings.HasPrefix(failed.Warnings[0], "⚠️") {
+		t.Errorf("group 2 warning %q should not start with an emoji", failed.Warnings[0])
+	}
+	if skipped := report.Groups[2]; skipped.Status != groupStatusSkipped {
+		t.Errorf("group 3 status = %s, want %s", skipped.Status, groupStatusSkipped)
+	}
+}
+
+// TestPlainMessage tests stripping emoji from log messages
+func TestPlainMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"⚠️  Failed to fetch albums", "Failed to fetch albums"},
+		{"❌ 3 asset(s) failed", "3 asset(s) failed"},
+		{"   [DRY RUN] Would add", "[DRY RUN] Would add"},
+		{"Plain", "Plain"},
+	}
+
+	for _, tt := range tests {
+		if got := plainMessage(tt.message); got != tt.want {
+			t.Errorf("plainMessage(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
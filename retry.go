@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+const (
+	// Retry defaults
+	defaultMaxAttempts = 4
+	retryBaseDelay     = 500 * time.Millisecond
+	retryMaxDelay      = 30 * time.Second
+	retryAfterMaxDelay = 5 * time.Minute
+)
+
+// retryClient wraps an HTTPClient and retries idempotent requests that fail
+// with a transient error, using jittered exponential backoff and honouring
+// the Retry-After header
+type retryClient struct {
+	client      immich.HTTPClient
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	sleep       func(ctx context.Context, d time.Duration) error
+	logWarning  func(format string, args ...interface{})
+}
+
+// newRetryClient returns client wrapped with retries, making at most
+// maxAttempts attempts per request
+func newRetryClient(client immich.HTTPClient, maxAttempts int) *retryClient {
+	return &retryClient{
+		client:      client,
+		maxAttempts: max(1, maxAttempts),
+		baseDelay:   retryBaseDelay,
+		maxDelay:    retryMaxDelay,
+		sleep:       sleepContext,
+		logWarning:  logWarning,
+	}
+}
+
+// withConfig returns a copy of the client making at most config.MaxAttempts
+// attempts, so that every user of --users has its own limit, and logging its
+// retries with config.logWarning, so that they reach the log of the group
+// being processed
+func (c *retryClient) withConfig(config *Config) *retryClient {
+	copied := *c
+	copied.maxAttempts = max(1, config.MaxAttempts)
+	copied.logWarning = config.logWarning
+	return &copied
+}
+
+// Do sends req, retrying it while it fails with a transient error
+func (c *retryClient) Do(req *http.Request) (*http.Response, error) {
+	if !isIdempotent(req) {
+		return c.client.Do(req)
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.client.Do(req)
+		if attempt >= c.maxAttempts || !isRetryable(resp, err) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			// The body cannot be replayed
+			return resp, err
+		}
+
+		delay := c.backoff(attempt)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = fmt.Sprintf("HTTP %d", resp.StatusCode)
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				delay = min(retryAfter, retryAfterMaxDelay)
+			}
+			drainBody(resp)
+		}
+
+		c.logWarning("%s %s failed (%s), retrying in %s (attempt %d/%d)",
+			req.Method, req.URL.Path, reason, delay.Round(time.Millisecond), attempt+1, c.maxAttempts)
+
+		if err := c.sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to replay request body: %w", err)
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// sleepContext waits for d, returning early with the context error when ctx
+// is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// backoff returns a random delay of up to baseDelay * 2^(attempt-1), capped
+// at maxDelay
+func (c *retryClient) backoff(attempt int) time.Duration {
+	ceiling := c.maxDelay
+	if shift := attempt - 1; shift < 32 {
+		ceiling = min(c.maxDelay, c.baseDelay<<shift)
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// isIdempotent reports whether req can be safely retried. Adding assets to
+// an album is not: if the first attempt succeeded but its response was lost,
+// the retry reports the assets as already present, so they would be neither
+// counted as added nor journaled.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodDelete:
+		return true
+	case http.MethodPut:
+		return !isAlbumAssetsPath(req.URL.Path)
+	}
+	return false
+}
+
+// isAlbumAssetsPath reports whether path is the assets endpoint of an album
+func isAlbumAssetsPath(path string) bool {
+	_, rest, ok := strings.Cut(path, "/api/albums/")
+	return ok && strings.Count(rest, "/") == 1 && strings.HasSuffix(rest, "/assets")
+}
+
+// isRetryable reports whether a response or error is transient. Transport
+// errors such as connection resets and timeouts are always retried.
+func isRetryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, date.Sub(now)), true
+	}
+	return 0, false
+}
+
+// drainBody discards and closes a response body so the connection can be reused
+func drainBody(resp *http.Response) {
+	if resp == nil || resp.Body == nil {
+		return
+	}
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		logError("Failed to drain response body: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		logError("Failed to close response body: %v", err)
+	}
+}
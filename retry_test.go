@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRetryClient tests which requests are retried and how often
+func TestRetryClient(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		responses    []int // Status codes returned in order; 0 means a transport error
+		maxAttempts  int
+		wantAttempts int
+		wantStatus   int
+		wantErr      bool
+	}{
+		{"success is not retried", "GET", []int{200}, 4, 1, 200, false},
+		{"503 then success", "GET", []int{503, 200}, 4, 2, 200, false},
+		{"429 and 502 then success", "PUT", []int{429, 502, 200}, 4, 3, 200, false},
+		{"connection error then success", "DELETE", []int{0, 204}, 4, 2, 204, false},
+		{"gives up after max attempts", "GET", []int{503, 503, 503}, 3, 3, 503, false},
+		{"transport error after max attempts", "GET", []int{0, 0}, 2, 2, 0, true},
+		{"client errors are not retried", "GET", []int{401, 200}, 4, 1, 401, false},
+		{"POST is not retried", "POST", []int{503, 200}, 4, 1, 503, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			var bodies []string
+			mock := &MockHTTPClient{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					status := tt.responses[attempts]
+					attempts++
+					if req.Body != nil {
+						body, _ := io.ReadAll(req.Body)
+						bodies = append(bodies, string(body))
+					}
+					if status == 0 {
+						return nil, errors.New("connection reset by peer")
+					}
+					return &http.Response{
+						StatusCode: status,
+						Body:       io.NopCloser(bytes.NewBufferString(`{}`)),
+					}, nil
+				},
+			}
+
+			var delays []time.Duration
+			client := newRetryClient(mock, tt.maxAttempts)
+			client.sleep = func(_ context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+
+			req, _ := http.NewRequest(tt.method, "http://localhost:2283/api/assets", bytes.NewBufferString(`{"ids":["a"]}`))
+			resp, err := client.Do(req)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && resp.StatusCode != tt.wantStatus {
+				t.Errorf("Do() status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("Do() made %d attempt(s), want %d", attempts, tt.wantAttempts)
+			}
+			if len(delays) != tt.wantAttempts-1 {
+				t.Errorf("Do() slept %d time(s), want %d", len(delays), tt.wantAttempts-1)
+			}
+			for _, body := range bodies {
+				if body != `{"ids":["a"]}` {
+					t.Errorf("retried request body = %q, want the original body", body)
+				}
+			}
+		})
+	}
+}
+
+// TestIsIdempotent tests that album additions are never replayed
+func TestIsIdempotent(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   bool
+	}{
+		{"GET", "/api/albums/album1", true},
+		{"PUT", "/api/assets", true},
+		{"PUT", "/api/tags/tag1/assets", true},
+		{"PUT", "/api/albums/album1/assets", false},
+		{"PUT", "/immich/api/albums/album1/assets", false},
+		{"DELETE", "/api/albums/album1/assets", true},
+		{"POST", "/api/stacks", false},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "http://localhost:2283"+tt.path, nil)
+		if got := isIdempotent(req); got != tt.want {
+			t.Errorf("isIdempotent(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestRetryClientRetryAfter tests that Retry-After overrides the backoff delay
+func TestRetryClientRetryAfter(t *testing.T) {
+	attempts := 0
+	mock := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			if attempts == 1 {
+				return &http.Response{
+					StatusCode: http.StatusTooManyRequests,
+					Header:     http.Header{"Retry-After": []string{"7"}},
+					Body:       io.NopCloser(strings.NewReader(``)),
+				}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(`[]`))}, nil
+		},
+	}
+
+	var delays []time.Duration
+	client := newRetryClient(mock, 3)
+	client.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	req, _ := http.NewRequest("GET", "http://localhost:2283/api/duplicates", nil)
+	if _, err := client.Do(req); err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+
+	if len(delays) != 1 || delays[0] != 7*time.Second {
+		t.Errorf("Do() delays = %v, want [7s]", delays)
+	}
+}
+
+// TestRetryClientCancel tests that cancelling the request interrupts the
+// wait before a retry
+func TestRetryClientCancel(t *testing.T) {
+	attempts := 0
+	mock := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Header:     http.Header{"Retry-After": []string{"300"}},
+				Body:       io.NopCloser(strings.NewReader(``)),
+			}, nil
+		},
+	}
+
+	config := &Config{MaxAttempts: 3, report: &Report{}}
+	client := newRetryClient(mock, defaultMaxAttempts).withConfig(config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://localhost:2283/api/duplicates", nil)
+
+	start := time.Now()
+	if _, err := client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Do() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Do() returned after %v, want it to stop waiting when the context is done", elapsed)
+	}
+	if attempts != 1 || len(config.report.Warnings) != 1 {
+		t.Errorf("Do() made %d attempt(s) and reported %d warning(s), want 1 and 1", attempts, len(config.report.Warnings))
+	}
+}
+
+// TestRetryClientPerConfig tests that each configuration, such as a user of
+// --users, retries up to its own max-attempts
+func TestRetryClientPerConfig(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	attempts := 0
+	retry := newRetryClient(&MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Body: io.NopCloser(strings.NewReader(``))}, nil
+		},
+	}, defaultMaxAttempts)
+	retry.sleep = func(context.Context, time.Duration) error { return nil }
+	httpClient = retry
+
+	for _, maxAttempts := range []int{1, 2, 6} {
+		attempts = 0
+		config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", MaxAttempts: maxAttempts}
+		if _, err := config.api().GetDuplicates(context.Background()); err == nil {
+			t.Fatal("GetDuplicates() should fail with HTTP 503")
+		}
+		if attempts != maxAttempts {
+			t.Errorf("max-attempts %d made %d attempt(s)", maxAttempts, attempts)
+		}
+	}
+}
+
+// TestParseRetryAfter tests parsing of both Retry-After formats
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"empty", "", 0, false},
+		{"seconds", "120", 2 * time.Minute, true},
+		{"negative seconds", "-1", 0, false},
+		{"HTTP date", "Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"date in the past", "Mon, 01 Jan 2024 11:00:00 GMT", 0, true},
+		{"garbage", "soon", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestRetryBackoff tests that the jittered backoff stays within its bounds
+func TestRetryBackoff(t *testing.T) {
+	client := newRetryClient(&MockHTTPClient{}, 10)
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		ceiling := min(retryMaxDelay, retryBaseDelay<<(attempt-1))
+		for i := 0; i < 20; i++ {
+			if d := client.backoff(attempt); d < 0 || d > ceiling {
+				t.Fatalf("backoff(%d) = %v, want between 0 and %v", attempt, d, ceiling)
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// reviewAction is what to do with a duplicate group reviewed interactively
+type reviewAction int
+
+const (
+	reviewResolve      reviewAction = iota // Keep one asset and delete or stack the others
+	reviewSkip                             // Leave the group untouched for now
+	reviewKeepAll                          // Keep every asset of the group
+	reviewNotDuplicate                     // The assets are not duplicates of each other
+	reviewQuit                             // Leave this and every remaining group untouched
+)
+
+// reviewChoice is the answer given for a group at the review prompt
+type reviewChoice struct {
+	action   reviewAction
+	keeperID string // Asset to keep with reviewResolve, empty for the suggested one
+}
+
+// Review holds the answers of an interactive review that outlive a single
+// group: a choice applied to all remaining groups, and whether the user quit.
+// It is shared by every group of a run.
+type Review struct {
+	mu         sync.Mutex
+	remembered *reviewChoice // Choice applied to every remaining group, nil asks for each
+	quit       bool
+}
+
+func newReview() *Review {
+	return &Review{}
+}
+
+// choose returns the choice for a group, calling ask unless an earlier answer
+// applies to every remaining group. Groups are asked one at a time.
+func (r *Review) choose(ask func() (reviewChoice, bool)) reviewChoice {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case r.quit:
+		return reviewChoice{action: reviewQuit}
+	case r.remembered != nil:
+		return *r.remembered
+	}
+
+	choice, remember := ask()
+	if choice.action == reviewQuit {
+		r.quit = true
+	}
+	if remember {
+		r.remembered = &choice
+	}
+	return choice
+}
+
+// stopped reports whether the user quit the review. A nil review never stops.
+func (r *Review) stopped() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.quit
+}
+
+// reviewSelection shows the ranked assets of a group and asks which one to
+// keep. It returns the selection to resolve, or nil if no asset must be
+// deleted or stacked, in which case the decision is counted in stats.
+func reviewSelection(config *Config, stats *Stats, group immich.DuplicateGroup, selection *Selection, assetAlbums map[string][]immich.Album) (*Selection, error) {
+	choice := config.review.choose(func() (reviewChoice, bool) {
+		table := formatReviewTable(selection, assetAlbums)
+		question := fmt.Sprintf("Keep [1-%d, Enter = 1], (s)kip, (k)eep all, (n)ot duplicates, (q)uit; add ! to apply to all remaining groups: ", len(selection.Ranked))
+		for {
+			response, err := config.prompt("\n" + table + question)
+			if err != nil {
+				return reviewChoice{action: reviewQuit}, false
+			}
+			choice, remember, err := parseReviewAnswer(response, selection.Ranked)
+			if err == nil {
+				return choice, remember
+			}
+			fmt.Printf("%v\n", err)
+		}
+	})
+
+	switch choice.action {
+	case reviewSkip:
+		config.logInfo("⏭️  Group skipped")
+		stats.Cancelled++
+	case reviewKeepAll:
+		config.logInfo("✓ Keeping all %d asset(s)", len(selection.Ranked))
+		stats.Kept++
+	case reviewNotDuplicate:
+		if err := markNotDuplicates(config, group); err != nil {
+			return nil, err
+		}
+		stats.NotDuplicates++
+	case reviewQuit:
+		config.logInfo("⏹️  Review stopped; group left untouched")
+		stats.Cancelled++
+	default:
+		if choice.keeperID == "" || choice.keeperID == selection.KeeperID {
+			return selection, nil
+		}
+		config.logInfo("👤 Keeping asset %s instead of %s", truncateID(choice.keeperID), truncateID(selection.KeeperID))
+		config.groupReport.setKeeper(choice.keeperID)
+		return selection.withKeeper(group, choice.keeperID), nil
+	}
+	return nil, nil
+}
+
+// parseReviewAnswer parses an answer to the review prompt. Numbers pick an
+// asset in ranked order; a trailing ! applies the answer to every remaining
+// group, which is not possible for a specific asset.
+func parseReviewAnswer(response string, ranked []ScoredCandidate) (reviewChoice, bool, error) {
+	answer := strings.ToLower(strings.TrimSpace(response))
+	remember := strings.HasSuffix(answer, "!")
+	answer = strings.TrimSuffix(answer, "!")
+
+	switch answer {
+	case "", "y", "yes":
+		return reviewChoice{action: reviewResolve}, remember, nil
+	case "s", "skip":
+		return reviewChoice{action: reviewSkip}, remember, nil
+	case "k", "keep":
+		return reviewChoice{action: reviewKeepAll}, remember, nil
+	case "n", "not":
+		return reviewChoice{action: reviewNotDuplicate}, remember, nil
+	case "q", "quit":
+		return reviewChoice{action: reviewQuit}, false, nil
+	}
+
+	n, err := strconv.Atoi(answer)
+	if err != nil || n < 1 || n > len(ranked) {
+		return reviewChoice{}, false, fmt.Errorf("invalid answer %q", response)
+	}
+	if remember {
+		return reviewChoice{}, false, fmt.Errorf("a specific asset cannot be kept in every group; use Enter! to keep the suggested assets")
+	}
+	return reviewChoice{action: reviewResolve, keeperID: ranked[n-1].ID}, false, nil
+}
+
+// formatReviewTable renders the ranked assets of a group, best first, with
+// the suggested asset marked
+func formatReviewTable(selection *Selection, assetAlbums map[string][]immich.Album) string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\t#\tFile\tSize\tResolution\tCreated\tAlbums\tScore")
+	for i, scored := range selection.Ranked {
+		marker := ""
+		if scored.ID == selection.KeeperID {
+			marker = "*"
+		}
+
+		details := selection.Details[scored.ID]
+		size, resolution := "-", "-"
+		if exif := details.ExifInfo; exif != nil {
+			size = formatBytes(exif.FileSizeInByte)
+			if exif.ImageWidth > 0 && exif.ImageHeight > 0 {
+				resolution = fmt.Sprintf("%dx%d", exif.ImageWidth, exif.ImageHeight)
+			}
+		}
+
+		albums := make([]string, len(assetAlbums[scored.ID]))
+		for j, album := range assetAlbums[scored.ID] {
+			albums[j] = album.AlbumName
+		}
+		albumList := strings.Join(albums, ", ")
+		if albumList == "" {
+			albumList = "-"
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%.2f\n", marker, i+1, details.OriginalFileName, size, resolution,
+			details.FileCreatedAt.Format("2006-01-02 15:04"), albumList, scored.Total)
+	}
+	if err := w.Flush(); err != nil {
+		return err.Error() + "\n"
+	}
+	return b.String()
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// TestParseReviewAnswer tests parsing the answers to the review prompt
+func TestParseReviewAnswer(t *testing.T) {
+	ranked := []ScoredCandidate{{ID: "best"}, {ID: "other"}}
+
+	tests := []struct {
+		answer       string
+		want         reviewChoice
+		wantRemember bool
+		wantErr      bool
+	}{
+		{answer: "", want: reviewChoice{action: reviewResolve}},
+		{answer: "Y", want: reviewChoice{action: reviewResolve}},
+		{answer: "!", want: reviewChoice{action: reviewResolve}, wantRemember: true},
+		{answer: "2", want: reviewChoice{action: reviewResolve, keeperID: "other"}},
+		{answer: " s ", want: reviewChoice{action: reviewSkip}},
+		{answer: "k!", want: reviewChoice{action: reviewKeepAll}, wantRemember: true},
+		{answer: "n", want: reviewChoice{action: reviewNotDuplicate}},
+		{answer: "q", want: reviewChoice{action: reviewQuit}},
+		{answer: "3", wantErr: true},
+		{answer: "0", wantErr: true},
+		{answer: "2!", wantErr: true},
+		{answer: "maybe", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, remember, err := parseReviewAnswer(tt.answer, ranked)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseReviewAnswer(%q) error = %v, wantErr %v", tt.answer, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (got != tt.want || remember != tt.wantRemember) {
+			t.Errorf("parseReviewAnswer(%q) = %+v, %v, want %+v, %v", tt.answer, got, remember, tt.want, tt.wantRemember)
+		}
+	}
+}
+
+// TestInteractiveReview tests picking a keeper, remembering an answer for the
+// remaining groups, skipping and quitting, and that only resolved groups are
+// checkpointed
+func TestInteractiveReview(t *testing.T) {
+	oldClient, oldInput := httpClient, promptInput
+	defer func() { httpClient, promptInput = oldClient, oldInput }()
+
+	tests := []struct {
+		name        string
+		input       string
+		wantDeleted []string
+		wantStats   Stats
+		wantDone    []string
+	}{
+		{
+			name:        "keep another asset",
+			input:       "2\nmaybe\ny\n",
+			wantDeleted: []string{"dup1-big", "dup2-small"},
+			wantStats:   Stats{Groups: 2, Trashed: 2},
+			wantDone:    []string{"dup1", "dup2"},
+		},
+		{
+			name:      "keep all remaining",
+			input:     "k!\n",
+			wantStats: Stats{Groups: 2, Kept: 2},
+			wantDone:  []string{"dup1", "dup2"},
+		},
+		{
+			name:        "not duplicates then suggested",
+			input:       "n\n\n",
+			wantDeleted: []string{"dup2-small"},
+			wantStats:   Stats{Groups: 2, Trashed: 1, NotDuplicates: 1},
+			wantDone:    []string{"dup1", "dup2"},
+		},
+		{
+			name:        "skip then suggested",
+			input:       "s\n\n",
+			wantDeleted: []string{"dup2-small"},
+			wantStats:   Stats{Groups: 1, Trashed: 1, Cancelled: 1},
+			wantDone:    []string{"dup2"},
+		},
+		{
+			name:      "quit",
+			input:     "q\n",
+			wantStats: Stats{Cancelled: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var deleted []string
+			httpClient = &MockHTTPClient{
+				DoFunc: func(req *http.Request) (*http.Response, error) {
+					body := `[]`
+					switch {
+					case req.Method == "GET" && strings.HasPrefix(req.URL.Path, "/api/assets/"):
+						id := strings.TrimPrefix(req.URL.Path, "/api/assets/")
+						size := "1000"
+						if strings.HasSuffix(id, "-big") {
+							size = "2000"
+						}
+						body = `{"id": "` + id + `", "originalFileName": "` + id + `.jpg", "exifInfo": {"fileSizeInByte": ` + size + `}}`
+					case req.Method == "DELETE":
+						var request immich.DeleteAssetsRequest
+						if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+							t.Errorf("failed to decode delete request: %v", err)
+						}
+						mu.Lock()
+						deleted = append(deleted, request.IDs...)
+						mu.Unlock()
+						return &http.Response{StatusCode: http.StatusNoContent, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+					}
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+				},
+			}
+			promptInput = bufio.NewReader(strings.NewReader(tt.input))
+
+			duplicates := []immich.DuplicateGroup{
+				{DuplicateID: "dup1", Assets: []immich.DuplicateAsset{{ID: "dup1-big"}, {ID: "dup1-small"}}},
+				{DuplicateID: "dup2", Assets: []immich.DuplicateAsset{{ID: "dup2-big"}, {ID: "dup2-small"}}},
+			}
+			config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", AutoDelete: true, Interactive: true, review: newReview()}
+
+			checkpoint, err := openCheckpoint(filepath.Join(t.TempDir(), "checkpoint.jsonl"), checkpointHeader(config), false)
+			if err != nil {
+				t.Fatalf("openCheckpoint() error = %v", err)
+			}
+			defer checkpoint.Close()
+
+			stats := processGroups(config, checkpoint, duplicates)
+
+			if !reflect.DeepEqual(deleted, tt.wantDeleted) {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			if *stats != tt.wantStats {
+				t.Errorf("stats = %+v, want %+v", *stats, tt.wantStats)
+			}
+			done := []string{}
+			for _, group := range duplicates {
+				if checkpoint.IsDone(group.DuplicateID) {
+					done = append(done, group.DuplicateID)
+				}
+			}
+			if strings.Join(done, ",") != strings.Join(tt.wantDone, ",") {
+				t.Errorf("checkpointed groups = %v, want %v", done, tt.wantDone)
+			}
+		})
+	}
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// Criterion identifies a single quality signal used by weighted policies
+type Criterion string
+
+// Supported scoring criteria
+const (
+	CriterionResolution Criterion = "resolution"
+	CriterionFileSize   Criterion = "size"
+	CriterionBitDepth   Criterion = "bitdepth"
+	CriterionFormat     Criterion = "format"
+	CriterionCameraMake Criterion = "camera"
+	CriterionGPS        Criterion = "gps"
+	CriterionAlbums     Criterion = "albums"
+	CriterionFavorite   Criterion = "favorite"
+)
+
+// allCriteria lists every criterion in reporting order
+var allCriteria = []Criterion{
+	CriterionResolution,
+	CriterionFileSize,
+	CriterionBitDepth,
+	CriterionFormat,
+	CriterionCameraMake,
+	CriterionGPS,
+	CriterionAlbums,
+	CriterionFavorite,
+}
+
+// Built-in policy names
+const (
+	policyLegacy     = "legacy"
+	policyBalanced   = "balanced"
+	policyMetadata   = "metadata"
+	policyResolution = "resolution"
+)
+
+// defaultResolutionTolerance is the relative pixel-count difference under
+// which the resolution policy considers two candidates equivalent
+const defaultResolutionTolerance = 0.02
+
+// Candidate is a duplicate asset under consideration by a QualityPolicy
+type Candidate struct {
+	Details    *immich.AssetDetails
+	AlbumCount int // Number of albums the asset belonged to before synchronization
+}
+
+// ScoredCandidate is the result of scoring a single candidate
+type ScoredCandidate struct {
+	ID        string
+	Total     float64
+	Breakdown map[Criterion]float64 // Weighted contribution of each criterion
+	Reason    string                // Why the candidate was ranked first, if the policy explains it
+}
+
+// QualityPolicy ranks the candidates of a duplicate group, best first
+type QualityPolicy interface {
+	Name() string
+	Rank(candidates []Candidate) []ScoredCandidate
+}
+
+// Weights maps each criterion to its weight in a weighted policy
+type Weights map[Criterion]float64
+
+// weightPresets holds the weights of the built-in weighted policies
+var weightPresets = map[string]Weights{
+	policyBalanced: {
+		CriterionResolution: 4,
+		CriterionFileSize:   2,
+		CriterionBitDepth:   1,
+		CriterionFormat:     1,
+		CriterionCameraMake: 1,
+		CriterionGPS:        1,
+		CriterionAlbums:     1,
+		CriterionFavorite:   2,
+	},
+	policyMetadata: {
+		CriterionResolution: 1,
+		CriterionFileSize:   1,
+		CriterionCameraMake: 2,
+		CriterionGPS:        3,
+		CriterionAlbums:     3,
+		CriterionFavorite:   4,
+	},
+}
+
+// policyNames returns the names of every selectable policy
+func policyNames() []string {
+	names := []string{policyLegacy, policyResolution}
+	for name := range weightPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names[2:])
+	return names
+}
+
+// newQualityPolicy returns the policy selected by config.Policy, applying
+// config.Weights overrides (in the form "resolution=3,size=1") to weighted
+// policies and config.ResolutionTolerance to the resolution policy
+func newQualityPolicy(config *Config) (QualityPolicy, error) {
+	name := config.Policy
+	weightOverrides := config.Weights
+	if name == "" {
+		name = policyLegacy
+	}
+
+	switch name {
+	case policyLegacy:
+		if weightOverrides != "" {
+			return nil, fmt.Errorf("--weights cannot be used with the %s policy", policyLegacy)
+		}
+		return legacyPolicy{}, nil
+	case policyResolution:
+		if weightOverrides != "" {
+			return nil, fmt.Errorf("--weights cannot be used with the %s policy", policyResolution)
+		}
+		if config.ResolutionTolerance < 0 || config.ResolutionTolerance >= 1 {
+			return nil, fmt.Errorf("--resolution-tolerance must be between 0 and 1, got %g", config.ResolutionTolerance)
+		}
+		return &resolutionPolicy{tolerance: config.ResolutionTolerance}, nil
+	}
+
+	preset, ok := weightPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown policy %q (available: %s)", name, strings.Join(policyNames(), ", "))
+	}
+
+	weights := make(Weights, len(preset))
+	for criterion, weight := range preset {
+		weights[criterion] = weight
+	}
+
+	overrides, err := parseWeights(weightOverrides)
+	if err != nil {
+		return nil, err
+	}
+	for criterion, weight := range overrides {
+		weights[criterion] = weight
+	}
+
+	return &weightedPolicy{name: name, weights: weights}, nil
+}
+
+// parseWeights parses a comma-separated list of criterion=weight pairs
+func parseWeights(spec string) (Weights, error) {
+	weights := Weights{}
+	if strings.TrimSpace(spec) == "" {
+		return weights, nil
+	}
+
+	known := make(map[Criterion]bool, len(allCriteria))
+	for _, criterion := range allCriteria {
+		known[criterion] = true
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid weight %q: expected criterion=weight", pair)
+		}
+
+		criterion := Criterion(strings.ToLower(strings.TrimSpace(key)))
+		if !known[criterion] {
+			return nil, fmt.Errorf("unknown criterion %q in --weights", key)
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %q", criterion, value)
+		}
+		weights[criterion] = weight
+	}
+
+	return weights, nil
+}
+
+// legacyPolicy keeps the historical size > filename > date ordering of
+// selectBestQualityAsset
+type legacyPolicy struct{}
+
+func (legacyPolicy) Name() string { return policyLegacy }
+
+func (legacyPolicy) Rank(candidates []Candidate) []ScoredCandidate {
+	assets := make(map[string]*immich.AssetDetails, len(candidates))
+	var maxSize int64
+	for _, c := range candidates {
+		assets[c.Details.ID] = c.Details
+		if c.Details.ExifInfo != nil && c.Details.ExifInfo.FileSizeInByte > maxSize {
+			maxSize = c.Details.ExifInfo.FileSizeInByte
+		}
+	}
+
+	bestID := selectBestQualityAsset(assets)
+	if bestID == "" {
+		return nil
+	}
+
+	ranked := make([]ScoredCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		score := ratio(float64(fileSize(c.Details)), float64(maxSize))
+		ranked = append(ranked, ScoredCandidate{
+			ID:        c.Details.ID,
+			Total:     score,
+			Breakdown: map[Criterion]float64{CriterionFileSize: score},
+		})
+	}
+
+	sortRanked(ranked, bestID)
+	return ranked
+}
+
+// resolutionPolicy ranks candidates by pixel count first. Candidates whose
+// pixel count is within the tolerance band of the largest one are considered
+// equivalent and ranked with the legacy size > filename > date ordering.
+// Pixel count does not depend on orientation, so a rotated copy ranks the
+// same as its original.
+type resolutionPolicy struct {
+	tolerance float64
+}
+
+func (p *resolutionPolicy) Name() string { return policyResolution }
+
+func (p *resolutionPolicy) Rank(candidates []Candidate) []ScoredCandidate {
+	var maxPixels int64
+	for _, c := range candidates {
+		maxPixels = max(maxPixels, pixelCount(c.Details))
+	}
+
+	threshold := float64(maxPixels) * (1 - p.tolerance)
+	topTier := make(map[string]*immich.AssetDetails)
+	ranked := make([]ScoredCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		pixels := pixelCount(c.Details)
+		if float64(pixels) >= threshold {
+			topTier[c.Details.ID] = c.Details
+		}
+
+		score := ratio(float64(pixels), float64(maxPixels))
+		ranked = append(ranked, ScoredCandidate{
+			ID:        c.Details.ID,
+			Total:     score,
+			Breakdown: map[Criterion]float64{CriterionResolution: score},
+		})
+	}
+
+	bestID := selectBestQualityAsset(topTier)
+	sortRanked(ranked, bestID)
+	if len(ranked) == 0 {
+		return ranked
+	}
+
+	winner := topTier[ranked[0].ID]
+	switch {
+	case maxPixels == 0:
+		ranked[0].Reason = "no resolution data; " + explainTieBreak(winner, topTier)
+	case len(topTier) == 1:
+		runnerUp := int64(0)
+		for _, c := range candidates {
+			if c.Details.ID != winner.ID {
+				runnerUp = max(runnerUp, pixelCount(c.Details))
+			}
+		}
+		ranked[0].Reason = fmt.Sprintf("highest resolution (%s vs %s)", formatMegapixels(maxPixels), formatMegapixels(runnerUp))
+	default:
+		ranked[0].Reason = fmt.Sprintf("%d candidates within %.0f%% of %s; %s",
+			len(topTier), p.tolerance*100, formatMegapixels(maxPixels), explainTieBreak(winner, topTier))
+	}
+
+	return ranked
+}
+
+// explainTieBreak describes which legacy tie-breaker made winner beat the
+// other equivalent candidates
+func explainTieBreak(winner *immich.AssetDetails, tier map[string]*immich.AssetDetails) string {
+	if winner == nil || len(tier) < 2 {
+		return "only candidate"
+	}
+
+	// The tie-breaker that decided is the last one needed against any other
+	// candidate: a larger file only decided if no candidate had the same size
+	largerFile, originalName := true, true
+	for id, other := range tier {
+		if id == winner.ID || fileSize(winner) != fileSize(other) {
+			continue
+		}
+		largerFile = false
+		if isOriginalFilename(winner.OriginalFileName) == isOriginalFilename(other.OriginalFileName) {
+			originalName = false
+		}
+	}
+
+	switch {
+	case largerFile:
+		return fmt.Sprintf("kept the largest file (%d bytes)", fileSize(winner))
+	case originalName:
+		return fmt.Sprintf("kept the original filename %q", winner.OriginalFileName)
+	default:
+		return fmt.Sprintf("kept the earliest file (%s)", winner.FileCreatedAt.Format("2006-01-02 15:04:05"))
+	}
+}
+
+// formatMegapixels renders a pixel count in megapixels
+func formatMegapixels(pixels int64) string {
+	return fmt.Sprintf("%.1f MP", float64(pixels)/1e6)
+}
+
+// weightedPolicy sums the weighted, group-normalized score of every criterion
+type weightedPolicy struct {
+	name    string
+	weights Weights
+}
+
+func (p *weightedPolicy) Name() string { return p.name }
+
+func (p *weightedPolicy) Rank(candidates []Candidate) []ScoredCandidate {
+	var maxPixels, maxSize, maxBits, maxAlbums float64
+	for _, c := range candidates {
+		maxPixels = max(maxPixels, float64(pixelCount(c.Details)))
+		maxSize = max(maxSize, float64(fileSize(c.Details)))
+		if c.Details.ExifInfo != nil {
+			maxBits = max(maxBits, float64(c.Details.ExifInfo.BitsPerSample))
+		}
+		maxAlbums = max(maxAlbums, float64(c.AlbumCount))
+	}
+
+	ranked := make([]ScoredCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		exif := c.Details.ExifInfo
+		if exif == nil {
+			exif = &immich.ExifInfo{}
+		}
+
+		raw := map[Criterion]float64{
+			CriterionResolution: ratio(float64(pixelCount(c.Details)), maxPixels),
+			CriterionFileSize:   ratio(float64(fileSize(c.Details)), maxSize),
+			CriterionBitDepth:   ratio(float64(exif.BitsPerSample), maxBits),
+			CriterionFormat:     formatScore(c.Details),
+			CriterionCameraMake: boolScore(strings.TrimSpace(exif.Make) != ""),
+			CriterionGPS:        boolScore(exif.Latitude != nil && exif.Longitude != nil),
+			CriterionAlbums:     ratio(float64(c.AlbumCount), maxAlbums),
+			CriterionFavorite:   boolScore(c.Details.IsFavorite),
+		}
+
+		scored := ScoredCandidate{ID: c.Details.ID, Breakdown: make(map[Criterion]float64)}
+		for _, criterion := range allCriteria {
+			weight := p.weights[criterion]
+			if weight == 0 {
+				continue
+			}
+			contribution := weight * raw[criterion]
+			scored.Breakdown[criterion] = contribution
+			scored.Total += contribution
+		}
+		ranked = append(ranked, scored)
+	}
+
+	// Break ties on the total score with the legacy ordering
+	tied := make(map[string]*immich.AssetDetails)
+	best := -1.0
+	for _, s := range ranked {
+		best = max(best, s.Total)
+	}
+	for i, s := range ranked {
+		if s.Total == best {
+			tied[s.ID] = candidates[i].Details
+		}
+	}
+
+	sortRanked(ranked, selectBestQualityAsset(tied))
+	return ranked
+}
+
+// sortRanked orders candidates by descending score, placing preferredID first
+// and ordering the remaining ties by asset ID. preferredID comes from
+// selectBestQualityAsset, which breaks its own ties by asset ID, so the
+// ranking never depends on map iteration order.
+func sortRanked(ranked []ScoredCandidate, preferredID string) {
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].ID == preferredID || ranked[j].ID == preferredID {
+			return ranked[i].ID == preferredID
+		}
+		if ranked[i].Total != ranked[j].Total {
+			return ranked[i].Total > ranked[j].Total
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+}
+
+// formatBreakdown renders a score breakdown in criterion order
+func formatBreakdown(breakdown map[Criterion]float64) string {
+	parts := []string{}
+	for _, criterion := range allCriteria {
+		if score, ok := breakdown[criterion]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%.2f", criterion, score))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatScores rates file formats, favoring raw and lossless encodings
+var formatScores = map[string]float64{
+	".dng": 1.0, ".cr2": 1.0, ".cr3": 1.0, ".nef": 1.0, ".arw": 1.0, ".orf": 1.0, ".raf": 1.0, ".rw2": 1.0,
+	".tif": 0.9, ".tiff": 0.9,
+	".png":  0.8,
+	".heic": 0.7, ".heif": 0.7, ".avif": 0.7,
+	".jpg": 0.6, ".jpeg": 0.6,
+	".webp": 0.4,
+	".gif":  0.2,
+}
+
+// formatScore rates an asset's file format between 0 and 1
+func formatScore(details *immich.AssetDetails) float64 {
+	ext := strings.ToLower(filepath.Ext(details.OriginalFileName))
+	if score, ok := formatScores[ext]; ok {
+		return score
+	}
+	return 0.5
+}
+
+// pixelCount returns the number of pixels of an asset, or 0 if unknown
+func pixelCount(details *immich.AssetDetails) int64 {
+	if details.ExifInfo == nil {
+		return 0
+	}
+	return int64(details.ExifInfo.ImageWidth) * int64(details.ExifInfo.ImageHeight)
+}
+
+// fileSize returns the file size of an asset, or 0 if unknown
+func fileSize(details *immich.AssetDetails) int64 {
+	if details.ExifInfo == nil {
+		return 0
+	}
+	return details.ExifInfo.FileSizeInByte
+}
+
+func ratio(value, maximum float64) float64 {
+	if maximum <= 0 {
+		return 0
+	}
+	return value / maximum
+}
+
+func boolScore(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// TestNewQualityPolicy tests policy lookup and weight parsing
+func TestNewQualityPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		weights  string
+		wantName string
+		wantErr  bool
+	}{
+		{"default is legacy", "", "", policyLegacy, false},
+		{"legacy", "legacy", "", policyLegacy, false},
+		{"balanced", "balanced", "", policyBalanced, false},
+		{"balanced with overrides", "balanced", "gps=3, favorite=0", policyBalanced, false},
+		{"legacy with overrides", "legacy", "size=1", "", true},
+		{"unknown policy", "fancy", "", "", true},
+		{"unknown criterion", "balanced", "color=1", "", true},
+		{"malformed weight", "balanced", "size", "", true},
+		{"negative weight", "balanced", "size=-1", "", true},
+		{"resolution", "resolution", "", policyResolution, false},
+		{"resolution with overrides", "resolution", "size=1", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newQualityPolicy(&Config{Policy: tt.policy, Weights: tt.weights})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newQualityPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && policy.Name() != tt.wantName {
+				t.Errorf("newQualityPolicy() name = %s, want %s", policy.Name(), tt.wantName)
+			}
+		})
+	}
+}
+
+// TestQualityPolicyRank tests the winner picked by each policy
+func TestQualityPolicyRank(t *testing.T) {
+	lat, lng := 48.85, 2.35
+
+	bigExport := &immich.AssetDetails{
+		ID:               "export",
+		OriginalFileName: "export.png",
+		ExifInfo:         &immich.ExifInfo{FileSizeInByte: 9000000, ImageWidth: 4000, ImageHeight: 3000},
+	}
+	original := &immich.AssetDetails{
+		ID:               "original",
+		OriginalFileName: "IMG_0001.jpg",
+		IsFavorite:       true,
+		ExifInfo: &immich.ExifInfo{
+			FileSizeInByte: 6000000,
+			ImageWidth:     6000,
+			ImageHeight:    4000,
+			Make:           "Canon",
+			Latitude:       &lat,
+			Longitude:      &lng,
+		},
+	}
+
+	tests := []struct {
+		name       string
+		policy     string
+		weights    string
+		candidates []Candidate
+		want       string
+	}{
+		{
+			name:       "legacy keeps the larger file",
+			policy:     "legacy",
+			candidates: []Candidate{{Details: bigExport}, {Details: original}},
+			want:       "export",
+		},
+		{
+			name:       "balanced keeps the higher resolution original",
+			policy:     "balanced",
+			candidates: []Candidate{{Details: bigExport}, {Details: original}},
+			want:       "original",
+		},
+		{
+			name:       "size-only weights keep the larger file",
+			policy:     "balanced",
+			weights:    "resolution=0,format=0,camera=0,gps=0,favorite=0,albums=0,bitdepth=0",
+			candidates: []Candidate{{Details: original}, {Details: bigExport}},
+			want:       "export",
+		},
+		{
+			name:       "album membership wins under metadata weights",
+			policy:     "metadata",
+			weights:    "resolution=0,size=0,camera=0,gps=0,favorite=0",
+			candidates: []Candidate{{Details: original}, {Details: bigExport, AlbumCount: 3}},
+			want:       "export",
+		},
+		{
+			name:   "tie falls back to legacy ordering",
+			policy: "balanced",
+			candidates: []Candidate{
+				{Details: &immich.AssetDetails{ID: "b", OriginalFileName: "IMG_1.jpg", FileCreatedAt: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), ExifInfo: &immich.ExifInfo{FileSizeInByte: 100}}},
+				{Details: &immich.AssetDetails{ID: "a", OriginalFileName: "IMG_2.jpg", FileCreatedAt: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), ExifInfo: &immich.ExifInfo{FileSizeInByte: 100}}},
+			},
+			want: "a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newQualityPolicy(&Config{Policy: tt.policy, Weights: tt.weights})
+			if err != nil {
+				t.Fatalf("newQualityPolicy() error = %v", err)
+			}
+
+			ranked := policy.Rank(tt.candidates)
+			if len(ranked) != len(tt.candidates) {
+				t.Fatalf("Rank() returned %d candidates, want %d", len(ranked), len(tt.candidates))
+			}
+			if ranked[0].ID != tt.want {
+				t.Errorf("Rank() winner = %s, want %s", ranked[0].ID, tt.want)
+			}
+		})
+	}
+}
+
+// TestWeightedPolicyBreakdown tests that the breakdown sums to the total score
+func TestWeightedPolicyBreakdown(t *testing.T) {
+	policy, err := newQualityPolicy(&Config{Policy: policyBalanced})
+	if err != nil {
+		t.Fatalf("newQualityPolicy() error = %v", err)
+	}
+
+	ranked := policy.Rank([]Candidate{
+		{Details: &immich.AssetDetails{ID: "a", OriginalFileName: "a.jpg", IsFavorite: true, ExifInfo: &immich.ExifInfo{FileSizeInByte: 50, ImageWidth: 10, ImageHeight: 10}}},
+		{Details: &immich.AssetDetails{ID: "b", OriginalFileName: "b.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 100, ImageWidth: 10, ImageHeight: 10}}},
+	})
+
+	for _, scored := range ranked {
+		var sum float64
+		for _, score := range scored.Breakdown {
+			sum += score
+		}
+		if sum != scored.Total {
+			t.Errorf("candidate %s: breakdown sums to %.2f, total is %.2f", scored.ID, sum, scored.Total)
+		}
+	}
+
+	if got := formatBreakdown(ranked[0].Breakdown); got == "" {
+		t.Error("formatBreakdown() returned an empty string")
+	}
+}
+
+// TestLegacyPolicyWithoutExif tests that legacy ranking fails without EXIF data
+func TestLegacyPolicyWithoutExif(t *testing.T) {
+	ranked := legacyPolicy{}.Rank([]Candidate{
+		{Details: &immich.AssetDetails{ID: "a"}},
+		{Details: &immich.AssetDetails{ID: "b"}},
+	})
+	if len(ranked) != 0 {
+		t.Errorf("Rank() returned %d candidates, want 0", len(ranked))
+	}
+}
+
+// TestResolutionPolicy tests pixel-count ranking with its tolerance band
+func TestResolutionPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		tolerance  float64
+		candidates []Candidate
+		want       string
+		wantReason string
+	}{
+		{
+			name: "higher resolution beats larger file",
+			candidates: []Candidate{
+				{Details: &immich.AssetDetails{ID: "png", OriginalFileName: "export.png", ExifInfo: &immich.ExifInfo{FileSizeInByte: 30000000, ImageWidth: 4000, ImageHeight: 3000}}},
+				{Details: &immich.AssetDetails{ID: "jpg", OriginalFileName: "IMG_1.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 8000000, ImageWidth: 6000, ImageHeight: 4000}}},
+			},
+			want:       "jpg",
+			wantReason: "highest resolution (24.0 MP vs 12.0 MP)",
+		},
+		{
+			name: "rotated copy is equivalent and size breaks the tie",
+			candidates: []Candidate{
+				{Details: &immich.AssetDetails{ID: "landscape", ExifInfo: &immich.ExifInfo{FileSizeInByte: 100, ImageWidth: 4000, ImageHeight: 3000}}},
+				{Details: &immich.AssetDetails{ID: "portrait", ExifInfo: &immich.ExifInfo{FileSizeInByte: 200, ImageWidth: 3000, ImageHeight: 4000}}},
+			},
+			want:       "portrait",
+			wantReason: "2 candidates within 0% of 12.0 MP; kept the largest file (200 bytes)",
+		},
+		{
+			name:      "same size as another tied candidate falls back to filename",
+			tolerance: 0.05,
+			candidates: []Candidate{
+				{Details: &immich.AssetDetails{ID: "small", OriginalFileName: "IMG_1.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 100, ImageWidth: 4000, ImageHeight: 3000}}},
+				{Details: &immich.AssetDetails{ID: "generated", OriginalFileName: "IMG_2.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 200, ImageWidth: 4000, ImageHeight: 3000}}},
+				{Details: &immich.AssetDetails{ID: "original", OriginalFileName: "holiday.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 200, ImageWidth: 3960, ImageHeight: 2970}}},
+			},
+			want:       "original",
+			wantReason: `3 candidates within 5% of 12.0 MP; kept the original filename "holiday.jpg"`,
+		},
+		{
+			name:      "within tolerance falls back to filename",
+			tolerance: 0.05,
+			candidates: []Candidate{
+				{Details: &immich.AssetDetails{ID: "a", OriginalFileName: "IMG_1.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 100, ImageWidth: 4000, ImageHeight: 3000}}},
+				{Details: &immich.AssetDetails{ID: "b", OriginalFileName: "holiday.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 100, ImageWidth: 3960, ImageHeight: 2970}}},
+			},
+			want:       "b",
+			wantReason: `2 candidates within 5% of 12.0 MP; kept the original filename "holiday.jpg"`,
+		},
+		{
+			name: "outside tolerance resolution wins",
+			candidates: []Candidate{
+				{Details: &immich.AssetDetails{ID: "a", OriginalFileName: "IMG_1.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 100, ImageWidth: 4000, ImageHeight: 3000}}},
+				{Details: &immich.AssetDetails{ID: "b", OriginalFileName: "holiday.jpg", ExifInfo: &immich.ExifInfo{FileSizeInByte: 100, ImageWidth: 3960, ImageHeight: 2970}}},
+			},
+			want:       "a",
+			wantReason: "highest resolution (12.0 MP vs 11.8 MP)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := newQualityPolicy(&Config{Policy: policyResolution, ResolutionTolerance: tt.tolerance})
+			if err != nil {
+				t.Fatalf("newQualityPolicy() error = %v", err)
+			}
+
+			ranked := policy.Rank(tt.candidates)
+			if ranked[0].ID != tt.want {
+				t.Errorf("Rank() winner = %s, want %s", ranked[0].ID, tt.want)
+			}
+			if ranked[0].Reason != tt.wantReason {
+				t.Errorf("Rank() reason = %q, want %q", ranked[0].Reason, tt.wantReason)
+			}
+		})
+	}
+}
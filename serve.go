@@ -0,0 +1,639 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+const (
+	// Default address of the serve command, reachable from this machine only
+	defaultListenAddress = "127.0.0.1:8080"
+
+	// Duplicate groups returned per page by the groups endpoint
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Actions accepted by the resolve endpoint
+const (
+	webActionDelete = "delete"
+	webActionStack  = "stack"
+	webActionKeep   = "keep"
+	webActionIgnore = "ignore"
+)
+
+// webServer serves the review web UI and the JSON API behind it
+type webServer struct {
+	config *Config
+	policy QualityPolicy
+
+	mu     sync.Mutex      // Serializes resolutions
+	hidden map[string]bool // Groups resolved, kept or ignored during this session
+}
+
+// apiGroupPage is a page of duplicate groups returned by GET /api/groups
+type apiGroupPage struct {
+	Server string     `json:"server"`
+	Policy string     `json:"policy"`
+	DryRun bool       `json:"dryRun"`
+	Total  int        `json:"total"`
+	Offset int        `json:"offset"`
+	Groups []apiGroup `json:"groups"`
+}
+
+// apiGroup is a duplicate group in the JSON API
+type apiGroup struct {
+	Index       int        `json:"index"`
+	DuplicateID string     `json:"duplicateId"`
+	Keeper      string     `json:"keeper,omitempty"` // Asset suggested by the quality policy
+	Assets      []apiAsset `json:"assets"`
+	Warnings    []string   `json:"warnings,omitempty"`
+}
+
+// apiAsset is an asset of a duplicate group in the JSON API
+type apiAsset struct {
+	ID        string     `json:"id"`
+	FileName  string     `json:"fileName,omitempty"`
+	Size      int64      `json:"size,omitempty"`
+	Width     int        `json:"width,omitempty"`
+	Height    int        `json:"height,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+	Camera    string     `json:"camera,omitempty"`
+	Albums    []string   `json:"albums"`
+	Score     *float64   `json:"score,omitempty"`
+	Breakdown string     `json:"breakdown,omitempty"`
+}
+
+// resolveRequest is the body of POST /api/groups/{id}/resolve
+type resolveRequest struct {
+	Action string `json:"action"`           // delete, stack, keep or ignore
+	Keeper string `json:"keeper,omitempty"` // Asset to keep, the policy's choice if empty
+}
+
+// resolveResponse is the outcome of resolving a group
+type resolveResponse struct {
+	Status string   `json:"status"`
+	Stats  Stats    `json:"stats"`
+	Log    []string `json:"log,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+func newWebServer(config *Config) (*webServer, error) {
+	policy, err := newQualityPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	return &webServer{config: config, policy: policy, hidden: make(map[string]bool)}, nil
+}
+
+// runServe serves the review web UI until the server fails
+func runServe(config *Config) error {
+	server, err := newWebServer(config)
+	if err != nil {
+		return err
+	}
+
+	address := config.Listen
+	if address == "" {
+		address = defaultListenAddress
+	}
+	remote, err := remoteListenAddress(address)
+	if err != nil {
+		return err
+	}
+	if remote {
+		if !config.AllowRemote {
+			return fmt.Errorf("--listen %s is reachable from other machines and the web UI has no authentication; listen on 127.0.0.1 or pass --allow-remote", address)
+		}
+		logWarning("⚠️  LISTENING ON %s WITHOUT AUTHENTICATION - anyone who can reach it can delete assets with your API key", address)
+	}
+	logInfo("🌐 Review UI available at http://%s (press Ctrl+C to stop)", address)
+	if config.DryRun {
+		logWarning("⚠️  DRY RUN MODE - No changes will be made")
+	}
+
+	httpServer := &http.Server{
+		Addr:              address,
+		Handler:           server.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return httpServer.ListenAndServe()
+}
+
+// routes returns the handler of the web UI and its API
+func (s *webServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/groups", s.handleGroups)
+	mux.HandleFunc("/api/groups/", s.handleResolve)
+	mux.HandleFunc("/api/assets/", s.handleThumbnail)
+	return s.guard(mux)
+}
+
+// guard rejects requests that a web page from another site could make
+// through the user's browser: requests addressed to a foreign host name
+// (DNS rebinding) and cross-origin or form-encoded changes.
+func (s *webServer) guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.allowedHost(r.Host) {
+			writeJSONError(w, http.StatusForbidden, "unexpected host "+r.Host)
+			return
+		}
+		if r.Method != http.MethodGet {
+			if origin := r.Header.Get("Origin"); origin != "" && origin != "http://"+r.Host {
+				writeJSONError(w, http.StatusForbidden, "cross-origin request refused")
+				return
+			}
+			if mediaType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0]); mediaType != "application/json" {
+				writeJSONError(w, http.StatusUnsupportedMediaType, "expected a JSON body")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// remoteListenAddress reports whether the serve command listening on address
+// would be reachable from other machines: all interfaces, a non-loopback IP
+// address, or a host name other than localhost
+func remoteListenAddress(address string) (bool, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false, fmt.Errorf("invalid --listen address %q: %w", address, err)
+	}
+	if strings.EqualFold(host, "localhost") {
+		return false, nil
+	}
+	ip := net.ParseIP(host)
+	return ip == nil || !ip.IsLoopback(), nil
+}
+
+// allowedHost reports whether a Host header names this server: localhost, a
+// loopback address, the host name it listens on, or with --allow-remote any
+// IP address
+func (s *webServer) allowedHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+
+	listenHost, _, err := net.SplitHostPort(s.config.Listen)
+	if err == nil && listenHost != "" && strings.EqualFold(host, listenHost) {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback() || s.config.AllowRemote
+	}
+	return strings.EqualFold(host, "localhost")
+}
+
+// handleIndex serves the web UI
+func (s *webServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; img-src 'self'; style-src 'unsafe-inline'; script-src 'unsafe-inline'")
+	if _, err := w.Write([]byte(serveHTML)); err != nil {
+		logError("Failed to write response: %v", err)
+	}
+}
+
+// handleGroups returns a page of the duplicate groups not hidden in this
+// session, with the scores of their assets
+func (s *webServer) handleGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid offset")
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultPageSize)
+	if err != nil || limit < 1 {
+		writeJSONError(w, http.StatusBadRequest, "invalid limit")
+		return
+	}
+	limit = min(limit, maxPageSize)
+
+	duplicates, err := s.config.api().GetDuplicates(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to fetch duplicates: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	visible := make([]immich.DuplicateGroup, 0, len(duplicates))
+	for _, group := range duplicates {
+		if len(group.Assets) >= 2 && !s.hidden[group.DuplicateID] {
+			visible = append(visible, group)
+		}
+	}
+	s.mu.Unlock()
+
+	page := apiGroupPage{
+		Server: s.config.ImmichURL,
+		Policy: s.policy.Name(),
+		DryRun: s.config.DryRun,
+		Total:  len(visible),
+		Offset: offset,
+		Groups: []apiGroup{},
+	}
+	for i := offset; i < len(visible) && i < offset+limit; i++ {
+		page.Groups = append(page.Groups, toAPIGroup(buildReviewGroup(s.config, s.policy, i+1, visible[i])))
+	}
+
+	writeJSON(w, http.StatusOK, page)
+}
+
+// toAPIGroup converts a reviewed group to its JSON API representation
+func toAPIGroup(review reviewGroup) apiGroup {
+	group := apiGroup{Index: review.Index, DuplicateID: review.DuplicateID, Assets: []apiAsset{}, Warnings: review.Warnings}
+	for _, item := range review.Assets {
+		asset := apiAsset{ID: item.ID, Albums: []string{}}
+		if details := item.Details; details != nil {
+			asset.FileName = details.OriginalFileName
+			createdAt := details.FileCreatedAt
+			asset.CreatedAt = &createdAt
+			if exif := details.ExifInfo; exif != nil {
+				asset.Size = exif.FileSizeInByte
+				asset.Width, asset.Height = exif.ImageWidth, exif.ImageHeight
+				asset.Camera = strings.TrimSpace(exif.Make + " " + exif.Model)
+			}
+		}
+		for _, album := range item.Albums {
+			asset.Albums = append(asset.Albums, album.AlbumName)
+		}
+		if item.Score != nil {
+			score := item.Score.Total
+			asset.Score = &score
+			asset.Breakdown = formatBreakdown(item.Score.Breakdown)
+		}
+		if item.Keep {
+			group.Keeper = item.ID
+		}
+		group.Assets = append(group.Assets, asset)
+	}
+	return group
+}
+
+// handleResolve handles POST /api/groups/{id}/resolve: it synchronizes the
+// albums of the group and deletes or stacks its other assets, or only hides
+// the group for the rest of the session when it is kept or ignored. Resolved
+// groups are hidden as well.
+func (s *webServer) handleResolve(w http.ResponseWriter, r *http.Request) {
+	duplicateID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/groups/"), "/resolve")
+	if !ok || duplicateID == "" || strings.Contains(duplicateID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var request resolveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&request); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch request.Action {
+	case webActionKeep, webActionIgnore:
+		s.hidden[duplicateID] = true
+		logInfo("👁️  Group %s marked %q in the web UI", truncateID(duplicateID), request.Action)
+		writeJSON(w, http.StatusOK, resolveResponse{Status: request.Action})
+		return
+	case webActionDelete, webActionStack:
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("unknown action %q", request.Action))
+		return
+	}
+
+	duplicates, err := s.config.api().GetDuplicates(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("failed to fetch duplicates: %v", err))
+		return
+	}
+	var group *immich.DuplicateGroup
+	for i := range duplicates {
+		if duplicates[i].DuplicateID == duplicateID {
+			group = &duplicates[i]
+		}
+	}
+	if group == nil {
+		writeJSONError(w, http.StatusNotFound, "the server no longer reports this duplicate group")
+		return
+	}
+	if request.Keeper != "" && !groupHasAsset(*group, request.Keeper) {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("asset %s is not part of the group", request.Keeper))
+		return
+	}
+
+	// Capture the group's log lines to return them with the outcome
+	groupConfig := *s.config
+	groupConfig.output = newGroupOutput()
+	groupConfig.ctx = r.Context()
+	response := resolveResponse{Status: groupStatusDone}
+	if err := resolveWebGroup(&groupConfig, &response.Stats, *group, request); err != nil {
+		groupConfig.logError("Failed to resolve group %s: %v", truncateID(duplicateID), err)
+		response.Status = groupStatusFailed
+		response.Error = redact(err.Error())
+	} else {
+		// Stacked groups are still reported as duplicates by the server
+		s.hidden[duplicateID] = true
+	}
+
+	response.Log = strings.Split(strings.TrimRight(groupConfig.output.buf.String(), "\n"), "\n")
+	outputMu.Lock()
+	groupConfig.output.flush()
+	outputMu.Unlock()
+
+	status := http.StatusOK
+	if response.Error != "" {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, response)
+}
+
+// resolveWebGroup synchronizes the albums of a group and deletes or stacks
+// the assets other than the keeper
+func resolveWebGroup(config *Config, stats *Stats, group immich.DuplicateGroup, request resolveRequest) error {
+	config.logInfo("\n📁 Resolving group %s from the web UI (%s)", truncateID(group.DuplicateID), request.Action)
+
+	syncCount, assetAlbums, err := synchronizeAlbums(config, group)
+	if err != nil {
+		return fmt.Errorf("album synchronization failed: %w", err)
+	}
+	stats.Synced += syncCount
+
+	selection, err := selectKeeper(config, group, assetAlbums)
+	if err != nil {
+		return err
+	}
+	if selection == nil {
+		return errors.New("not enough asset details to compare quality")
+	}
+	if request.Keeper != "" && request.Keeper != selection.KeeperID {
+		if selection.Details[request.Keeper] == nil {
+			return fmt.Errorf("details of asset %s are unavailable", truncateID(request.Keeper))
+		}
+		config.logInfo("👤 Keeping asset %s instead of %s", truncateID(request.Keeper), truncateID(selection.KeeperID))
+		selection = selection.withKeeper(group, request.Keeper)
+	}
+
+	if request.Action == webActionStack {
+		return stackSelection(config, stats, selection)
+	}
+	return deleteSelection(config, stats, selection)
+}
+
+// handleThumbnail proxies GET /api/assets/{id}/thumbnail from Immich, so that
+// the browser never needs the API key
+func (s *webServer) handleThumbnail(w http.ResponseWriter, r *http.Request) {
+	assetID, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/assets/"), "/thumbnail")
+	if !ok || assetID == "" || strings.Contains(assetID, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	thumbnail, err := s.config.api().GetThumbnail(r.Context(), assetID)
+	if err != nil {
+		status := http.StatusBadGateway
+		if immich.StatusCode(err) == http.StatusNotFound {
+			status = http.StatusNotFound
+		}
+		writeJSONError(w, status, fmt.Sprintf("failed to fetch thumbnail: %v", err))
+		return
+	}
+	mediaType, ok := thumbnailType(thumbnail)
+	if !ok {
+		writeJSONError(w, http.StatusBadGateway, fmt.Sprintf("unsupported thumbnail type %q", thumbnail.ContentType))
+		return
+	}
+
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if _, err := w.Write(thumbnail.Data); err != nil {
+		logError("Failed to write thumbnail: %v", err)
+	}
+}
+
+// groupHasAsset reports whether an asset belongs to a duplicate group
+func groupHasAsset(group immich.DuplicateGroup, assetID string) bool {
+	for _, asset := range group.Assets {
+		if asset.ID == assetID {
+			return true
+		}
+	}
+	return false
+}
+
+// queryInt parses an integer query parameter, returning def if it is absent
+func queryInt(r *http.Request, name string, def int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// writeJSON writes v as the JSON body of a response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logError("Failed to write response: %v", err)
+	}
+}
+
+// writeJSONError writes an error message as a JSON response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+const serveHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Duplicate review</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem; background: #f5f5f7; color: #1d1d1f; }
+header p { color: #6e6e73; margin: 0.25rem 0; }
+section { background: #fff; border-radius: 12px; padding: 1rem 1.5rem; margin: 1.5rem 0; box-shadow: 0 1px 3px rgba(0,0,0,.1); }
+section.resolved { opacity: 0.5; }
+h2 { font-size: 1.1rem; margin: 0 0 1rem; }
+h2 code { color: #6e6e73; font-weight: normal; }
+.assets { display: flex; gap: 1rem; flex-wrap: wrap; }
+.asset { flex: 0 1 260px; border: 2px solid #e5e5ea; border-radius: 10px; padding: 0.75rem; cursor: pointer; }
+.asset.keep { border-color: #34c759; }
+.asset img { width: 100%; height: 200px; object-fit: contain; background: #f0f0f3; border-radius: 6px; }
+.badge { display: inline-block; padding: 0.1rem 0.5rem; border-radius: 999px; font-size: 0.8rem; background: #e5e5ea; }
+.keep .badge { background: #34c759; color: #fff; }
+dl { display: grid; grid-template-columns: max-content 1fr; gap: 0.2rem 0.75rem; font-size: 0.85rem; margin: 0.75rem 0 0; }
+dt { color: #6e6e73; }
+dd { margin: 0; overflow-wrap: anywhere; }
+.actions { margin-top: 1rem; display: flex; gap: 0.5rem; }
+button { padding: 0.4rem 0.9rem; border-radius: 8px; border: 1px solid #c7c7cc; background: #fff; cursor: pointer; }
+button.danger { background: #ff3b30; border-color: #ff3b30; color: #fff; }
+.warnings, .error { color: #c93400; font-size: 0.85rem; }
+pre { background: #f0f0f3; padding: 0.5rem; border-radius: 6px; font-size: 0.8rem; white-space: pre-wrap; }
+</style>
+</head>
+<body>
+<header>
+<h1>Duplicate review</h1>
+<p id="summary">Loading...</p>
+</header>
+<main id="groups"></main>
+<p><button id="more" hidden>Load more</button></p>
+<script>
+"use strict";
+let shown = 0, removed = 0;
+
+function el(tag, props, ...children) {
+  const node = document.createElement(tag);
+  Object.assign(node, props || {});
+  for (const child of children) node.append(child);
+  return node;
+}
+
+function bytes(size) {
+  if (!size) return "";
+  const units = ["B", "KiB", "MiB", "GiB"];
+  let i = 0;
+  while (size >= 1024 && i < units.length - 1) { size /= 1024; i++; }
+  return (i ? size.toFixed(1) : size) + " " + units[i];
+}
+
+async function load() {
+  const more = document.getElementById("more");
+  more.hidden = true;
+  const res = await fetch("/api/groups?offset=" + (shown - removed) + "&limit=20");
+  const page = await res.json();
+  if (!res.ok) {
+    document.getElementById("summary").textContent = "Error: " + page.error;
+    return;
+  }
+  document.getElementById("summary").textContent = "Server: " + page.server + " · policy " + page.policy +
+    " · " + page.total + " group(s)" + (page.dryRun ? " · DRY RUN, nothing will be changed" : "");
+  for (const group of page.groups) document.getElementById("groups").append(renderGroup(group));
+  shown += page.groups.length;
+  more.hidden = shown - removed >= page.total;
+}
+
+function renderGroup(group) {
+  const section = el("section");
+  let keeper = group.keeper || (group.assets[0] && group.assets[0].id);
+  const cards = el("div", {className: "assets"});
+  const select = (id) => {
+    keeper = id;
+    for (const card of cards.children) {
+      const keep = card.dataset.id === id;
+      card.classList.toggle("keep", keep);
+      card.querySelector(".badge").textContent = keep ? "Keep" : "Delete";
+    }
+  };
+  for (const asset of group.assets) {
+    const details = el("dl");
+    const row = (label, value) => { if (value) details.append(el("dt", {textContent: label}), el("dd", {textContent: value})); };
+    row("File", asset.fileName);
+    row("Size", bytes(asset.size));
+    row("Resolution", asset.width ? asset.width + " × " + asset.height : "");
+    row("Created", asset.createdAt ? new Date(asset.createdAt).toLocaleString() : "");
+    row("Camera", asset.camera);
+    row("Albums", asset.albums.length ? asset.albums.join(", ") : "none");
+    row("Score", asset.score !== undefined ? asset.score.toFixed(2) + " (" + asset.breakdown + ")" : "");
+    const card = el("div", {className: "asset", title: asset.id},
+      el("img", {src: "/api/assets/" + encodeURIComponent(asset.id) + "/thumbnail", alt: "Thumbnail of " + asset.id, loading: "lazy"}),
+      el("p", {}, el("span", {className: "badge"})), details);
+    card.dataset.id = asset.id;
+    card.addEventListener("click", () => select(asset.id));
+    cards.append(card);
+  }
+  select(keeper);
+
+  const output = el("div");
+  const buttons = [
+    ["Delete others", "delete", "danger"],
+    ["Stack", "stack", ""],
+    ["Keep all", "keep", ""],
+    ["Ignore", "ignore", ""],
+  ].map(([label, action, className]) => {
+    const button = el("button", {textContent: label, className: className});
+    button.addEventListener("click", () => resolve(group, action, keeper, section, buttons, output));
+    return button;
+  });
+
+  section.append(
+    el("h2", {}, "Group " + group.index + " ", el("code", {textContent: group.duplicateId})),
+    cards,
+    el("div", {className: "actions"}, ...buttons),
+    output);
+  if (group.warnings) section.append(el("ul", {className: "warnings"}, ...group.warnings.map((w) => el("li", {textContent: w}))));
+  return section;
+}
+
+async function resolve(group, action, keeper, section, buttons, output) {
+  if (action === "delete" && !confirm("Delete every asset of group " + group.index + " except the one marked Keep?")) return;
+  for (const button of buttons) button.disabled = true;
+  output.replaceChildren(el("p", {textContent: "Working..."}));
+
+  let result;
+  try {
+    const res = await fetch("/api/groups/" + encodeURIComponent(group.duplicateId) + "/resolve", {
+      method: "POST",
+      headers: {"Content-Type": "application/json"},
+      body: JSON.stringify({action: action, keeper: keeper}),
+    });
+    result = await res.json();
+  } catch (err) {
+    result = {error: String(err)};
+  }
+
+  output.replaceChildren();
+  if (result.log) output.append(el("pre", {textContent: result.log.join("\n")}));
+  if (result.error) {
+    output.prepend(el("p", {className: "error", textContent: "Failed: " + result.error}));
+    for (const button of buttons) button.disabled = false;
+    return;
+  }
+  output.prepend(el("p", {textContent: {delete: "Duplicates deleted", stack: "Stacked", keep: "All assets kept", ignore: "Ignored"}[action]}));
+  section.classList.add("resolved");
+  removed++;
+}
+
+document.getElementById("more").addEventListener("click", load);
+load();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// serveMock is a mock Immich server with one duplicate group
+func serveMock(t *testing.T, deleted *[]string) *MockHTTPClient {
+	var mu sync.Mutex
+	return &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			header := http.Header{"Content-Type": {"application/json"}}
+			body := `[]`
+			switch {
+			case req.URL.Path == "/api/duplicates":
+				body = `[{"duplicateId": "dup1", "assets": [{"id": "big"}, {"id": "small"}]}, {"duplicateId": "single", "assets": [{"id": "x"}]}]`
+			case req.URL.Path == "/api/albums":
+				if req.URL.Query().Get("assetId") == "small" {
+					body = `[{"id": "album1", "albumName": "Vacation"}]`
+				}
+			case req.URL.Path == "/api/assets/big":
+				body = `{"id": "big", "originalFileName": "big.jpg", "exifInfo": {"fileSizeInByte": 2048, "imageWidth": 40, "imageHeight": 30}}`
+			case req.URL.Path == "/api/assets/small":
+				body = `{"id": "small", "originalFileName": "small.jpg", "exifInfo": {"fileSizeInByte": 1024}}`
+			case req.URL.Path == "/api/assets/big/thumbnail":
+				header.Set("Content-Type", "image/jpeg")
+				body = "jpeg"
+			case req.URL.Path == "/api/assets/small/thumbnail":
+				header.Set("Content-Type", "image/svg+xml")
+				body = "<svg/>"
+			case req.Method == "DELETE":
+				var request immich.DeleteAssetsRequest
+				if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+					t.Errorf("failed to decode delete request: %v", err)
+				}
+				mu.Lock()
+				*deleted = append(*deleted, request.IDs...)
+				mu.Unlock()
+				return &http.Response{StatusCode: http.StatusNoContent, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+			}
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		},
+	}
+}
+
+// serveRequest sends a request to the web UI as a same-origin browser would
+func serveRequest(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Host = "127.0.0.1:8080"
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestServeGroups tests listing groups and resolving one through the API
+func TestServeGroups(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	var deleted []string
+	httpClient = serveMock(t, &deleted)
+
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", Listen: defaultListenAddress}
+	server, err := newWebServer(config)
+	if err != nil {
+		t.Fatalf("newWebServer() error = %v", err)
+	}
+	handler := server.routes()
+
+	rec := serveRequest(handler, "GET", "/api/groups", "")
+	var page apiGroupPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("failed to decode groups: %v", err)
+	}
+	if rec.Code != http.StatusOK || page.Total != 1 || len(page.Groups) != 1 {
+		t.Fatalf("GET /api/groups = %d with %d of %d group(s), want 1 of 1", rec.Code, len(page.Groups), page.Total)
+	}
+	group := page.Groups[0]
+	if group.Keeper != "big" || len(group.Assets) != 2 || group.Assets[0].Width != 40 || !reflect.DeepEqual(group.Assets[1].Albums, []string{"Vacation"}) {
+		t.Errorf("group = %+v", group)
+	}
+
+	// Keep the smaller asset instead of the suggested one
+	rec = serveRequest(handler, "POST", "/api/groups/dup1/resolve", `{"action": "delete", "keeper": "small"}`)
+	var result resolveResponse
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("failed to decode resolution: %v", err)
+	}
+	if rec.Code != http.StatusOK || result.Status != groupStatusDone || result.Stats.Trashed != 1 || result.Stats.Synced != 1 || len(result.Log) == 0 {
+		t.Errorf("resolve = %d %+v", rec.Code, result)
+	}
+	if !reflect.DeepEqual(deleted, []string{"big"}) {
+		t.Errorf("deleted = %v, want [big]", deleted)
+	}
+
+	// Resolved groups are no longer listed
+	rec = serveRequest(handler, "GET", "/api/groups", "")
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("failed to decode groups: %v", err)
+	}
+	if page.Total != 0 {
+		t.Errorf("GET /api/groups after resolving lists %d group(s), want 0", page.Total)
+	}
+}
+
+// TestServeRejectedRequests tests the validation of API requests
+func TestServeRejectedRequests(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	var deleted []string
+	httpClient = serveMock(t, &deleted)
+
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", Listen: defaultListenAddress}
+	server, err := newWebServer(config)
+	if err != nil {
+		t.Fatalf("newWebServer() error = %v", err)
+	}
+	handler := server.routes()
+
+	tests := []struct {
+		name     string
+		prepare  func(req *http.Request)
+		target   string
+		body     string
+		wantCode int
+	}{
+		{"unknown keeper", nil, "/api/groups/dup1/resolve", `{"action": "delete", "keeper": "other"}`, http.StatusBadRequest},
+		{"unknown action", nil, "/api/groups/dup1/resolve", `{"action": "burn"}`, http.StatusBadRequest},
+		{"unknown group", nil, "/api/groups/gone/resolve", `{"action": "delete"}`, http.StatusNotFound},
+		{"form body", func(req *http.Request) { req.Header.Set("Content-Type", "application/x-www-form-urlencoded") }, "/api/groups/dup1/resolve", `{"action": "delete"}`, http.StatusUnsupportedMediaType},
+		{"cross origin", func(req *http.Request) { req.Header.Set("Origin", "http://evil.example") }, "/api/groups/dup1/resolve", `{"action": "delete"}`, http.StatusForbidden},
+		{"foreign host", func(req *http.Request) { req.Host = "evil.example:8080" }, "/api/groups/dup1/resolve", `{"action": "delete"}`, http.StatusForbidden},
+		{"foreign IP address", func(req *http.Request) { req.Host = "192.168.1.5:8080" }, "/api/groups/dup1/resolve", `{"action": "delete"}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+			req.Host = "localhost:8080"
+			req.Header.Set("Content-Type", "application/json")
+			if tt.prepare != nil {
+				tt.prepare(req)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d (%s)", rec.Code, tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+	if len(deleted) != 0 {
+		t.Errorf("rejected requests deleted %v", deleted)
+	}
+}
+
+// TestServeListenAddress tests that serve refuses addresses reachable from
+// other machines without --allow-remote
+func TestServeListenAddress(t *testing.T) {
+	tests := []struct {
+		address    string
+		wantRemote bool
+		wantErr    bool
+	}{
+		{"127.0.0.1:8080", false, false},
+		{"[::1]:8080", false, false},
+		{"localhost:8080", false, false},
+		{":8080", true, false},
+		{"0.0.0.0:8080", true, false},
+		{"192.168.1.5:8080", true, false},
+		{"nas.lan:8080", true, false},
+		{"8080", false, true},
+	}
+	for _, tt := range tests {
+		remote, err := remoteListenAddress(tt.address)
+		if remote != tt.wantRemote || (err != nil) != tt.wantErr {
+			t.Errorf("remoteListenAddress(%q) = %v, %v, want %v (error %v)", tt.address, remote, err, tt.wantRemote, tt.wantErr)
+		}
+	}
+
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key", Listen: "0.0.0.0:0"}
+	if err := runServe(config); err == nil || !strings.Contains(err.Error(), "--allow-remote") {
+		t.Errorf("runServe() error = %v, want a refusal naming --allow-remote", err)
+	}
+
+	server, err := newWebServer(&Config{Listen: "0.0.0.0:8080", AllowRemote: true})
+	if err != nil {
+		t.Fatalf("newWebServer() error = %v", err)
+	}
+	if !server.allowedHost("192.168.1.5:8080") {
+		t.Error("allowedHost() should accept the IP address of the machine with --allow-remote")
+	}
+}
+
+// TestServeThumbnail tests that only raster thumbnails are proxied
+func TestServeThumbnail(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	var deleted []string
+	httpClient = serveMock(t, &deleted)
+
+	server, err := newWebServer(&Config{ImmichURL: "http://localhost:2283", APIKey: "test-key"})
+	if err != nil {
+		t.Fatalf("newWebServer() error = %v", err)
+	}
+	handler := server.routes()
+
+	rec := serveRequest(handler, "GET", "/api/assets/big/thumbnail", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" || rec.Body.String() != "jpeg" {
+		t.Errorf("thumbnail of big = %d %s %q", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	rec = serveRequest(handler, "GET", "/api/assets/small/thumbnail", "")
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("SVG thumbnail status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// tuiAction is what the terminal UI does with a duplicate group
+type tuiAction int
+
+const (
+	tuiPending tuiAction = iota // Not decided yet, left untouched
+	tuiDelete                   // Keep the keeper and delete the other assets
+	tuiStack                    // Stack the other assets behind the keeper
+	tuiIgnore                   // Reviewed and left untouched
+)
+
+func (a tuiAction) String() string {
+	switch a {
+	case tuiDelete:
+		return "delete"
+	case tuiStack:
+		return "stack"
+	case tuiIgnore:
+		return "ignore"
+	}
+	return "-"
+}
+
+// tuiGroup is a duplicate group listed in the terminal UI. Its albums and
+// ranking are loaded when the group is first opened or marked.
+type tuiGroup struct {
+	group     immich.DuplicateGroup
+	loaded    bool
+	albums    map[string][]immich.Album // Albums of each asset before synchronization
+	selection *Selection                // Policy ranking, nil if fewer than two assets could be compared
+	keeperID  string                    // Asset to keep, the policy's choice unless overridden
+	action    tuiAction
+	status    string // Outcome of the queued action once executed
+}
+
+// tuiView is a screen of the terminal UI
+type tuiView int
+
+const (
+	tuiList     tuiView = iota // Every duplicate group
+	tuiDetail                  // The assets of one group
+	tuiConfirm                 // Confirmation before executing the queued actions
+	tuiProgress                // Queued actions being executed
+	tuiDone                    // Execution finished
+)
+
+// tuiModel is the state of the terminal UI. It is independent of the
+// terminal: keys are fed to handleKey and screens are rendered as lines.
+type tuiModel struct {
+	groups      []*tuiGroup
+	load        func(*tuiGroup) // Fetches the albums and ranking of a group
+	dryRun      bool
+	deletion    string // How deleted assets are removed, shown before executing
+	view        tuiView
+	cursor      int // Selected group
+	offset      int // First group shown in the list
+	assetCursor int // Selected asset of the open group, in ranked order
+	message     string
+	quit        bool
+	execute     bool
+	done        int // Queued groups executed so far
+}
+
+func newTUIModel(duplicates []immich.DuplicateGroup, load func(*tuiGroup), config *Config) *tuiModel {
+	m := &tuiModel{load: load, dryRun: config.DryRun, deletion: deleteAction(config)}
+	for _, group := range duplicates {
+		m.groups = append(m.groups, &tuiGroup{group: group})
+	}
+	return m
+}
+
+// handleKey applies a key press to the model
+func (m *tuiModel) handleKey(key string) {
+	m.message = ""
+	if key == "ctrl-c" {
+		m.quit = true
+		return
+	}
+
+	switch m.view {
+	case tuiList:
+		m.handleListKey(key)
+	case tuiDetail:
+		m.handleDetailKey(key)
+	case tuiConfirm:
+		if key == "y" {
+			m.execute = true
+			return
+		}
+		m.view = tuiList
+		m.message = "Execution cancelled"
+	case tuiDone:
+		m.quit = true
+	}
+}
+
+func (m *tuiModel) handleListKey(key string) {
+	if len(m.groups) == 0 {
+		if key == "q" {
+			m.quit = true
+		}
+		return
+	}
+
+	switch key {
+	case "up", "k":
+		m.cursor = max(0, m.cursor-1)
+	case "down", "j":
+		m.cursor = min(len(m.groups)-1, m.cursor+1)
+	case "pgup":
+		m.cursor = max(0, m.cursor-10)
+	case "pgdown":
+		m.cursor = min(len(m.groups)-1, m.cursor+10)
+	case "enter", "right", "l":
+		m.open()
+	case "d", "s", "i", "u":
+		if m.mark(m.groups[m.cursor], key) {
+			m.cursor = min(len(m.groups)-1, m.cursor+1)
+		}
+	case "x":
+		deletions, stacks := m.queued()
+		if deletions+stacks == 0 {
+			m.message = "No deletions or stacks queued"
+			return
+		}
+		m.view = tuiConfirm
+	case "q":
+		m.quit = true
+	}
+}
+
+func (m *tuiModel) handleDetailKey(key string) {
+	g := m.groups[m.cursor]
+	ranked := 0
+	if g.selection != nil {
+		ranked = len(g.selection.Ranked)
+	}
+
+	switch key {
+	case "up", "k":
+		m.assetCursor = max(0, m.assetCursor-1)
+	case "down", "j":
+		m.assetCursor = max(0, min(ranked-1, m.assetCursor+1))
+	case "space", "enter":
+		if ranked == 0 {
+			m.message = "Not enough asset details to choose an asset to keep"
+			return
+		}
+		g.keeperID = g.selection.Ranked[m.assetCursor].ID
+		m.message = "Keeping " + truncateID(g.keeperID)
+	case "d", "s", "i", "u":
+		m.mark(g, key)
+	case "n", "pgdown":
+		if m.cursor < len(m.groups)-1 {
+			m.cursor++
+			m.open()
+		}
+	case "p", "pgup":
+		if m.cursor > 0 {
+			m.cursor--
+			m.open()
+		}
+	case "esc", "left", "h", "backspace", "q":
+		m.view = tuiList
+	}
+}
+
+// open shows the assets of the selected group
+func (m *tuiModel) open() {
+	m.ensureLoaded(m.groups[m.cursor])
+	m.view = tuiDetail
+	m.assetCursor = 0
+}
+
+// ensureLoaded fetches the albums and ranking of a group the first time
+func (m *tuiModel) ensureLoaded(g *tuiGroup) {
+	if g.loaded {
+		return
+	}
+	m.load(g)
+	g.loaded = true
+}
+
+// mark sets the action of a group for one of the keys d, s, i and u and
+// reports whether it was set
+func (m *tuiModel) mark(g *tuiGroup, key string) bool {
+	action := map[string]tuiAction{"d": tuiDelete, "s": tuiStack, "i": tuiIgnore, "u": tuiPending}[key]
+	if action == tuiDelete || action == tuiStack {
+		m.ensureLoaded(g)
+		if g.selection == nil {
+			m.message = "Not enough asset details to compare this group"
+			return false
+		}
+	}
+	g.action = action
+	return true
+}
+
+// queued counts the groups marked for deletion and for stacking
+func (m *tuiModel) queued() (deletions, stacks int) {
+	for _, g := range m.groups {
+		switch g.action {
+		case tuiDelete:
+			deletions++
+		case tuiStack:
+			stacks++
+		}
+	}
+	return deletions, stacks
+}
+
+// render draws the current screen as lines of at most width characters
+func (m *tuiModel) render(width, height int) []string {
+	var lines []string
+	switch m.view {
+	case tuiDetail:
+		lines = m.renderDetail()
+	case tuiProgress, tuiDone:
+		lines = m.renderProgress(height)
+	default:
+		lines = m.renderList(height)
+	}
+
+	for i, line := range lines {
+		lines[i] = fitLine(line, width)
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+func (m *tuiModel) renderList(height int) []string {
+	deletions, stacks := m.queued()
+	title := fmt.Sprintf("Immich duplicates: %d group(s), %d to delete, %d to stack", len(m.groups), deletions, stacks)
+	if m.dryRun {
+		title += "  [DRY RUN]"
+	}
+	lines := []string{title, "", "     #  Assets  Action  Keeper    Group"}
+
+	// Keep the selected group visible between the header and the footer
+	rows := max(1, height-len(lines)-3)
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	}
+	if m.cursor >= m.offset+rows {
+		m.offset = m.cursor - rows + 1
+	}
+	for i := m.offset; i < len(m.groups) && i < m.offset+rows; i++ {
+		g := m.groups[i]
+		pointer := " "
+		if i == m.cursor {
+			pointer = ">"
+		}
+		keeper := "-"
+		if g.keeperID != "" {
+			keeper = truncateID(g.keeperID)
+		}
+		lines = append(lines, fmt.Sprintf("%s %4d  %6d  %-6s  %-8s  %s", pointer, i+1, len(g.group.Assets), g.action, keeper, g.group.DuplicateID))
+	}
+	if len(m.groups) == 0 {
+		lines = append(lines, "  No duplicates found.")
+	}
+
+	lines = append(lines, "", m.message)
+	if m.view == tuiConfirm {
+		lines[len(lines)-1] = fmt.Sprintf("Execute %d deletion(s) (%s the other assets) and %d stack(s)? [y/N]", deletions, m.deletion, stacks)
+	}
+	return append(lines, "up/down move  enter open  d delete  s stack  i ignore  u undecided  x execute  q quit")
+}
+
+func (m *tuiModel) renderDetail() []string {
+	g := m.groups[m.cursor]
+	lines := []string{
+		fmt.Sprintf("Group %d/%d  %s  action: %s", m.cursor+1, len(m.groups), g.group.DuplicateID, g.action),
+		"",
+	}
+
+	if g.selection == nil {
+		lines = append(lines, "Not enough asset details to compare this group. Assets:")
+		for _, asset := range g.group.Assets {
+			lines = append(lines, "  "+asset.ID)
+		}
+	} else {
+		table := strings.Split(strings.TrimRight(formatReviewTable(g.selection.withKeeper(g.group, g.keeperID), g.albums), "\n"), "\n")
+		for i, row := range table {
+			pointer := "  "
+			if i == m.assetCursor+1 {
+				pointer = "> "
+			}
+			lines = append(lines, pointer+row)
+		}
+		lines = append(lines, "")
+		lines = append(lines, describeAsset(g.selection.Details[g.selection.Ranked[m.assetCursor].ID], g.albums)...)
+	}
+
+	return append(lines, "", m.message,
+		"up/down select  space keep selected  d delete  s stack  i ignore  u undecided  n/p next/previous group  esc back")
+}
+
+// describeAsset lists the details of an asset shown below the group's table
+func describeAsset(details *immich.AssetDetails, assetAlbums map[string][]immich.Album) []string {
+	lines := []string{"ID:       " + details.ID, "File:     " + details.OriginalFileName}
+	if exif := details.ExifInfo; exif != nil {
+		if exif.Make != "" || exif.Model != "" {
+			lines = append(lines, "Camera:   "+strings.TrimSpace(exif.Make+" "+exif.Model))
+		}
+		if gps := formatCoordinates(exif.Latitude, exif.Longitude); gps != "" {
+			lines = append(lines, "GPS:      "+gps)
+		}
+		if exif.Description != "" {
+			lines = append(lines, "Caption:  "+exif.Description)
+		}
+	}
+	if details.IsFavorite {
+		lines = append(lines, "Favorite: yes")
+	}
+
+	albums := assetAlbums[details.ID]
+	if len(albums) == 0 {
+		return append(lines, "Albums:   none")
+	}
+	for i, album := range albums {
+		label := "          "
+		if i == 0 {
+			label = "Albums:   "
+		}
+		lines = append(lines, label+album.AlbumName)
+	}
+	return lines
+}
+
+func (m *tuiModel) renderProgress(height int) []string {
+	var queued []*tuiGroup
+	for _, g := range m.groups {
+		if g.action == tuiDelete || g.action == tuiStack {
+			queued = append(queued, g)
+		}
+	}
+
+	const barWidth = 30
+	filled := 0
+	if len(queued) > 0 {
+		filled = barWidth * m.done / len(queued)
+	}
+	lines := []string{
+		fmt.Sprintf("Executing queued actions: %d/%d", m.done, len(queued)),
+		"[" + strings.Repeat("#", filled) + strings.Repeat(".", barWidth-filled) + "]",
+		"",
+	}
+
+	// Show the groups up to the one being executed
+	rows := max(1, height-len(lines)-2)
+	start := max(0, min(m.done+1, len(queued))-rows)
+	for i := start; i < len(queued) && i < start+rows; i++ {
+		status := queued[i].status
+		if status == "" {
+			status = "queued"
+		}
+		lines = append(lines, fmt.Sprintf("  %-6s %s  %s", queued[i].action, queued[i].group.DuplicateID, status))
+	}
+
+	if m.view == tuiDone {
+		lines = append(lines, "", "Finished. Press any key to exit.")
+	}
+	return lines
+}
+
+// fitLine cuts a line to the terminal width
+func fitLine(line string, width int) string {
+	runes := []rune(line)
+	if width <= 0 || len(runes) <= width {
+		return line
+	}
+	return string(runes[:width])
+}
+
+// runTUI lists the duplicate groups in a full-screen terminal UI and executes
+// the deletions and stacks queued in it
+func runTUI(config *Config) error {
+	logInfo("🔍 Fetching duplicate groups...")
+	duplicates, err := config.api().GetDuplicates(config.context())
+	if err != nil {
+		return fmt.Errorf("failed to fetch duplicates: %w", err)
+	}
+
+	// Loading a group logs its ranking, which is shown on screen instead
+	load := func(g *tuiGroup) {
+		quiet := *config
+		quiet.output = newGroupOutput()
+		g.albums = fetchAssetAlbums(&quiet, g.group)
+		selection, err := selectKeeper(&quiet, g.group, g.albums)
+		if err == nil && selection != nil {
+			g.selection = selection
+			g.keeperID = selection.KeeperID
+		}
+	}
+	m := newTUIModel(duplicates, load, config)
+
+	term, err := openTerminal()
+	if err != nil {
+		return err
+	}
+
+	// Hold back log output while the screen is in use
+	var logs bytes.Buffer
+	logOutput := log.Writer()
+	log.SetOutput(&logs)
+
+	stats := runTUILoop(config, m, term)
+
+	closeErr := term.close()
+	log.SetOutput(logOutput)
+	if _, err := logOutput.Write(logs.Bytes()); err != nil {
+		logError("Failed to write log output: %v", err)
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+
+	if stats != nil {
+		logInfo("\n🎉 Queued actions executed!")
+		printSummary(config, stats)
+		if config.RunID != "" {
+			logInfo("↩️  To revert this run: %s undo %s --url %s --api-key YOUR_KEY", os.Args[0], config.RunID, config.ImmichURL)
+		}
+	}
+	return nil
+}
+
+// runTUILoop handles keys until the user quits or executes the queued
+// actions. It returns the counters of the execution, nil if nothing ran.
+func runTUILoop(config *Config, m *tuiModel, term *terminal) *Stats {
+	for !m.quit && !m.execute {
+		term.draw(m.render(term.size()))
+		key, err := term.readKey()
+		if err != nil {
+			return nil
+		}
+		m.handleKey(key)
+	}
+	if !m.execute {
+		return nil
+	}
+
+	stats := executeTUI(config, m, func() { term.draw(m.render(term.size())) })
+	for !m.quit {
+		if _, err := term.readKey(); err != nil {
+			break
+		}
+		m.handleKey("")
+	}
+	return stats
+}
+
+// executeTUI synchronizes the albums of every group marked for deletion or
+// stacking, then resolves it with the chosen keeper. update is called after
+// each step so that progress can be shown.
+func executeTUI(config *Config, m *tuiModel, update func()) *Stats {
+	stats := &Stats{}
+	m.view = tuiProgress
+	m.done = 0
+
+	for i, g := range m.groups {
+		if g.action != tuiDelete && g.action != tuiStack {
+			continue
+		}
+		g.status = "running"
+		update()
+
+		config.logInfo("\n📁 Executing group %d/%d (%s)", i+1, len(m.groups), truncateID(g.group.DuplicateID))
+		if err := executeTUIGroup(config, stats, g); err != nil {
+			config.logError("Failed to process group %d: %v", i+1, err)
+			g.status = "failed: " + redact(err.Error())
+			stats.Failed++
+		} else {
+			g.status = "done"
+			stats.Groups++
+		}
+		m.done++
+	}
+
+	m.view = tuiDone
+	update()
+	return stats
+}
+
+// executeTUIGroup synchronizes the albums of a group and deletes or stacks
+// its other assets
+func executeTUIGroup(config *Config, stats *Stats, g *tuiGroup) error {
+	syncCount, _, err := synchronizeAlbums(config, g.group)
+	if err != nil {
+		return fmt.Errorf("album synchronization failed: %w", err)
+	}
+	stats.Synced += syncCount
+
+	selection := g.selection.withKeeper(g.group, g.keeperID)
+	if g.action == tuiStack {
+		return stackSelection(config, stats, selection)
+	}
+	return deleteSelection(config, stats, selection)
+}
+
+// terminal is the controlling terminal switched to raw mode and the
+// alternate screen. Modes are changed with stty, so a Unix terminal is
+// required.
+type terminal struct {
+	state string // Settings restored on close, as saved by stty -g
+}
+
+func openTerminal() (*terminal, error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("the terminal UI needs an interactive Unix terminal: %w", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("failed to switch the terminal to raw mode: %w", err)
+	}
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	return &terminal{state: state}, nil
+}
+
+// close leaves the alternate screen and restores the terminal settings
+func (t *terminal) close() error {
+	fmt.Print("\x1b[?25h\x1b[?1049l")
+	if _, err := stty(t.state); err != nil {
+		return fmt.Errorf("failed to restore the terminal: %w", err)
+	}
+	return nil
+}
+
+// size returns the width and height of the terminal
+func (t *terminal) size() (int, int) {
+	out, err := stty("size")
+	if err != nil {
+		return 80, 24
+	}
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return 80, 24
+	}
+	rows, rowsErr := strconv.Atoi(fields[0])
+	cols, colsErr := strconv.Atoi(fields[1])
+	if rowsErr != nil || colsErr != nil || rows <= 0 || cols <= 0 {
+		return 80, 24
+	}
+	return cols, rows
+}
+
+// draw replaces the screen with lines
+func (t *terminal) draw(lines []string) {
+	fmt.Print("\x1b[H\x1b[2J" + strings.Join(lines, "\r\n"))
+}
+
+// readKey waits for a key press
+func (t *terminal) readKey() (string, error) {
+	buf := make([]byte, 16)
+	n, err := os.Stdin.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return parseKey(buf[:n]), nil
+}
+
+// parseKey names the key sent by a terminal as the given bytes
+func parseKey(input []byte) string {
+	switch string(input) {
+	case "\x1b[A", "\x1bOA":
+		return "up"
+	case "\x1b[B", "\x1bOB":
+		return "down"
+	case "\x1b[C", "\x1bOC":
+		return "right"
+	case "\x1b[D", "\x1bOD":
+		return "left"
+	case "\x1b[5~":
+		return "pgup"
+	case "\x1b[6~":
+		return "pgdown"
+	case "\x1b":
+		return "esc"
+	case "\r", "\n":
+		return "enter"
+	case " ":
+		return "space"
+	case "\x7f", "\b":
+		return "backspace"
+	case "\x03":
+		return "ctrl-c"
+	}
+	return strings.ToLower(string(input))
+}
+
+// stty runs stty on the standard input and returns its output
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// stubLoad ranks the assets of a group in group order without any request
+func stubLoad(g *tuiGroup) {
+	if len(g.group.Assets) < 2 {
+		return
+	}
+	g.albums = map[string][]immich.Album{}
+	selection := &Selection{Details: map[string]*immich.AssetDetails{}}
+	for _, asset := range g.group.Assets {
+		selection.Details[asset.ID] = &immich.AssetDetails{ID: asset.ID, OriginalFileName: asset.ID + ".jpg"}
+		selection.Ranked = append(selection.Ranked, ScoredCandidate{ID: asset.ID})
+	}
+	selection.KeeperID = g.group.Assets[0].ID
+	selection.Others = []string{g.group.Assets[1].ID}
+	g.selection = selection
+	g.keeperID = selection.KeeperID
+}
+
+func tuiTestGroups() []immich.DuplicateGroup {
+	return []immich.DuplicateGroup{
+		{DuplicateID: "dup1", Assets: []immich.DuplicateAsset{{ID: "dup1-a"}, {ID: "dup1-b"}}},
+		{DuplicateID: "dup2", Assets: []immich.DuplicateAsset{{ID: "dup2-a"}, {ID: "dup2-b"}}},
+		{DuplicateID: "dup3", Assets: []immich.DuplicateAsset{{ID: "dup3-a"}}},
+	}
+}
+
+// TestTUIModel tests navigating, overriding the keeper and queueing actions
+func TestTUIModel(t *testing.T) {
+	m := newTUIModel(tuiTestGroups(), stubLoad, &Config{})
+
+	// Open the first group, keep its second asset and mark it for deletion
+	for _, key := range []string{"enter", "down", "space", "d", "esc"} {
+		m.handleKey(key)
+	}
+	if m.view != tuiList || m.groups[0].keeperID != "dup1-b" || m.groups[0].action != tuiDelete {
+		t.Fatalf("group 1 = keeper %s, action %s, want dup1-b, delete", m.groups[0].keeperID, m.groups[0].action)
+	}
+
+	// Marking from the list moves to the next group
+	m.handleKey("down")
+	m.handleKey("s")
+	if m.groups[1].action != tuiStack || m.cursor != 2 {
+		t.Errorf("group 2 action = %s, cursor %d, want stack, 2", m.groups[1].action, m.cursor)
+	}
+
+	// A group that cannot be compared cannot be queued, only ignored
+	m.handleKey("d")
+	if m.groups[2].action != tuiPending || m.message == "" {
+		t.Errorf("group 3 action = %s, message %q, want pending with a message", m.groups[2].action, m.message)
+	}
+	m.handleKey("i")
+	if m.groups[2].action != tuiIgnore {
+		t.Errorf("group 3 action = %s, want ignore", m.groups[2].action)
+	}
+
+	if deletions, stacks := m.queued(); deletions != 1 || stacks != 1 {
+		t.Errorf("queued() = %d, %d, want 1, 1", deletions, stacks)
+	}
+
+	// Execution is confirmed before it starts
+	m.handleKey("x")
+	m.handleKey("n")
+	if m.execute || m.view != tuiList {
+		t.Errorf("declined confirmation: execute = %v, view = %v", m.execute, m.view)
+	}
+	m.handleKey("x")
+	m.handleKey("y")
+	if !m.execute {
+		t.Error("confirmed execution was not started")
+	}
+}
+
+// TestTUIRender tests that every screen fits the terminal
+func TestTUIRender(t *testing.T) {
+	var groups []immich.DuplicateGroup
+	for i := 0; i < 50; i++ {
+		groups = append(groups, tuiTestGroups()[0])
+	}
+	m := newTUIModel(groups, stubLoad, &Config{DryRun: true})
+	for i := 0; i < 30; i++ {
+		m.handleKey("down")
+	}
+
+	for _, view := range []tuiView{tuiList, tuiDetail, tuiProgress} {
+		if view == tuiDetail {
+			m.open()
+		}
+		m.view = view
+		lines := m.render(40, 20)
+		if len(lines) > 20 {
+			t.Errorf("view %d has %d lines, want at most 20", view, len(lines))
+		}
+		for _, line := range lines {
+			if utf8.RuneCountInString(line) > 40 {
+				t.Errorf("view %d line %q is wider than 40", view, line)
+			}
+		}
+		if view == tuiList && !strings.Contains(strings.Join(lines, "\n"), ">   31") {
+			t.Errorf("list does not show the selected group 31:\n%s", strings.Join(lines, "\n"))
+		}
+	}
+}
+
+// TestExecuteTUI tests that queued groups are resolved with their keepers
+func TestExecuteTUI(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	var deleted []string
+	var stacked []string
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body := `[]`
+			switch {
+			case req.Method == "DELETE":
+				var request immich.DeleteAssetsRequest
+				if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+					t.Errorf("failed to decode delete request: %v", err)
+				}
+				deleted = append(deleted, request.IDs...)
+				return &http.Response{StatusCode: http.StatusNoContent, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+			case req.Method == "POST" && req.URL.Path == "/api/stacks":
+				var request immich.CreateStackRequest
+				if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+					t.Errorf("failed to decode stack request: %v", err)
+				}
+				stacked = request.AssetIDs
+				body = `{"id": "stack1"}`
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		},
+	}
+
+	config := &Config{ImmichURL: "http://localhost:2283", APIKey: "test-key"}
+	m := newTUIModel(tuiTestGroups(), stubLoad, config)
+	for _, key := range []string{"enter", "down", "space", "d", "esc", "down", "s", "i"} {
+		m.handleKey(key)
+	}
+
+	updates := 0
+	stats := executeTUI(config, m, func() { updates++ })
+
+	if !reflect.DeepEqual(deleted, []string{"dup1-a"}) {
+		t.Errorf("deleted = %v, want [dup1-a]", deleted)
+	}
+	if !reflect.DeepEqual(stacked, []string{"dup2-a", "dup2-b"}) {
+		t.Errorf("stacked = %v, want [dup2-a dup2-b]", stacked)
+	}
+	if stats.Groups != 2 || stats.Trashed != 1 || stats.Stacked != 1 {
+		t.Errorf("stats = %+v, want 2 groups, 1 trashed, 1 stacked", *stats)
+	}
+	if m.view != tuiDone || m.done != 2 || updates != 3 {
+		t.Errorf("view = %v, done = %d, updates = %d, want done view after 2 groups and 3 updates", m.view, m.done, updates)
+	}
+}
+
+// TestParseKey tests naming the keys sent by a terminal
+func TestParseKey(t *testing.T) {
+	tests := map[string]string{
+		"\x1b[A": "up",
+		"\x1bOB": "down",
+		"\x1b":   "esc",
+		"\r":     "enter",
+		" ":      "space",
+		"\x03":   "ctrl-c",
+		"D":      "d",
+	}
+	for input, want := range tests {
+		if got := parseKey([]byte(input)); got != want {
+			t.Errorf("parseKey(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
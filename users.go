@@ -0,0 +1,180 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// userResult is the outcome of processing the duplicates of one user
+type userResult struct {
+	name  string
+	stats *Stats // Counters of the user's run, nil if it did not complete
+	err   error  // Why the user could not be processed, nil otherwise
+}
+
+// loadUserConfigs builds the configuration of each user profile listed in
+// profiles. The flags in args are parsed again for every user so that they
+// apply to all of them, while each profile provides its own server and API
+// key. Reports and checkpoints get one file per user.
+func loadUserConfigs(args []string, profiles string) ([]*Config, error) {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(profiles, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("user %s is listed twice in --users", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("--users lists no profiles")
+	}
+
+	users := make([]*Config, 0, len(names))
+	keys := make(map[string]string)
+	for _, name := range names {
+		config := &Config{}
+		fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		registerFlags(fs, config)
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if config.Profile != "" {
+			return nil, fmt.Errorf("--profile cannot be used with --users")
+		}
+
+		config.Profile = name
+		if err := applyConfigSources(config, fs); err != nil {
+			return nil, fmt.Errorf("user %s: %w", name, err)
+		}
+		if !profileSetsAPIKey(config, name) {
+			return nil, fmt.Errorf("user %s: the profile must set its own api-key, api-key-file or api-key-command (a key given as a flag, in IMMICH_API_KEY or at the top level of the file would be used for every user)", name)
+		}
+		addSecret(config.APIKey)
+		if err := validateConfig(config); err != nil {
+			return nil, fmt.Errorf("user %s: %w", name, err)
+		}
+		if other, ok := keys[config.APIKey]; ok {
+			return nil, fmt.Errorf("users %s and %s have the same API key", other, name)
+		}
+		keys[config.APIKey] = name
+
+		config.Users = ""
+		config.user = name
+		config.ReportPath = userPath(config.ReportPath, name)
+		config.CheckpointPath = userPath(config.CheckpointPath, name)
+		users = append(users, config)
+	}
+	return users, nil
+}
+
+// profileSetsAPIKey reports whether the API key of config was taken from the
+// table of the named profile
+func profileSetsAPIKey(config *Config, profile string) bool {
+	for _, name := range apiKeySettings {
+		source, ok := config.sources[name]
+		if ok && source.file != "" && strings.HasPrefix(source.key, "profiles."+profile+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// userPath returns path with the user's name added before its extension, or
+// an empty path if path is empty
+func userPath(path, user string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + user + ext
+}
+
+// runUsers processes the duplicates of every user in turn, each with its own
+// counters, journal run and report, then prints a combined summary. It
+// returns the number of users that could not be processed.
+func runUsers(users []*Config) int {
+	logInfo("🚀 Starting Immich Duplicate Cleaner v%s for %d user(s)", version, len(users))
+
+	results := make([]userResult, len(users))
+	owners := make(map[string]string)
+	for i, config := range users {
+		results[i].name = config.user
+		logInfo("\n👤 User %d/%d: %s (%s)", i+1, len(users), config.user, config.ImmichURL)
+
+		stats, err := processUser(config, owners)
+		results[i].stats, results[i].err = stats, err
+		if err != nil {
+			logError("Failed to process user %s: %v", config.user, err)
+		}
+
+		if config.review.stopped() {
+			logInfo("\n⏹️  Review stopped - remaining users were left untouched")
+			break
+		}
+	}
+
+	printUsersSummary(users[0], results)
+
+	failed := 0
+	for _, result := range results {
+		if result.err != nil {
+			failed++
+		}
+	}
+	return failed
+}
+
+// processUser checks the user's server and API key and identifies its owner,
+// so that only albums of that user are added to, then processes the user's
+// duplicates.
+// owners maps the accounts already processed to their profile.
+func processUser(config *Config, owners map[string]string) (*Stats, error) {
+	if config.DryRun {
+		logWarning("⚠️  DRY RUN MODE - No changes will be made for user %s", config.user)
+	}
+	if err := checkServer(config, ""); err != nil {
+		return nil, err
+	}
+
+	user, err := config.api().GetCurrentUser(config.context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to identify the user: %w", err)
+	}
+	if other, ok := owners[user.ID]; ok {
+		return nil, fmt.Errorf("the API key belongs to %s, already processed as user %s", user.Email, other)
+	}
+	owners[user.ID] = config.user
+	config.ownerID = user.ID
+	logInfo("🔑 Signed in as %s <%s>", user.Name, user.Email)
+
+	return processDuplicates(config)
+}
+
+// printUsersSummary prints the outcome of every user and the combined counters
+func printUsersSummary(config *Config, results []userResult) {
+	logInfo("\n📊 Combined summary for %d user(s):", len(results))
+	total := &Stats{}
+	for _, result := range results {
+		switch {
+		case result.err != nil:
+			logInfo("   ❌ %s: failed: %v", result.name, result.err)
+		case result.stats == nil:
+			logInfo("   ⏭️  %s: not processed", result.name)
+		default:
+			logInfo("   ✅ %s: %d group(s) processed, %d failed, %d asset(s) synchronized",
+				result.name, result.stats.Groups, result.stats.Failed, result.stats.Synced)
+			total.add(*result.stats)
+		}
+	}
+	printSummary(config, total)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"immich-duplicate-cleaner/immich"
+)
+
+// writeUsersConfig writes a configuration file with a profile per user
+func writeUsersConfig(t *testing.T, content string) string {
+	t.Helper()
+	t.Setenv("IMMICH_URL", "")
+	t.Setenv("IMMICH_API_KEY", "")
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadUserConfigs tests building the configuration of each user
+func TestLoadUserConfigs(t *testing.T) {
+	path := writeUsersConfig(t, `url = "http://localhost:2283"
+policy = "balanced"
+
+[profiles.alice]
+api-key = "alice-key-123"
+
+[profiles.bob]
+url = "http://bob.example:2283"
+api-key = "bob-key-4567"
+policy = "metadata"
+max-attempts = 8
+
+[profiles.shared]
+api-key = "alice-key-123"
+`)
+
+	users, err := loadUserConfigs([]string{"--config", path, "-d", "--report", "report.json", "--checkpoint", ""}, "alice, bob")
+	if err != nil {
+		t.Fatalf("loadUserConfigs() error = %v", err)
+	}
+	if len(users) != 2 {
+		t.Fatalf("loadUserConfigs() returned %d user(s), want 2", len(users))
+	}
+
+	alice, bob := users[0], users[1]
+	if alice.user != "alice" || alice.ImmichURL != "http://localhost:2283" || alice.APIKey != "alice-key-123" || alice.Policy != "balanced" {
+		t.Errorf("alice = %s %s %s %s", alice.user, alice.ImmichURL, alice.APIKey, alice.Policy)
+	}
+	if bob.user != "bob" || bob.ImmichURL != "http://bob.example:2283" || bob.APIKey != "bob-key-4567" || bob.Policy != "metadata" {
+		t.Errorf("bob = %s %s %s %s", bob.user, bob.ImmichURL, bob.APIKey, bob.Policy)
+	}
+	if alice.MaxAttempts != defaultMaxAttempts || bob.MaxAttempts != 8 {
+		t.Errorf("max attempts = %d %d, want %d and the profile's 8", alice.MaxAttempts, bob.MaxAttempts, defaultMaxAttempts)
+	}
+	if !alice.AutoDelete || !bob.AutoDelete {
+		t.Error("flags should apply to every user")
+	}
+	if alice.ReportPath != "report-alice.json" || bob.ReportPath != "report-bob.json" || alice.CheckpointPath != "" {
+		t.Errorf("paths = %q %q %q, want one report per user and no checkpoint", alice.ReportPath, bob.ReportPath, alice.CheckpointPath)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		users   string
+		wantErr string
+	}{
+		{"same key", nil, "alice,shared", "users alice and shared have the same API key"},
+		{"listed twice", nil, "alice,alice", "user alice is listed twice"},
+		{"key flag", []string{"-k", "flag-key-890"}, "alice", "user alice: the profile must set its own api-key"},
+		{"unknown profile", nil, "carol", `user carol: ` + path + `: profile "carol" not found`},
+		{"profile flag", []string{"--profile", "bob"}, "alice", "--profile cannot be used with --users"},
+		{"empty", nil, " , ", "--users lists no profiles"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadUserConfigs(append([]string{"--config", path}, tt.args...), tt.users)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("loadUserConfigs() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestRunUsers tests processing each user with isolated counters and without
+// adding assets to albums owned by another user
+func TestRunUsers(t *testing.T) {
+	oldClient := httpClient
+	defer func() { httpClient = oldClient }()
+
+	added := map[string][]string{}
+	httpClient = &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			user := strings.TrimSuffix(req.Header.Get("x-api-key"), "-key")
+			body := `[]`
+			switch {
+			case req.URL.Path == "/api/server/version":
+				body = `{"major": 1, "minor": 120, "patch": 0}`
+			case req.URL.Path == "/api/server/ping" || req.URL.Path == "/api/server/config":
+				body = `{}`
+			case req.URL.Path == "/api/api-keys/me":
+				return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(bytes.NewBufferString(`{}`))}, nil
+			case req.URL.Path == "/api/users/me":
+				if user == "carol" {
+					return &http.Response{StatusCode: http.StatusUnauthorized, Body: io.NopCloser(bytes.NewBufferString(`{"message":"Invalid API key"}`))}, nil
+				}
+				body = `{"id": "` + user + `-id", "name": "` + user + `", "email": "` + user + `@example.com"}`
+			case req.URL.Path == "/api/duplicates" && user == "bob":
+				body = `[{"duplicateId": "dup1", "assets": [{"id": "b1"}, {"id": "b2"}]}]`
+			case req.URL.Path == "/api/albums" && req.URL.Query().Get("assetId") == "b1":
+				// An album alice shared with bob
+				body = `[{"id": "alice-album", "albumName": "Family", "ownerId": "alice-id"}]`
+			case req.URL.Path == "/api/albums" && req.URL.Query().Get("assetId") == "b2":
+				body = `[{"id": "bob-album", "albumName": "Trips", "ownerId": "bob-id"}]`
+			case req.Method == "PUT":
+				var request immich.BulkIDsRequest
+				if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+					t.Errorf("failed to decode album addition: %v", err)
+				}
+				added[req.URL.Path] = append(added[req.URL.Path], request.IDs...)
+				body = `[{"id": "b1", "success": true}]`
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewBufferString(body))}, nil
+		},
+	}
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	users := []*Config{
+		{ImmichURL: "http://localhost:2283", APIKey: "alice-key", user: "alice", DryRun: true},
+		{ImmichURL: "http://localhost:2283", APIKey: "dave-key", user: "dave", Resume: true, CheckpointPath: filepath.Join(t.TempDir(), "missing.jsonl")},
+		{ImmichURL: "http://localhost:2283", APIKey: "bob-key", user: "bob"},
+		{ImmichURL: "http://localhost:2283", APIKey: "carol-key", user: "carol"},
+	}
+	if failed := runUsers(users); failed != 2 {
+		t.Errorf("runUsers() failed = %d, want 2 (dave without a checkpoint to resume, and carol)", failed)
+	}
+	if !strings.Contains(output.String(), "dave: failed: failed to resume: no checkpoint found") {
+		t.Errorf("the summary should report dave's failure, got:\n%s", output.String())
+	}
+	if !strings.Contains(output.String(), "DRY RUN MODE - No changes will be made for user alice") || strings.Contains(output.String(), "for user bob") {
+		t.Errorf("the dry run banner should only be printed for alice, got:\n%s", output.String())
+	}
+
+	want := map[string][]string{"/api/albums/bob-album/assets": {"b1"}}
+	if !reflect.DeepEqual(added, want) {
+		t.Errorf("album additions = %v, want %v", added, want)
+	}
+	if users[0].ownerID != "alice-id" || users[2].ownerID != "bob-id" {
+		t.Errorf("owners = %q %q", users[0].ownerID, users[2].ownerID)
+	}
+}
+
+// TestUserPath tests the per-user file names
+func TestUserPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"", ""},
+		{"report.json", "report-bob.json"},
+		{"/var/lib/cleaner/checkpoint.jsonl", "/var/lib/cleaner/checkpoint-bob.jsonl"},
+		{"report", "report-bob"},
+	}
+	for _, tt := range tests {
+		if got := userPath(tt.path, "bob"); got != tt.want {
+			t.Errorf("userPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}